@@ -1,39 +1,53 @@
 package main
 
 import (
-	"crypto/sha256"
+	"crypto/ed25519"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
 	"net"
-	"net/rpc"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/korkmazkadir/rapidchain/common"
 	"github.com/korkmazkadir/rapidchain/consensus"
+	"github.com/korkmazkadir/rapidchain/mempool"
 	"github.com/korkmazkadir/rapidchain/network"
 	"github.com/korkmazkadir/rapidchain/registery"
+	"github.com/korkmazkadir/rapidchain/store"
 )
 
+// payload size of the transactions created by leaders
+const transactionPayloadSize = 512
+
 func main() {
 
 	hostname := getEnvWithDefault("NODE_HOSTNAME", "127.0.0.1")
 	registryAddress := getEnvWithDefault("REGISTRY_ADDRESS", "localhost:1234")
+	blockStorePath := getEnvWithDefault("BLOCK_STORE_PATH", "blocks.db")
+	keyPath := getEnvWithDefault("KEY_PATH", filepath.Join(filepath.Dir(blockStorePath), "node.key"))
+	nodesPath := getEnvWithDefault("NODES_PATH", filepath.Join(filepath.Dir(blockStorePath), "nodes.json"))
+	apiAddress := getEnvWithDefault("API_ADDRESS", fmt.Sprintf("%s:", hostname))
 
-	demux := common.NewDemultiplexer(0)
-	server := network.NewServer(demux)
-
-	err := rpc.Register(server)
+	// decided rounds of the previous runs are reloaded
+	blockStore, err := store.Open(blockStorePath)
 	if err != nil {
 		panic(err)
 	}
+	defer blockStore.Close()
+	log.Printf("block store opened, last decided round is %d\n", blockStore.LastRound())
+
+	demux := common.NewDemultiplexer(blockStore.LastRound())
+	server := network.NewServer(demux, blockStore)
 
-	rpc.HandleHTTP()
 	l, e := net.Listen("tcp", fmt.Sprintf("%s:", hostname))
 	if e != nil {
 		log.Fatal("listen error:", e)
@@ -43,15 +57,18 @@ func main() {
 	go func() {
 		for {
 			conn, _ := l.Accept()
-			go func() {
-				rpc.ServeConn(conn)
-			}()
+			go server.ServeConn(conn)
 		}
 	}()
 
 	log.Printf("p2p server started on %s\n", l.Addr().String())
 	nodeInfo := getNodeInfo(l.Addr().String())
 
+	// the node registers its public key, the other nodes accept only the messages signed by the registered keys.
+	// The key is kept next to the block store, a restarted node registers the same key again.
+	privateKey := loadOrCreateKey(keyPath)
+	nodeInfo.PublicKey = privateKey.Public().(ed25519.PublicKey)
+
 	registry := registery.NewRegistryClient(registryAddress, nodeInfo)
 
 	nodeInfo.ID = registry.RegisterNode()
@@ -59,6 +76,26 @@ func main() {
 
 	nodeConfig := registry.GetConfig()
 
+	// each committee runs its own shard chain with its own demux and store
+	first := &shardChain{shard: 0, demux: demux, blockStore: blockStore}
+	shards := openShardChains(first, blockStorePath, server, nodeConfig.CommitteeCount)
+	for _, sc := range shards[1:] {
+		defer sc.blockStore.Close()
+	}
+
+	for _, sc := range shards {
+		configureQueues(sc.demux, nodeConfig)
+		if nodeConfig.FutureRoundWindow > 0 {
+			sc.demux.SetFutureRoundWindow(nodeConfig.FutureRoundWindow)
+		}
+
+		deduplicator, err := common.NewDeduplicator(nodeConfig.DedupBackend, nodeConfig.DedupCapacity, nodeConfig.DedupFalsePositiveRate)
+		if err != nil {
+			panic(err)
+		}
+		sc.demux.SetDeduplicator(deduplicator)
+	}
+
 	var nodeList []registery.NodeInfo
 
 	for {
@@ -71,11 +108,52 @@ func main() {
 		log.Printf("received node list %d/%d\n", nodeCount, nodeConfig.NodeCount)
 	}
 
-	peerSet := createPeerSet(nodeList, nodeConfig.GossipFanout, nodeInfo)
+	validators, err := registery.NewValidatorSet(nodeList)
+	if err != nil {
+		panic(err)
+	}
+
+	// the node list is kept next to the block store, the stores are audited against the registered keys
+	saveNodeList(nodesPath, nodeList)
+
+	n := &node{config: nodeConfig, info: nodeInfo, privateKey: privateKey, nodes: validatorNodes(nodeList, validators), validators: validators, shards: shards}
+
+	// the validators are assigned to the committees of each epoch by the seeds of the reference chain
+	if nodeConfig.IsShardingEnabled() {
+		n.committees = consensus.NewCommittees(nodeConfig, validators, func(members []int) network.PeerSet {
+			return createPeerSet(n.membersOf(members), nodeConfig.GossipFanout, nodeInfo, common.ReferenceCommittee)
+		})
+	}
+
+	mempoolOrdering, err := mempool.ParseOrdering(nodeConfig.MempoolOrdering)
+	if err != nil {
+		panic(err)
+	}
+	n.pool = mempool.NewMempool(nodeConfig.MempoolSize, nodeConfig.MempoolBytes, mempoolOrdering)
+
+	// the blocks that replay the transactions committed on the stored chains are not valid
 	statLogger := common.NewStatLogger(nodeInfo.ID)
-	rapidchain := consensus.NewRapidchain(demux, nodeConfig, peerSet, statLogger)
+	for _, sc := range shards {
+		sc.consensus = consensus.NewRapidchain(sc.demux, nodeConfig, network.PeerSet{Shard: sc.shard}, privateKey, validators, statLogger)
+		sc.consensus.SetCommitted(n.pool.IsCommitted)
+		if n.committees != nil {
+			sc.consensus.SetCommittee(n.committees.Committee(sc.shard))
+		}
+	}
+	n.restore()
 
-	runConsensus(rapidchain, nodeConfig.EndRound, nodeInfo.ID, nodeConfig.NodeCount, nodeConfig.LeaderCount, nodeConfig.BlockSize, nodeList)
+	verifier := configureVerification(shards, nodeConfig)
+
+	n.apiServer = network.NewAPIServer(nodeInfo.ID, nodeConfig, first.demux, verifier, server, &network.PeerSet{}, first.blockStore, first.consensus.Beacon(), first.consensus.KnownValidatorsOf, statLogger)
+	startAPIServer(apiAddress, n.apiServer)
+
+	// clients submit transactions using the same address with the p2p server, the leaders of any committee include them
+	err = server.RegisterService("TxServer", network.NewTxServer(n.pool))
+	if err != nil {
+		panic(err)
+	}
+
+	runConsensus(n)
 
 	// collects stats abd uploads to registry
 	log.Printf("uploading stats to the registry\n")
@@ -89,7 +167,8 @@ func main() {
 	log.Printf("exiting as expected...\n")
 }
 
-func createPeerSet(nodeList []registery.NodeInfo, fanOut int, nodeInfo registery.NodeInfo) network.PeerSet {
+// createPeerSet connects to fanOut random nodes of the list, the messages are sent in the namespace of the shard
+func createPeerSet(nodeList []registery.NodeInfo, fanOut int, nodeInfo registery.NodeInfo, shard int) network.PeerSet {
 
 	var copyNodeList []registery.NodeInfo
 	copyNodeList = append(copyNodeList, nodeList...)
@@ -97,7 +176,7 @@ func createPeerSet(nodeList []registery.NodeInfo, fanOut int, nodeInfo registery
 	rand.Seed(time.Now().UnixNano())
 	rand.Shuffle(len(copyNodeList), func(i, j int) { copyNodeList[i], copyNodeList[j] = copyNodeList[j], copyNodeList[i] })
 
-	peerSet := network.PeerSet{}
+	peerSet := network.PeerSet{Shard: shard}
 
 	peerCount := 0
 	for i := 0; i < len(copyNodeList); i++ {
@@ -122,6 +201,94 @@ func createPeerSet(nodeList []registery.NodeInfo, fanOut int, nodeInfo registery
 	return peerSet
 }
 
+// configureQueues applies the queue capacity and the overflow policies of the config to the queues of the registered message kinds
+func configureQueues(demux *common.Demux, nodeConfig registery.NodeConfig) {
+
+	defaultPolicy, err := common.ParseOverflowPolicy(nodeConfig.QueueOverflowPolicy)
+	if err != nil {
+		panic(err)
+	}
+
+	kinds := demux.Kinds()
+	registered := make(map[common.MessageKind]bool)
+	for _, kind := range kinds {
+		registered[kind] = true
+	}
+
+	policies := make(map[common.MessageKind]common.OverflowPolicy)
+	for name, policyName := range nodeConfig.QueueOverflowPolicies {
+		kind := common.MessageKind(name)
+		if !registered[kind] {
+			panic(fmt.Errorf("unknown message kind %q", name))
+		}
+
+		policies[kind], err = common.ParseOverflowPolicy(policyName)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for _, kind := range kinds {
+		config := common.QueueConfig{Capacity: nodeConfig.QueueCapacity, Policy: defaultPolicy}
+		if config.Capacity == 0 {
+			config.Capacity = common.DefaultQueueCapacity
+		}
+
+		if policy, ok := policies[kind]; ok {
+			config.Policy = policy
+		}
+
+		demux.SetQueueConfig(kind, config)
+		log.Printf("%s queue capacity is %d, overflow policy is %s\n", kind, config.Capacity, config.Policy)
+	}
+}
+
+// configureVerification verifies the received messages on a pool of workers before they are enqueued to the demuxes of the shards.
+// The messages that are not issued by the validators of their rounds are rejected before their signatures are verified,
+// the consensus of each shard provides the members of its committee in a round.
+func configureVerification(shards []*shardChain, nodeConfig registery.NodeConfig) *common.Verifier {
+
+	workerCount := nodeConfig.VerificationWorkers
+	if workerCount == 0 {
+		workerCount = runtime.NumCPU()
+	}
+
+	verifier := common.NewVerifier(workerCount, nodeConfig.VerificationCacheSize)
+	for _, sc := range shards {
+		rc := sc.consensus
+		validate := func(message common.Message) error {
+			if err := rc.CheckMessage(message); err != nil {
+				return err
+			}
+			return verifier.Verify(message)
+		}
+
+		for _, kind := range verifier.Kinds() {
+			sc.demux.SetValidator(kind, validate)
+		}
+	}
+
+	log.Printf("%d verification workers, verification cache size is %d\n", workerCount, nodeConfig.VerificationCacheSize)
+
+	return verifier
+}
+
+// startAPIServer serves the read-only HTTP JSON API in the background
+func startAPIServer(address string, apiServer *network.APIServer) {
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatal("listen error:", err)
+	}
+
+	go func() {
+		err := apiServer.Serve(l)
+		log.Printf("http api server stopped: %s\n", err)
+	}()
+
+	log.Printf("http api server started on %s\n", l.Addr().String())
+}
+
 func getNodeInfo(netAddress string) registery.NodeInfo {
 	tokens := strings.Split(netAddress, ":")
 
@@ -134,86 +301,200 @@ func getNodeInfo(netAddress string) registery.NodeInfo {
 	return registery.NodeInfo{IPAddress: ipAddress, PortNumber: portNumber}
 }
 
-func runConsensus(rc *consensus.RapidchainConsensus, numberOfRounds int, nodeID int, nodeCount int, leaderCount int, blockSize int, nodeList []registery.NodeInfo) {
+func runConsensus(n *node) {
 
 	time.Sleep(5 * time.Second)
 	log.Println("Consensus started")
 
-	// genesis block
-	previousBlock := []common.Block{{Issuer: []byte("initial block"), Round: 0, Payload: []byte("hello world")}}
-
 	currentRound := 1
-	for currentRound <= numberOfRounds {
+
+	// continues from the last decided round of the previous run, a restarted node catches up with its peers before joining the current round
+	catchUp := false
+	for _, sc := range n.shards {
+		if lastRound := sc.blockStore.LastRound(); lastRound >= currentRound {
+			currentRound = lastRound + 1
+			catchUp = true
+			log.Printf("continuing from the stored round %d of shard %d\n", lastRound, sc.shard)
+		}
+	}
+
+	var sc *shardChain
+	for currentRound <= n.config.EndRound {
+
+		// the node runs the chain of its committee in the epoch of the round, a node that joins another chain catches up first
+		if next := n.chainOf(currentRound); next != sc || next.epoch != n.epochOf(currentRound) {
+			catchUp = catchUp || (sc != nil && next != sc)
+			sc = next
+			n.join(sc, currentRound)
+		}
+
+		rc := sc.consensus
+
+		if catchUp || rc.IsBehind(currentRound) {
+			n.catchUp(sc, currentRound)
+			currentRound = sc.blockStore.LastRound() + 1
+			catchUp = false
+
+			// the chain may have reached an epoch in which the node is a member of another committee
+			if currentRound > n.config.EndRound || n.chainOf(currentRound) != sc || sc.epoch != n.epochOf(currentRound) {
+				continue
+			}
+		}
 
 		log.Printf("+++++++++ Round %d +++++++++++++++\n", currentRound)
 
-		var block []common.Block
+		var decidedRound common.DecidedRound
+
+		// the leaders elected by VRF are not known before they propose
+		if leaders := rc.Leaders(currentRound); leaders != nil {
+			log.Printf("elected leaders are the validators %v\n", leaders)
+		}
 
-		if isElectedAsLeader(nodeList, currentRound, nodeID, leaderCount) {
+		if rc.IsLeader(currentRound) {
 			log.Println("elected as leader")
-			b := createBlock(currentRound, nodeID, hashBlock(previousBlock), blockSize, leaderCount)
+			b := createBlock(n.pool, currentRound, n.info.PublicKey, sc.previousBlockHash, n.config.BlockSize, n.config.LeaderCount, n.config.SyntheticLoad)
 
-			block = rc.Propose(currentRound, b, hashBlock(previousBlock))
+			decidedRound = rc.Propose(currentRound, b, sc.previousBlockHash)
 
 		} else {
 
-			block = rc.Decide(currentRound, hashBlock(previousBlock))
+			decidedRound = rc.Decide(currentRound, sc.previousBlockHash)
 
 		}
 
-		payloadSize := 0
-		for i := range block {
-			payloadSize += len(block[i].Payload)
-		}
-
-		log.Printf("appended payload size is %d bytes\n", payloadSize)
-
-		previousBlock = block
+		n.appendDecidedRound(sc, decidedRound)
 		//log.Printf("decided block hash %x\n", encodeBase64(block.Hash()[:15]))
 
 		currentRound++
 		//time.Sleep(2 * time.Second)
 
-		log.Printf("Appended block: %x\n", encodeBase64(hashBlock(block)[:15]))
+		log.Printf("Appended block: %x\n", encodeBase64(sc.previousBlockHash[:15]))
 
 	}
 
 }
 
-func hashBlock(blocks []common.Block) []byte {
+// storedBlockHash returns the hash of the last stored round that is not empty, the empty rounds do not change the hash
+func storedBlockHash(blockStore *store.BlockStore) []byte {
 
-	if len(blocks) == 1 {
-		return blocks[0].Hash()
+	firstRound := blockStore.FirstRound()
+	for round := blockStore.LastRound(); round >= firstRound && round > 0; round-- {
+		decidedRound, err := blockStore.Get(round)
+		if err != nil {
+			panic(err)
+		}
+
+		if !decidedRound.IsEmpty() {
+			return common.HashBlocks(decidedRound.Blocks)
+		}
+	}
+
+	return common.HashBlocks(common.GenesisBlocks())
+}
+
+// restoreCommitted commits the transactions of the stored rounds in the mempool, the clients can not replay them after a restart
+func restoreCommitted(pool *mempool.Mempool, blockStore *store.BlockStore) {
+
+	for round := blockStore.FirstRound(); round <= blockStore.LastRound() && round > 0; round++ {
+		decidedRound, err := blockStore.Get(round)
+		if err != nil {
+			panic(fmt.Errorf("could not restore the committed transactions: %w", err))
+		}
+
+		for i := range decidedRound.Blocks {
+			transactions, err := decidedRound.Blocks[i].Transactions()
+			if err != nil {
+				panic(err)
+			}
+
+			pool.Commit(transactions)
+		}
 	}
+}
+
+// restoreBeacon replays the stored rounds on the beacon to derive the seed of the current epoch
+func restoreBeacon(beacon *common.Beacon, blockStore *store.BlockStore) {
+
+	for round := 1; round <= blockStore.LastRound(); round++ {
+		decidedRound, err := blockStore.Get(round)
+		if err != nil {
+			panic(fmt.Errorf("could not restore the beacon: %w", err))
+		}
 
-	var hashSlice []byte
-	for i := range blocks {
-		hashSlice = append(hashSlice, blocks[i].Hash()...)
+		if err := beacon.AddRound(decidedRound); err != nil {
+			panic(err)
+		}
 	}
+}
+
+// appendDecidedRound stores a decided round, and commits its transactions in the mempool
+func appendDecidedRound(decidedRound common.DecidedRound, blockStore *store.BlockStore, pool *mempool.Mempool) {
 
-	h := sha256.New()
-	_, err := h.Write(hashSlice)
+	err := blockStore.Append(decidedRound)
 	if err != nil {
 		panic(err)
 	}
 
-	return h.Sum(nil)
+	payloadSize := 0
+	transactionCount := 0
+	for i := range decidedRound.Blocks {
+		payloadSize += len(decidedRound.Blocks[i].Payload)
+
+		transactions, err := decidedRound.Blocks[i].Transactions()
+		if err != nil {
+			panic(err)
+		}
+		transactionCount += len(transactions)
+
+		// transactions of the decided round are evicted from the mempool, and they are not accepted again
+		pool.Commit(transactions)
+	}
+
+	log.Printf("appended payload size is %d bytes, transaction count is %d\n", payloadSize, transactionCount)
 }
 
 // utils
 
-func createBlock(round int, nodeID int, previousBlockHash []byte, blockSize int, leaderCount int) common.Block {
+// createBlock fills a block with the transactions from the mempool up to the share of the leader from the block size.
+// If synthetic load is enabled, the rest of the share is filled with generated transactions.
+func createBlock(pool *mempool.Mempool, round int, issuer []byte, previousBlockHash []byte, blockSize int, leaderCount int, syntheticLoad bool) common.Block {
 
 	payloadSize := int(math.Ceil(float64(blockSize) / float64(leaderCount)))
 
-	block := common.Block{
-		Round:         round,
-		Issuer:        []byte{byte(nodeID)},
-		Payload:       getRandomByteSlice(payloadSize),
-		PrevBlockHash: previousBlockHash,
+	transactions := pool.Take(payloadSize)
+	log.Printf("%d transactions taken from the mempool, %d transactions are waiting\n", len(transactions), pool.Len())
+
+	if syntheticLoad {
+		size := 0
+		for i := range transactions {
+			size += transactions[i].Size()
+		}
+		transactions = append(transactions, createTransactions(payloadSize-size)...)
 	}
 
-	return block
+	return common.NewBlock(issuer, previousBlockHash, round, transactions)
+}
+
+// createTransactions creates signed transactions that fit into the payload size
+func createTransactions(payloadSize int) []common.Transaction {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	var transactions []common.Transaction
+	size := 0
+	for nonce := uint64(0); size < payloadSize; nonce++ {
+		tx := common.NewTransaction(privateKey, nonce, 0, getRandomByteSlice(transactionPayloadSize))
+		if size+tx.Size() > payloadSize {
+			break
+		}
+		transactions = append(transactions, tx)
+		size += tx.Size()
+	}
+
+	return transactions
 }
 
 func encodeBase64(hex []byte) string {
@@ -229,33 +510,55 @@ func getRandomByteSlice(size int) []byte {
 	return data
 }
 
-func getEnvWithDefault(key string, defaultValue string) string {
-	val := os.Getenv(key)
-	if len(val) == 0 {
-		val = defaultValue
+// loadOrCreateKey loads the private key of the node from the file, the key is created and saved if the file does not exist
+func loadOrCreateKey(path string) ed25519.PrivateKey {
+
+	seed, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(seed) != ed25519.SeedSize {
+			panic(fmt.Errorf("key file %s has %d bytes, expected %d", path, len(seed), ed25519.SeedSize))
+		}
+
+		log.Printf("key is loaded from %s\n", path)
+		return ed25519.NewKeyFromSeed(seed)
 	}
 
-	log.Printf("%s=%s\n", key, val)
-	return val
+	if !os.IsNotExist(err) {
+		panic(err)
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(path, privateKey.Seed(), 0600); err != nil {
+		panic(err)
+	}
+	log.Printf("key is created and saved to %s\n", path)
+
+	return privateKey
 }
 
-func isElectedAsLeader(nodeList []registery.NodeInfo, round int, nodeID int, leaderCount int) bool {
+// saveNodeList writes the registered nodes to the file as JSON
+func saveNodeList(path string, nodeList []registery.NodeInfo) {
 
-	// assumes that node list is same for all nodes
-	// shuffle the node list using round number as the source of randomness
-	rand.Seed(int64(round))
-	rand.Shuffle(len(nodeList), func(i, j int) { nodeList[i], nodeList[j] = nodeList[j], nodeList[i] })
+	data, err := json.MarshalIndent(nodeList, "", "  ")
+	if err != nil {
+		panic(err)
+	}
 
-	var electedLeaders []int
-	for i := 0; i < leaderCount; i++ {
-		electedLeaders = append(electedLeaders, nodeList[i].ID)
-		if nodeList[i].ID == nodeID {
-			log.Println("elected as leader")
-			return true
-		}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		panic(err)
 	}
+}
 
-	log.Printf("Elected leaders: %v\n", electedLeaders)
+func getEnvWithDefault(key string, defaultValue string) string {
+	val := os.Getenv(key)
+	if len(val) == 0 {
+		val = defaultValue
+	}
 
-	return false
+	log.Printf("%s=%s\n", key, val)
+	return val
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/consensus"
+	"github.com/korkmazkadir/rapidchain/mempool"
+	"github.com/korkmazkadir/rapidchain/network"
+	"github.com/korkmazkadir/rapidchain/registery"
+	"github.com/korkmazkadir/rapidchain/store"
+)
+
+// the connections to the peers of a previous epoch are kept for a while, so that the last messages of the epoch are delivered
+const peerSetCloseDelay = time.Minute
+
+// shardChain is a shard chain stored and served by the node.
+// The node runs the consensus of a shard chain while it is a member of the committee of the chain.
+type shardChain struct {
+	shard      int
+	demux      *common.Demux
+	blockStore *store.BlockStore
+	consensus  *consensus.RapidchainConsensus
+
+	// peers of the committee in the epoch that the node joined
+	peerSet *network.PeerSet
+	epoch   int
+
+	// hash of the last decided round, the blocks of the next round extend it
+	previousBlockHash []byte
+}
+
+// node runs the consensus of the shard chain of its committee
+type node struct {
+	config     registery.NodeConfig
+	info       registery.NodeInfo
+	privateKey ed25519.PrivateKey
+
+	// registered nodes ordered by their validator indexes
+	nodes      []registery.NodeInfo
+	validators *common.ValidatorSet
+
+	// committees of the epochs, it is nil if the sharding is disabled
+	committees *consensus.Committees
+
+	shards    []*shardChain
+	pool      *mempool.Mempool
+	apiServer *network.APIServer
+}
+
+// openShardChains opens and serves the stores of the shard chains after the shard 0, the stores are next to the store of the shard 0
+func openShardChains(first *shardChain, blockStorePath string, server *network.P2PServer, committeeCount int) []*shardChain {
+
+	shards := []*shardChain{first}
+	for shard := 1; shard < committeeCount; shard++ {
+
+		blockStore, err := store.Open(shardStorePath(blockStorePath, shard))
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("block store of shard %d opened, last decided round is %d\n", shard, blockStore.LastRound())
+
+		demux := common.NewDemultiplexer(blockStore.LastRound())
+		server.AddShard(shard, demux, blockStore)
+
+		shards = append(shards, &shardChain{shard: shard, demux: demux, blockStore: blockStore})
+	}
+
+	return shards
+}
+
+// shardStorePath returns the path of the store of a shard chain, for instance blocks-shard1.db for blocks.db
+func shardStorePath(path string, shard int) string {
+
+	extension := filepath.Ext(path)
+
+	return fmt.Sprintf("%s-shard%d%s", strings.TrimSuffix(path, extension), shard, extension)
+}
+
+// validatorNodes orders the registered nodes by their validator indexes
+func validatorNodes(nodeList []registery.NodeInfo, validators *common.ValidatorSet) []registery.NodeInfo {
+
+	nodes := make([]registery.NodeInfo, validators.Size())
+	for _, nodeInfo := range nodeList {
+		nodes[validators.Index(nodeInfo.PublicKey)] = nodeInfo
+	}
+
+	return nodes
+}
+
+// membersOf returns the registered nodes of the members of a committee
+func (n *node) membersOf(members []int) []registery.NodeInfo {
+
+	var nodes []registery.NodeInfo
+	for _, index := range members {
+		nodes = append(nodes, n.nodes[index])
+	}
+
+	return nodes
+}
+
+// restore restores the beacons, the hashes, and the committed transactions of the stored chains
+func (n *node) restore() {
+
+	for _, sc := range n.shards {
+		sc.previousBlockHash = storedBlockHash(sc.blockStore)
+		restoreBeacon(sc.consensus.Beacon(), sc.blockStore)
+		restoreCommitted(n.pool, sc.blockStore)
+	}
+
+	if n.committees == nil {
+		return
+	}
+
+	// the stored reference rounds are not requested again
+	reference := n.shards[common.ReferenceCommittee].blockStore
+	for round := 1; round <= reference.LastRound(); round++ {
+		decidedRound, err := reference.Get(round)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := n.committees.AddReferenceRound(decidedRound); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// chainOf returns the shard chain of the committee of the node in the round
+func (n *node) chainOf(round int) *shardChain {
+
+	if n.committees == nil {
+		return n.shards[0]
+	}
+
+	return n.shards[n.committees.ShardOf(n.info.PublicKey, round)]
+}
+
+// epochOf returns the epoch of the round
+func (n *node) epochOf(round int) int {
+
+	if n.committees == nil {
+		return 1
+	}
+
+	return n.committees.Epoch(round)
+}
+
+// firstRoundOf returns the first round of the epoch of the round
+func (n *node) firstRoundOf(round int) int {
+
+	if n.config.EpochLength == 0 {
+		return 1
+	}
+
+	return (n.epochOf(round)-1)*n.config.EpochLength + 1
+}
+
+// join connects to the members of the committee of the chain in the epoch of the round
+func (n *node) join(sc *shardChain, round int) {
+
+	nodes := n.nodes
+	if n.committees != nil {
+		nodes = n.membersOf(n.committees.Members(round)[sc.shard])
+	}
+
+	if previous := sc.peerSet; previous != nil {
+		time.AfterFunc(peerSetCloseDelay, previous.Close)
+	}
+
+	peerSet := createPeerSet(nodes, n.config.GossipFanout, n.info, sc.shard)
+	sc.peerSet = &peerSet
+	sc.epoch = n.epochOf(round)
+	sc.consensus.SetPeerSet(peerSet)
+
+	n.apiServer.SetShard(sc.shard, sc.demux, sc.peerSet, sc.blockStore, sc.consensus.Beacon(), sc.consensus.KnownValidatorsOf)
+
+	if n.committees != nil {
+		log.Printf("joined committee %d of %d members in epoch %d\n", sc.shard, len(nodes), sc.epoch)
+	}
+}
+
+// catchUp requests the decided rounds of the chain from the committee.
+// A node that joins a committee waits until the chain reaches the epoch of the round, the previous epoch is run by the previous committee.
+func (n *node) catchUp(sc *shardChain, round int) {
+
+	firstRound := n.firstRoundOf(round)
+	for {
+		for _, decidedRound := range sc.consensus.CatchUp(sc.blockStore.LastRound(), sc.previousBlockHash) {
+			n.appendDecidedRound(sc, decidedRound)
+		}
+
+		if sc.blockStore.LastRound()+1 >= firstRound {
+			return
+		}
+
+		log.Printf("waiting for the chain of committee %d to reach round %d\n", sc.shard, firstRound-1)
+		time.Sleep(time.Second)
+	}
+}
+
+// appendDecidedRound stores a decided round of a chain, the rounds of the reference chain derive the seeds of the epochs
+func (n *node) appendDecidedRound(sc *shardChain, decidedRound common.DecidedRound) {
+
+	appendDecidedRound(decidedRound, sc.blockStore, n.pool)
+	sc.previousBlockHash = decidedRound.NextBlockHash(sc.previousBlockHash)
+
+	if n.committees != nil && sc.shard == common.ReferenceCommittee {
+		if err := n.committees.AddReferenceRound(decidedRound); err != nil {
+			panic(err)
+		}
+	}
+}
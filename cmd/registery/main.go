@@ -49,5 +49,9 @@ func readConfigFromFile() registery.NodeConfig {
 	config := registery.NodeConfig{}
 	json.Unmarshal(data, &config)
 
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+
 	return config
 }
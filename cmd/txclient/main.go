@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/network"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+// txclient submits signed transactions to nodes at a fixed rate.
+// The load is open-loop: submissions do not wait for the replies of the previous submissions.
+func main() {
+
+	nodes := flag.String("nodes", "", "comma separated list of node addresses (ip:port)")
+	registryAddress := flag.String("registry", "", "registry address to retrieve node addresses, used if -nodes is not provided")
+	rate := flag.Float64("rate", 100, "number of transactions submitted per second")
+	duration := flag.Duration("duration", 1*time.Minute, "duration of the load")
+	payloadSize := flag.Int("size", 512, "mean payload size of transactions in bytes")
+	sizeDistribution := flag.String("size-dist", "fixed", "payload size distribution: fixed, uniform or exponential")
+	maxFee := flag.Uint64("max-fee", 0, "fees are drawn uniformly from [0, max-fee]")
+	senderCount := flag.Int("senders", 16, "number of distinct sender keys")
+	flag.Parse()
+
+	targets := getTargets(*nodes, *registryAddress)
+	if len(targets) == 0 {
+		log.Fatal("no target nodes, provide -nodes or -registry")
+	}
+
+	var clients []*rpc.Client
+	for _, target := range targets {
+		client, err := rpc.Dial("tcp", target)
+		if err != nil {
+			log.Fatalf("could not connect to %s: %s", target, err)
+		}
+		clients = append(clients, client)
+	}
+
+	sizeSampler, err := newSizeSampler(*sizeDistribution, *payloadSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	senders := make([]sender, *senderCount)
+	for i := range senders {
+		_, privateKey, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			panic(err)
+		}
+		senders[i].privateKey = privateKey
+	}
+
+	log.Printf("submitting %.1f tx/s to %d nodes for %s\n", *rate, len(targets), *duration)
+
+	stats := &submissionStats{}
+	var outstanding sync.WaitGroup
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	reportTicker := time.NewTicker(time.Second)
+	defer reportTicker.Stop()
+
+	startTime := time.Now()
+	submitted := 0
+	for time.Since(startTime) < *duration {
+		select {
+		case <-reportTicker.C:
+			stats.report(submitted)
+
+		case <-ticker.C:
+			// submits the transactions that are due according to the rate
+			due := int(time.Since(startTime).Seconds() * *rate)
+			for ; submitted < due; submitted++ {
+
+				s := &senders[submitted%len(senders)]
+				fee := uint64(0)
+				if *maxFee > 0 {
+					fee = uint64(rand.Int63n(int64(*maxFee) + 1))
+				}
+
+				tx := common.NewTransaction(s.privateKey, s.nonce, fee, getRandomByteSlice(sizeSampler()))
+				s.nonce++
+
+				client := clients[submitted%len(clients)]
+				reply := &network.SubmitReply{}
+				outstanding.Add(1)
+				call := client.Go("TxServer.SubmitTransaction", tx, reply, make(chan *rpc.Call, 1))
+				go func() {
+					defer outstanding.Done()
+					<-call.Done
+					stats.record(call.Error, reply)
+				}()
+			}
+		}
+	}
+
+	log.Printf("waiting for the replies of the outstanding submissions\n")
+	outstanding.Wait()
+
+	stats.report(submitted)
+	accepted, rejected, failed, reasons := stats.get()
+	fmt.Printf("submitted\t%d\naccepted\t%d\nrejected\t%d\nfailed\t%d\n", submitted, accepted, rejected, failed)
+	for reason, count := range reasons {
+		fmt.Printf("rejected\t%d\t%s\n", count, reason)
+	}
+}
+
+type sender struct {
+	privateKey ed25519.PrivateKey
+	nonce      uint64
+}
+
+type submissionStats struct {
+	mutex    sync.Mutex
+	accepted int
+	rejected int
+	failed   int
+	reasons  map[string]int
+}
+
+func (s *submissionStats) record(err error, reply *network.SubmitReply) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.reasons == nil {
+		s.reasons = make(map[string]int)
+	}
+
+	switch {
+	case err != nil:
+		s.failed++
+		s.reasons[err.Error()]++
+	case reply.Accepted:
+		s.accepted++
+	default:
+		s.rejected++
+		s.reasons[reply.Reason]++
+	}
+}
+
+func (s *submissionStats) get() (int, int, int, map[string]int) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reasons := make(map[string]int)
+	for reason, count := range s.reasons {
+		reasons[reason] = count
+	}
+
+	return s.accepted, s.rejected, s.failed, reasons
+}
+
+func (s *submissionStats) report(submitted int) {
+	accepted, rejected, failed, _ := s.get()
+	log.Printf("submitted %d accepted %d rejected %d failed %d\n", submitted, accepted, rejected, failed)
+}
+
+// newSizeSampler returns a function that draws payload sizes from the distribution
+func newSizeSampler(distribution string, meanSize int) (func() int, error) {
+
+	switch distribution {
+	case "fixed":
+		return func() int { return meanSize }, nil
+	case "uniform":
+		return func() int { return rand.Intn(2*meanSize + 1) }, nil
+	case "exponential":
+		return func() int { return int(math.Round(rand.ExpFloat64() * float64(meanSize))) }, nil
+	default:
+		return nil, fmt.Errorf("unknown size distribution %q", distribution)
+	}
+}
+
+func getTargets(nodes string, registryAddress string) []string {
+
+	if nodes != "" {
+		return strings.Split(nodes, ",")
+	}
+
+	if registryAddress == "" {
+		return nil
+	}
+
+	registry := registery.NewRegistryClient(registryAddress, registery.NodeInfo{})
+
+	var targets []string
+	for _, node := range registry.GetNodeList() {
+		targets = append(targets, fmt.Sprintf("%s:%d", node.IPAddress, node.PortNumber))
+	}
+
+	return targets
+}
+
+func getRandomByteSlice(size int) []byte {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/consensus"
+	"github.com/korkmazkadir/rapidchain/registery"
+	"github.com/korkmazkadir/rapidchain/store"
+)
+
+// verifychain audits the block stores of several nodes.
+// For every round it checks the hash chain links, the Merkle roots of the micro blocks, the signatures of the votes,
+// and that all the nodes decided on the same blocks.
+// The seeds of the epochs are recomputed from the beacon proofs of the decided blocks, the leaders of a node are elected using these seeds.
+// The votes must be issued by the validators of the round, the validators are read from the node list that the nodes save next to their stores.
+// With sharding, the stores of a single shard chain are audited together. The committee of the chain in a round is assigned
+// by the seeds replayed from a store of the reference chain, the shard 0.
+func main() {
+
+	configFile := flag.String("config", "config.json", "node config used in the experiment")
+	nodesFile := flag.String("nodes", "nodes.json", "node list saved by the nodes")
+	shard := flag.Int("shard", 0, "shard chain of the block stores")
+	referenceFile := flag.String("reference", "", "block store of the reference chain, the first block store if the shard is 0")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-config config.json] [-nodes nodes.json] [-shard 0] [-reference blocks.db] <block store file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config := readConfig(*configFile)
+	chains := openChains(flag.Args())
+	if len(chains) == 0 {
+		log.Fatal("no block stores found")
+	}
+
+	committee := &committeeReplay{config: config, validators: readValidators(*nodesFile), shard: *shard}
+	if config.IsShardingEnabled() {
+		committee.reference = openReference(*referenceFile, *shard, chains)
+		committee.beacon = common.NewBeacon(config.EpochSeed, config.EpochLength)
+	}
+
+	firstRound, lastRound := roundRange(chains)
+	fmt.Printf("verifying rounds %d-%d of %d nodes\n", firstRound, lastRound, len(chains))
+
+	// hash of the previous round on each chain, the chains start from the genesis blocks
+	previousHashes := make([][]byte, len(chains))
+	for i := range previousHashes {
+		previousHashes[i] = common.HashBlocks(common.GenesisBlocks())
+	}
+
+	// the beacon replays the rounds decided by all the nodes, the seeds can not be derived if the first rounds are not stored
+	var beacon *common.Beacon
+	if firstRound == 1 {
+		beacon = common.NewBeacon(config.EpochSeed, config.EpochLength)
+	}
+
+	firstDivergence := -1
+	for round := firstRound; round <= lastRound; round++ {
+
+		report := verifyRound(round, chains, previousHashes, config, committee)
+		fmt.Println(report.String())
+
+		if beacon != nil && report.ok() {
+			replayBeacon(beacon, report.decidedRound)
+		} else {
+			beacon = nil
+		}
+
+		if !report.ok() && firstDivergence == -1 {
+			firstDivergence = round
+			for _, detail := range report.details {
+				fmt.Printf("\t%s\n", detail)
+			}
+		}
+	}
+
+	if firstDivergence != -1 {
+		fmt.Printf("first divergence at round %d\n", firstDivergence)
+		os.Exit(1)
+	}
+
+	fmt.Printf("all %d nodes agree on %d rounds\n", len(chains), lastRound-firstRound+1)
+}
+
+type chain struct {
+	name       string
+	blockStore *store.BlockStore
+}
+
+type roundReport struct {
+	round   int
+	valid   int
+	missing int
+	invalid int
+
+	// number of nodes decided on each hash
+	hashes map[string]int
+
+	// round decided by a node whose round is valid
+	decidedRound common.DecidedRound
+
+	details []string
+}
+
+func (r roundReport) ok() bool {
+	return r.invalid == 0 && r.missing == 0 && len(r.hashes) == 1
+}
+
+func (r roundReport) String() string {
+
+	status := "OK"
+	switch {
+	case r.invalid > 0:
+		status = "INVALID"
+	case len(r.hashes) > 1:
+		status = "DIVERGED"
+	case r.missing > 0:
+		status = "MISSING"
+	}
+
+	var hashes []string
+	for hash, count := range r.hashes {
+		hashes = append(hashes, fmt.Sprintf("%s:%d", encodeBase64([]byte(hash)[:15]), count))
+	}
+	sort.Strings(hashes)
+
+	return fmt.Sprintf("round %d\t%s\tvalid %d\tinvalid %d\tmissing %d\t%s", r.round, status, r.valid, r.invalid, r.missing, strings.Join(hashes, " "))
+}
+
+func verifyRound(round int, chains []chain, previousHashes [][]byte, config registery.NodeConfig, committee *committeeReplay) roundReport {
+
+	report := roundReport{round: round, hashes: make(map[string]int)}
+
+	validators, err := committee.validatorsOf(round)
+	if err != nil {
+		report.invalid = len(chains)
+		report.details = append(report.details, err.Error())
+		return report
+	}
+
+	var missingChains []int
+	for i, c := range chains {
+
+		decidedRound, err := c.blockStore.Get(round)
+		if err == store.ErrRoundNotFound {
+			report.missing++
+			report.details = append(report.details, fmt.Sprintf("%s: round is missing", c.name))
+			missingChains = append(missingChains, i)
+			continue
+		}
+
+		if err != nil {
+			report.invalid++
+			report.details = append(report.details, fmt.Sprintf("%s: could not read the round: %s", c.name, err))
+			continue
+		}
+
+		hash := decidedRound.NextBlockHash(previousHashes[i])
+		err = consensus.VerifyDecidedRound(decidedRound, round, previousHashes[i], config, validators)
+		previousHashes[i] = hash
+
+		if err != nil {
+			report.invalid++
+			report.details = append(report.details, fmt.Sprintf("%s: %s", c.name, err))
+			continue
+		}
+
+		report.valid++
+		report.hashes[string(hash)]++
+		report.decidedRound = decidedRound
+		if decidedRound.IsEmpty() {
+			report.details = append(report.details, fmt.Sprintf("%s: decided an empty round, the chain stays at %s", c.name, encodeBase64(hash[:15])))
+			continue
+		}
+		report.details = append(report.details, fmt.Sprintf("%s: decided %s", c.name, encodeBase64(hash[:15])))
+	}
+
+	// a chain missing the round continues from the round decided by the other nodes
+	if len(report.hashes) == 1 {
+		for hash := range report.hashes {
+			for _, i := range missingChains {
+				previousHashes[i] = []byte(hash)
+			}
+		}
+	}
+
+	return report
+}
+
+// replayBeacon adds a decided round to the beacon, and prints the seed of the next epoch at the end of an epoch
+func replayBeacon(beacon *common.Beacon, decidedRound common.DecidedRound) {
+
+	if err := beacon.AddRound(decidedRound); err != nil {
+		log.Fatal(err)
+	}
+
+	nextRound := decidedRound.Round + 1
+	if beacon.Epoch(nextRound) == beacon.Epoch(decidedRound.Round) {
+		return
+	}
+
+	epochs := beacon.Epochs()
+	ended := epochs[len(epochs)-2]
+	seed, _ := beacon.Seed(nextRound)
+	fmt.Printf("epoch %d ended with %d contributors, seed of epoch %d is %x\n", ended.Epoch, len(ended.Contributors), beacon.Epoch(nextRound), seed)
+}
+
+// committeeReplay provides the validators of the audited chain in a round
+type committeeReplay struct {
+	config     registery.NodeConfig
+	validators *common.ValidatorSet
+	shard      int
+
+	// the seeds are replayed from the reference chain, they are nil if the sharding is disabled
+	reference *store.BlockStore
+	beacon    *common.Beacon
+}
+
+// validatorsOf returns the committee of the chain in the round, all the validators if the sharding is disabled
+func (c *committeeReplay) validatorsOf(round int) (*common.ValidatorSet, error) {
+
+	if c.beacon == nil {
+		return c.validators, nil
+	}
+
+	// the seed of an epoch is derived from the reference rounds of the previous epoch
+	for {
+		seed, ok := c.beacon.Seed(round)
+		if ok {
+			members := common.AssignCommittees(c.validators.Size(), seed, c.beacon.Epoch(round), c.config.CommitteeCount)
+			return c.validators.Subset(members[c.shard]), nil
+		}
+
+		referenceRound := c.beacon.LastRound() + 1
+		decidedRound, err := c.reference.Get(referenceRound)
+		if err != nil {
+			return nil, fmt.Errorf("committee of round %d is not known, could not read reference round %d: %s", round, referenceRound, err)
+		}
+
+		if err := c.beacon.AddRound(decidedRound); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// openReference opens the block store of the reference chain, the first audited store is the reference if the shard is 0
+func openReference(referenceFile string, shard int, chains []chain) *store.BlockStore {
+
+	if referenceFile == "" {
+		if shard != common.ReferenceCommittee {
+			log.Fatalf("block store of the reference chain is required to audit the shard %d", shard)
+		}
+
+		return chains[0].blockStore
+	}
+
+	reference, err := store.OpenReadOnly(referenceFile)
+	if err != nil {
+		log.Fatalf("could not open %s: %s", referenceFile, err)
+	}
+
+	return reference
+}
+
+func roundRange(chains []chain) (int, int) {
+
+	firstRound := 0
+	lastRound := 0
+	for _, c := range chains {
+		if first := c.blockStore.FirstRound(); first > 0 && (firstRound == 0 || first < firstRound) {
+			firstRound = first
+		}
+
+		if last := c.blockStore.LastRound(); last > lastRound {
+			lastRound = last
+		}
+	}
+
+	return firstRound, lastRound
+}
+
+// openChains opens the block stores, directories are searched for files with .db extension
+func openChains(paths []string) []chain {
+
+	var files []string
+	for _, path := range paths {
+
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.IsDir() && filepath.Ext(file) == ".db" {
+				files = append(files, file)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var chains []chain
+	for _, file := range files {
+		blockStore, err := store.OpenReadOnly(file)
+		if err != nil {
+			log.Fatalf("could not open %s: %s", file, err)
+		}
+		chains = append(chains, chain{name: file, blockStore: blockStore})
+	}
+
+	return chains
+}
+
+func readConfig(configFile string) registery.NodeConfig {
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config := registery.NodeConfig{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return config
+}
+
+// readValidators reads the node list, the validators are ordered as the nodes order them
+func readValidators(nodesFile string) *common.ValidatorSet {
+
+	data, err := ioutil.ReadFile(nodesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var nodeList []registery.NodeInfo
+	err = json.Unmarshal(data, &nodeList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	validators, err := registery.NewValidatorSet(nodeList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return validators
+}
+
+func encodeBase64(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
+}
@@ -0,0 +1,196 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Randomness beacon
+//
+// The seed of the first epoch is the seed of the config. A leader attaches to its block the VRF proof of its key
+// over the seed of the epoch, and the seed of the next epoch is derived from the VRF outputs of the blocks
+// decided in the epoch. The keys of the validators are registered before the first epoch, they commit to
+// the outputs: a leader can not choose its contribution.
+// All the nodes decide the same blocks, so they derive the same seeds. The transcript of the beacon is the chain,
+// the seeds can be recomputed from the decided rounds by anyone.
+//
+// The seed is not unbiasable. A leader can withhold its contribution by not proposing, or by proposing a block
+// that is not decided, and nobody else can compute its output. The leaders of the last round of an epoch know
+// the outputs decided before them, so each of them can choose between two seeds at the cost of its block.
+// Removing this bias requires the withheld contributions to be recoverable by the other validators,
+// for instance with a threshold VRF or publicly verifiable secret sharing, which are not implemented.
+
+// BeaconEpoch is an epoch of the beacon
+type BeaconEpoch struct {
+	Epoch int
+
+	Seed []byte
+
+	// issuers of the VRF outputs that derive the seed of the next epoch, in the order of their first decided block.
+	// The contributors of the current epoch are the ones of the rounds added so far.
+	Contributors [][]byte
+}
+
+// Beacon derives the seed of each epoch from the decided rounds, the rounds are added in order.
+// It is safe for concurrent use.
+type Beacon struct {
+	mutex sync.Mutex
+
+	// number of rounds of an epoch, there is a single epoch if it is 0
+	epochLength int
+
+	// epochs[i] is the epoch i+1
+	epochs []BeaconEpoch
+
+	lastRound int
+
+	// VRF outputs of the current epoch keyed by issuer, each issuer contributes once in an epoch
+	outputs map[string][]byte
+}
+
+// NewBeacon creates a beacon, seed is the seed of the first epoch
+func NewBeacon(seed []byte, epochLength int) *Beacon {
+
+	if epochLength < 0 {
+		panic(fmt.Errorf("illegal epoch length %d", epochLength))
+	}
+
+	return &Beacon{
+		epochLength: epochLength,
+		epochs:      []BeaconEpoch{{Epoch: 1, Seed: seed}},
+		outputs:     make(map[string][]byte),
+	}
+}
+
+// Epoch returns the epoch of the round, the first epoch starts from round 1
+func (b *Beacon) Epoch(round int) int {
+
+	if b.epochLength == 0 || round < 1 {
+		return 1
+	}
+
+	return (round-1)/b.epochLength + 1
+}
+
+// Seed returns the seed of the epoch of the round, it returns false if the previous epoch has not ended yet
+func (b *Beacon) Seed(round int) ([]byte, bool) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	epoch := b.Epoch(round)
+	if epoch > len(b.epochs) {
+		return nil, false
+	}
+
+	return b.epochs[epoch-1].Seed, true
+}
+
+// Prove returns the beacon proof of the key for the epoch of the round, the proof is attached to the block of the round
+func (b *Beacon) Prove(privateKey ed25519.PrivateKey, round int) []byte {
+
+	seed, ok := b.Seed(round)
+	if !ok {
+		panic(fmt.Errorf("seed of round %d is not known", round))
+	}
+
+	_, proof := VRFProve(privateKey, BeaconInput(seed, b.Epoch(round)))
+
+	return proof
+}
+
+// AddRound adds the next decided round. If the round is the last round of its epoch, the seed of the next epoch is derived.
+// The blocks whose beacon proofs are not valid, and the blocks of the issuers that already contributed to the epoch are ignored.
+func (b *Beacon) AddRound(decidedRound DecidedRound) error {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if decidedRound.Round != b.lastRound+1 {
+		return fmt.Errorf("round %d does not follow the last round %d of the beacon", decidedRound.Round, b.lastRound)
+	}
+
+	current := &b.epochs[len(b.epochs)-1]
+	alpha := BeaconInput(current.Seed, current.Epoch)
+
+	for i := range decidedRound.Blocks {
+		block := &decidedRound.Blocks[i]
+		if _, ok := b.outputs[string(block.Issuer)]; ok {
+			continue
+		}
+
+		output, err := VRFVerify(block.Issuer, alpha, block.BeaconProof)
+		if err != nil {
+			continue
+		}
+
+		b.outputs[string(block.Issuer)] = output
+		current.Contributors = append(current.Contributors, block.Issuer)
+	}
+
+	b.lastRound = decidedRound.Round
+
+	if b.epochLength == 0 || b.lastRound%b.epochLength != 0 {
+		return nil
+	}
+
+	var outputs [][]byte
+	for _, issuer := range current.Contributors {
+		outputs = append(outputs, b.outputs[string(issuer)])
+	}
+
+	b.epochs = append(b.epochs, BeaconEpoch{Epoch: current.Epoch + 1, Seed: NextEpochSeed(current.Seed, current.Epoch, outputs)})
+	b.outputs = make(map[string][]byte)
+
+	return nil
+}
+
+// LastRound returns the last added round
+func (b *Beacon) LastRound() int {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.lastRound
+}
+
+// Epochs returns the epochs whose seeds are known, the contributors of the current epoch are the ones received so far
+func (b *Beacon) Epochs() []BeaconEpoch {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	epochs := make([]BeaconEpoch, len(b.epochs))
+	for i, epoch := range b.epochs {
+		epochs[i] = BeaconEpoch{Epoch: epoch.Epoch, Seed: epoch.Seed}
+		epochs[i].Contributors = append(epochs[i].Contributors, epoch.Contributors...)
+	}
+
+	return epochs
+}
+
+// BeaconInput returns the VRF input of the beacon proofs of an epoch
+func BeaconInput(seed []byte, epoch int) []byte {
+
+	input := append([]byte("rapidchain beacon"), seed...)
+	var encodedEpoch [8]byte
+	binary.BigEndian.PutUint64(encodedEpoch[:], uint64(epoch))
+
+	return append(input, encodedEpoch[:]...)
+}
+
+// NextEpochSeed derives the seed of the epoch after the given epoch from its seed and the VRF outputs of its contributors
+func NextEpochSeed(seed []byte, epoch int, outputs [][]byte) []byte {
+
+	h := sha256.New()
+	h.Write([]byte("rapidchain epoch seed"))
+	e := newEncoder(h)
+	e.writeBytes(seed)
+	e.writeInt(epoch + 1)
+	e.writeBytesList(outputs)
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestBeacon(t *testing.T) {
+
+	var publicKeys [][]byte
+	var privateKeys []ed25519.PrivateKey
+	for i := 0; i < 3; i++ {
+		publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+		publicKeys = append(publicKeys, publicKey)
+		privateKeys = append(privateKeys, privateKey)
+	}
+
+	seed := []byte{1, 2, 3}
+	beacon := NewBeacon(seed, 2)
+
+	if beacon.Epoch(1) != 1 || beacon.Epoch(2) != 1 || beacon.Epoch(3) != 2 {
+		t.Fatalf("unexpected epochs of the rounds")
+	}
+
+	if _, ok := beacon.Seed(3); ok {
+		t.Fatalf("the seed of the second epoch is known before the first epoch ends")
+	}
+
+	block := func(issuer int, proof []byte) Block {
+		return Block{Issuer: publicKeys[issuer], BeaconProof: proof}
+	}
+
+	// the proof of the validator 1 is for another epoch, and the validator 0 contributes once
+	wrongEpoch, _ := NewBeacon(seed, 2).Seed(1)
+	_, wrongProof := VRFProve(privateKeys[1], BeaconInput(wrongEpoch, 2))
+	rounds := []DecidedRound{
+		{Round: 1, Blocks: []Block{block(0, beacon.Prove(privateKeys[0], 1)), block(1, wrongProof)}},
+		{Round: 2, Blocks: []Block{block(0, beacon.Prove(privateKeys[0], 2)), block(2, beacon.Prove(privateKeys[2], 2))}},
+	}
+
+	if err := beacon.AddRound(rounds[1]); err == nil {
+		t.Errorf("expected an error because the rounds are not added in order")
+	}
+
+	for i := range rounds {
+		if err := beacon.AddRound(rounds[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output0, _ := VRFProve(privateKeys[0], BeaconInput(seed, 1))
+	output2, _ := VRFProve(privateKeys[2], BeaconInput(seed, 1))
+	expected := NextEpochSeed(seed, 1, [][]byte{output0, output2})
+
+	nextSeed, ok := beacon.Seed(3)
+	if !ok || !bytes.Equal(nextSeed, expected) {
+		t.Fatalf("unexpected seed of the second epoch %x, expected %x", nextSeed, expected)
+	}
+
+	epochs := beacon.Epochs()
+	if len(epochs) != 2 || len(epochs[0].Contributors) != 2 || !bytes.Equal(epochs[0].Contributors[1], publicKeys[2]) {
+		t.Errorf("expected the validators 0 and 2 to contribute to the first epoch")
+	}
+
+	// the seed is recomputed from the transcript by another node
+	replayed := NewBeacon(seed, 2)
+	for i := range rounds {
+		if err := replayed.AddRound(rounds[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if replayedSeed, _ := replayed.Seed(4); !bytes.Equal(replayedSeed, nextSeed) {
+		t.Errorf("the replayed beacon derived a different seed")
+	}
+}
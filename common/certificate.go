@@ -0,0 +1,81 @@
+package common
+
+import "fmt"
+
+// AcceptCertificate proves that a quorum of nodes accepted the micro blocks of a round, the round is final.
+// The accept proofs of the votes are removed, the signatures of the votes do not cover them.
+type AcceptCertificate struct {
+	AcceptVotes []Vote
+}
+
+// NewAcceptCertificate creates a certificate from a quorum of accept votes
+func NewAcceptCertificate(acceptVotes []Vote) AcceptCertificate {
+
+	var certificate AcceptCertificate
+	for _, vote := range acceptVotes {
+		vote.Proof = AcceptProof{}
+		certificate.AcceptVotes = append(certificate.AcceptVotes, vote)
+	}
+
+	return certificate
+}
+
+// Verify checks that the certificate has at least minVoteCount valid accept votes of the round
+// for the Merkle roots from distinct issuers
+func (ac AcceptCertificate) Verify(round int, merkleRoots [][]byte, minVoteCount int) error {
+
+	issuers := make(map[string]struct{})
+	for i := range ac.AcceptVotes {
+		vote := ac.AcceptVotes[i]
+		if vote.Tag != AcceptTag || vote.Round != round || !equalHashLists(vote.BlockHash, merkleRoots) {
+			return fmt.Errorf("accept vote %d is not for the decided merkle roots", i)
+		}
+
+		if _, ok := issuers[string(vote.Issuer)]; ok {
+			return fmt.Errorf("accept vote %d has a duplicate issuer", i)
+		}
+		issuers[string(vote.Issuer)] = struct{}{}
+
+		if !vote.VerifySignature() {
+			return fmt.Errorf("accept vote %d has an invalid signature", i)
+		}
+	}
+
+	if len(issuers) < minVoteCount {
+		return fmt.Errorf("accept certificate has %d accept votes, required %d", len(issuers), minVoteCount)
+	}
+
+	return nil
+}
+
+// TimeoutCertificate proves that a quorum of nodes timed out in a round before deciding any micro block
+type TimeoutCertificate struct {
+	TimeoutVotes []Vote
+}
+
+// Verify checks that the certificate has at least minVoteCount valid timeout votes of the round from distinct issuers
+func (tc TimeoutCertificate) Verify(round int, minVoteCount int) error {
+
+	issuers := make(map[string]struct{})
+	for i := range tc.TimeoutVotes {
+		vote := tc.TimeoutVotes[i]
+		if vote.Tag != TimeoutTag || vote.Round != round || len(vote.BlockHash) != 0 {
+			return fmt.Errorf("timeout vote %d is not a timeout vote of round %d", i, round)
+		}
+
+		if _, ok := issuers[string(vote.Issuer)]; ok {
+			return fmt.Errorf("timeout vote %d has a duplicate issuer", i)
+		}
+		issuers[string(vote.Issuer)] = struct{}{}
+
+		if !vote.VerifySignature() {
+			return fmt.Errorf("timeout vote %d has an invalid signature", i)
+		}
+	}
+
+	if len(issuers) < minVoteCount {
+		return fmt.Errorf("timeout certificate has %d timeout votes, required %d", len(issuers), minVoteCount)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestAcceptCertificate(t *testing.T) {
+
+	merkleRoots := [][]byte{[]byte("merkle root")}
+
+	echoPublicKey, echoPrivateKey, _ := ed25519.GenerateKey(nil)
+	echoVote := Vote{Issuer: echoPublicKey, Tag: EchoTag, Round: 2, BlockHash: merkleRoots}
+	echoVote.Signature = ed25519.Sign(echoPrivateKey, echoVote.SigningHash())
+
+	var acceptVotes []Vote
+	for i := 0; i < 3; i++ {
+		publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+		vote := Vote{Issuer: publicKey, Tag: AcceptTag, Round: 2, BlockHash: merkleRoots, Proof: AcceptProof{EchoVotes: []Vote{echoVote}}}
+		vote.Signature = ed25519.Sign(privateKey, vote.SigningHash())
+		if !vote.VerifySignature() {
+			t.Fatalf("accept vote %d has an invalid signature", i)
+		}
+		acceptVotes = append(acceptVotes, vote)
+	}
+
+	// the proofs are removed, the signatures remain valid
+	certificate := NewAcceptCertificate(acceptVotes)
+	if len(certificate.AcceptVotes[0].Proof.EchoVotes) != 0 {
+		t.Errorf("expected the accept proofs to be removed")
+	}
+
+	if err := certificate.Verify(2, merkleRoots, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if certificate.Verify(2, [][]byte{[]byte("another merkle root")}, 3) == nil {
+		t.Errorf("expected an error because the votes are for other merkle roots")
+	}
+
+	if certificate.Verify(2, merkleRoots, 4) == nil {
+		t.Errorf("expected an error because there is no quorum of accept votes")
+	}
+
+	duplicate := AcceptCertificate{AcceptVotes: []Vote{certificate.AcceptVotes[0], certificate.AcceptVotes[0], certificate.AcceptVotes[1]}}
+	if duplicate.Verify(2, merkleRoots, 3) == nil {
+		t.Errorf("expected an error because the accept votes have duplicate issuers")
+	}
+}
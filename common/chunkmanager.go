@@ -3,7 +3,6 @@ package common
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
 	"fmt"
 	"math"
 
@@ -12,13 +11,54 @@ import (
 
 func ChunkBlock(block Block, numberOfChunks int) ([]BlockChunk, []byte) {
 
-	blockBytes := encodeToBytes(block)
+	blockBytes := encodeBlockData(block)
 	chunks := constructChunks(block, blockBytes, numberOfChunks)
 	merkleRootHash := createAuthenticators(chunks)
 
 	return chunks, merkleRootHash
 }
 
+// ChunkBlockWithErasureCoding chunks a block using Reed-Solomon erasure coding.
+// The block can be reconstructed using any dataChunkCount chunks out of numberOfChunks chunks.
+func ChunkBlockWithErasureCoding(block Block, dataChunkCount int, numberOfChunks int) ([]BlockChunk, []byte) {
+
+	coder, err := newErasureCoder(dataChunkCount, numberOfChunks)
+	if err != nil {
+		panic(err)
+	}
+
+	blockBytes := encodeBlockData(block)
+	shards := coder.Encode(blockBytes)
+
+	var chunks []BlockChunk
+	for i := range shards {
+		chunk := BlockChunk{
+			Issuer:         block.Issuer,
+			Round:          block.Round,
+			ChunkCount:     numberOfChunks,
+			DataChunkCount: dataChunkCount,
+			ChunkIndex:     i,
+			Payload:        shards[i],
+		}
+
+		chunks = append(chunks, chunk)
+	}
+
+	merkleRootHash := createAuthenticators(chunks)
+
+	return chunks, merkleRootHash
+}
+
+// ChunkBlockWithCounts chunks a block into chunkCount chunks, the block is erasure coded if dataChunkCount is smaller than chunkCount
+func ChunkBlockWithCounts(block Block, dataChunkCount int, chunkCount int) ([]BlockChunk, []byte) {
+
+	if dataChunkCount < chunkCount {
+		return ChunkBlockWithErasureCoding(block, dataChunkCount, chunkCount)
+	}
+
+	return ChunkBlock(block, chunkCount)
+}
+
 // mergeChunks assumes that sanity checks are done before calling this function
 func MergeChunks(chunks []BlockChunk) Block {
 
@@ -27,7 +67,89 @@ func MergeChunks(chunks []BlockChunk) Block {
 		blockData = append(blockData, chunks[i].Payload...)
 	}
 
-	return decodeToBlock(blockData)
+	block, err := decodeBlockData(blockData)
+	if err != nil {
+		panic(err)
+	}
+
+	return block
+}
+
+// ReconstructBlock reconstructs a block from the chunks of the block.
+// If the block is erasure coded, any DataChunkCount distinct chunks are enough,
+// otherwise all the chunks must be provided. Chunks must be validated before calling this function.
+// The reconstructed block is chunked again, and it is rejected if the Merkle root differs from the root of the chunks.
+// Otherwise a leader could send chunks that do not agree with each other, and different subsets of the chunks would reconstruct different blocks.
+func ReconstructBlock(chunks []BlockChunk) (Block, error) {
+
+	block, err := reconstructBlock(chunks)
+	if err != nil {
+		return Block{}, err
+	}
+
+	_, merkleRoot := ChunkBlockWithCounts(block, chunks[0].DataChunkCount, chunks[0].ChunkCount)
+	if !bytes.Equal(merkleRoot, chunks[0].Authenticator.MerkleRoot) {
+		return Block{}, fmt.Errorf("chunks of the reconstructed block do not match the merkle root")
+	}
+
+	return block, nil
+}
+
+// reconstructBlock decodes the block from the chunks without checking the Merkle root
+func reconstructBlock(chunks []BlockChunk) (Block, error) {
+
+	if len(chunks) == 0 {
+		return Block{}, fmt.Errorf("no chunks provided to reconstruct the block")
+	}
+
+	chunkCount := chunks[0].ChunkCount
+	dataChunkCount := chunks[0].DataChunkCount
+
+	orderedChunks := make([]*BlockChunk, chunkCount)
+	for i := range chunks {
+		c := &chunks[i]
+		if c.ChunkCount != chunkCount || c.DataChunkCount != dataChunkCount {
+			return Block{}, fmt.Errorf("chunk %d does not agree on chunk counts", c.ChunkIndex)
+		}
+
+		if c.ChunkIndex < 0 || c.ChunkIndex >= chunkCount {
+			return Block{}, fmt.Errorf("chunk index %d is out of range", c.ChunkIndex)
+		}
+
+		orderedChunks[c.ChunkIndex] = c
+	}
+
+	if dataChunkCount == chunkCount {
+
+		var blockData []byte
+		for i := range orderedChunks {
+			if orderedChunks[i] == nil {
+				return Block{}, fmt.Errorf("chunk %d is missing", i)
+			}
+			blockData = append(blockData, orderedChunks[i].Payload...)
+		}
+
+		return decodeBlockData(blockData)
+	}
+
+	coder, err := newErasureCoder(dataChunkCount, chunkCount)
+	if err != nil {
+		return Block{}, err
+	}
+
+	shards := make([][]byte, chunkCount)
+	for i := range orderedChunks {
+		if orderedChunks[i] != nil {
+			shards[i] = orderedChunks[i].Payload
+		}
+	}
+
+	blockData, err := coder.Reconstruct(shards)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return decodeBlockData(blockData)
 }
 
 // createAuthenticators returns mekle root
@@ -70,10 +192,11 @@ func constructChunks(block Block, blockBytes []byte, numberOfChunks int) []Block
 		panic(fmt.Errorf("chunk payload size is 0"))
 	}
 
+	// a block smaller than the number of chunks leaves the last chunks empty
 	for i := 0; i < numberOfChunks; i++ {
 
-		startIndex := i * chunkSize
-		endIndex := startIndex + chunkSize
+		startIndex := minInt(i*chunkSize, len(blockBytes))
+		endIndex := minInt(startIndex+chunkSize, len(blockBytes))
 
 		var payload []byte
 		if i < (numberOfChunks - 1) {
@@ -83,11 +206,12 @@ func constructChunks(block Block, blockBytes []byte, numberOfChunks int) []Block
 		}
 
 		chunk := BlockChunk{
-			Issuer:     block.Issuer,
-			Round:      block.Round,
-			ChunkCount: numberOfChunks,
-			ChunkIndex: i,
-			Payload:    payload,
+			Issuer:         block.Issuer,
+			Round:          block.Round,
+			ChunkCount:     numberOfChunks,
+			DataChunkCount: numberOfChunks,
+			ChunkIndex:     i,
+			Payload:        payload,
 		}
 
 		chunks = append(chunks, chunk)
@@ -96,28 +220,79 @@ func constructChunks(block Block, blockBytes []byte, numberOfChunks int) []Block
 	return chunks
 }
 
-// https://gist.github.com/SteveBate/042960baa7a4795c3565
-func encodeToBytes(p interface{}) []byte {
+// encodeBlockData serializes a block to be chunked: the canonical encoding of the header followed by the payload.
+// The header is at the start of the first chunks, so it can be proven without the rest of the block.
+func encodeBlockData(block Block) []byte {
+	return append(encodeToCanonicalBytes(&block), block.Payload...)
+}
 
-	buf := bytes.Buffer{}
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(p)
-	if err != nil {
-		panic(err)
+func decodeBlockData(data []byte) (Block, error) {
+
+	block := Block{}
+	d := newDecoder(data)
+	block.decode(d)
+	if d.err != nil {
+		return Block{}, fmt.Errorf("could not decode the block header: %s", d.err)
 	}
 
-	return buf.Bytes()
+	block.Payload = data[d.offset:]
+
+	return block, nil
 }
 
-func decodeToBlock(data []byte) Block {
+// VerifyChunk verifies the Merkle path of a chunk against the Merkle root.
+// The path must lead to the leaf at the index of the chunk, so that a chunk can not be placed at another position of the tree.
+func VerifyChunk(merkleRoot []byte, chunk BlockChunk) error {
 
-	block := Block{}
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err := dec.Decode(&block)
+	path := chunk.Authenticator.Path
+	index := chunk.Authenticator.Index
+
+	if err := verifyLeafPosition(index, chunk.ChunkIndex, chunk.ChunkCount); err != nil {
+		return fmt.Errorf("chunk %d: %s", chunk.ChunkIndex, err)
+	}
+
+	valid, err := VerifyContentWithPath(merkleRoot, chunk, path, index)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return block
+
+	if !valid {
+		return fmt.Errorf("merkle path of chunk %d is not correct", chunk.ChunkIndex)
+	}
+
+	return nil
+}
+
+// verifyLeafPosition checks that the path index leads to the leaf at position of a tree of leafCount leaves
+func verifyLeafPosition(index []int64, position int, leafCount int) error {
+
+	if leafCount < 1 || position < 0 || position >= leafCount {
+		return fmt.Errorf("leaf %d is out of range", position)
+	}
+
+	// the tree pads the odd levels by duplicating the last node, there is always at least one level
+	depth := 0
+	for size := leafCount; size > 1 || depth == 0; size = (size + 1) / 2 {
+		depth++
+	}
+
+	if len(index) != depth {
+		return fmt.Errorf("merkle path has %d levels, expected %d", len(index), depth)
+	}
+
+	// an index of 1 means that the node is the left child of its parent
+	pathPosition := 0
+	for level := range index {
+		if index[level] == 0 {
+			pathPosition |= 1 << level
+		}
+	}
+
+	if pathPosition != position {
+		return fmt.Errorf("merkle path leads to leaf %d, expected leaf %d", pathPosition, position)
+	}
+
+	return nil
 }
 
 // VerifyContentWithPath verifies content using path information comming from GetMerklePath function, and Merkle root.
@@ -154,3 +329,10 @@ func VerifyContentWithPath(merkleRoot []byte, content merkletree.Content, path [
 
 	return bytes.Equal(merkleRoot, calculatedRoot), nil
 }
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
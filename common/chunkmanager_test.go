@@ -74,3 +74,122 @@ func getRandomByteSlice(size int) []byte {
 	}
 	return data
 }
+
+func TestChunkBlockWithErasureCoding(t *testing.T) {
+
+	block := Block{
+		Round:         3,
+		Issuer:        getRandomByteSlice(32),
+		Payload:       getRandomByteSlice(2097152),
+		PrevBlockHash: getRandomByteSlice(32),
+	}
+
+	dataChunkCount := 96
+	chunks, merkleRoot := ChunkBlockWithErasureCoding(block, dataChunkCount, 128)
+
+	if len(chunks) != 128 {
+		t.Errorf("expected 128 chunk received %d chunk", len(chunks))
+	}
+
+	for _, c := range chunks {
+		result, err := VerifyContentWithPath(merkleRoot, c, c.Authenticator.Path, c.Authenticator.Index)
+		if err != nil || result == false {
+			t.Errorf("failed to verify chunk %d", c.ChunkIndex)
+		}
+	}
+
+	// a chunk presented under a different index must not verify
+	moved := chunks[5]
+	moved.ChunkIndex = 6
+	result, _ := VerifyContentWithPath(merkleRoot, moved, moved.Authenticator.Path, moved.Authenticator.Index)
+	if result {
+		t.Errorf("chunk verified with a wrong index")
+	}
+
+	// only the last dataChunkCount chunks are used, data chunks are mostly missing
+	reconstructedBlock, err := ReconstructBlock(chunks[len(chunks)-dataChunkCount:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(reconstructedBlock.Hash(), block.Hash()) == false {
+		t.Errorf("block hashes are not equal")
+	}
+
+	_, err = ReconstructBlock(chunks[:dataChunkCount-1])
+	if err == nil {
+		t.Errorf("expected an error because there are not enough chunks")
+	}
+}
+
+func TestReconstructInconsistentChunks(t *testing.T) {
+
+	block := Block{Round: 3, Issuer: getRandomByteSlice(32), Payload: getRandomByteSlice(1024), PrevBlockHash: getRandomByteSlice(32)}
+	chunks, _ := ChunkBlockWithErasureCoding(block, 4, 8)
+
+	// the leader replaces a parity chunk, and authenticates the chunks under a new merkle root
+	chunks[7].Payload = getRandomByteSlice(len(chunks[7].Payload))
+	createAuthenticators(chunks)
+
+	for _, c := range chunks {
+		if err := VerifyChunk(c.Authenticator.MerkleRoot, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// neither the data chunks nor a subset with the replaced chunk reconstruct a block
+	if _, err := ReconstructBlock(chunks[:4]); err == nil {
+		t.Errorf("block is reconstructed from the data chunks of inconsistent chunks")
+	}
+
+	if _, err := ReconstructBlock(chunks[4:]); err == nil {
+		t.Errorf("block is reconstructed from the parity chunks of inconsistent chunks")
+	}
+}
+
+func TestVerifyChunkPosition(t *testing.T) {
+
+	block := Block{Round: 3, Issuer: getRandomByteSlice(32), Payload: getRandomByteSlice(1024), PrevBlockHash: getRandomByteSlice(32)}
+
+	for _, chunkCount := range []int{1, 2, 5, 8} {
+		chunks, merkleRoot := ChunkBlock(block, chunkCount)
+		for _, c := range chunks {
+			if err := VerifyChunk(merkleRoot, c); err != nil {
+				t.Errorf("chunk %d of %d chunks is not verified: %s", c.ChunkIndex, chunkCount, err)
+			}
+		}
+	}
+
+	// the leader places the first two chunks at each other's leaves
+	chunks, _ := ChunkBlock(block, 4)
+	chunks[0], chunks[1] = chunks[1], chunks[0]
+	merkleRoot := createAuthenticators(chunks)
+
+	if err := VerifyChunk(merkleRoot, chunks[0]); err == nil {
+		t.Errorf("chunk %d is verified at another leaf", chunks[0].ChunkIndex)
+	}
+}
+
+func TestChunkEmptyBlock(t *testing.T) {
+
+	// an empty block is smaller than the number of chunks, the last chunks are empty
+	block := NewBlock(getRandomByteSlice(32), getRandomByteSlice(32), 1, nil)
+	for _, dataChunkCount := range []int{128, 64} {
+		chunks, merkleRoot := ChunkBlockWithCounts(block, dataChunkCount, 128)
+
+		for _, c := range chunks {
+			if err := VerifyChunk(merkleRoot, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		reconstructed, err := ReconstructBlock(chunks[len(chunks)-dataChunkCount:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(reconstructed.Hash(), block.Hash()) {
+			t.Errorf("reconstructed block with %d data chunks does not match the block", dataChunkCount)
+		}
+	}
+}
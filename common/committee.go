@@ -0,0 +1,60 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// ReferenceCommittee is the committee whose chain derives the seeds of the epochs, the other committees follow its chain
+const ReferenceCommittee = 0
+
+// AssignCommittees assigns the validators to committeeCount committees using the seed of an epoch.
+// The validators are permuted using the seed, and the permutation is split into committees whose sizes differ by at most one.
+// It returns the indexes of the validators of each committee in increasing order.
+func AssignCommittees(validatorCount int, seed []byte, epoch int, committeeCount int) [][]int {
+
+	if committeeCount < 1 || committeeCount > validatorCount {
+		panic(fmt.Errorf("illegal committee count %d of %d validators", committeeCount, validatorCount))
+	}
+
+	h := sha256.New()
+	h.Write([]byte("rapidchain committees"))
+	e := newEncoder(h)
+	e.writeBytes(seed)
+	e.writeInt(epoch)
+	digest := h.Sum(nil)
+
+	source := rand.NewSource(int64(binary.BigEndian.Uint64(digest[:8])))
+	permutation := rand.New(source).Perm(validatorCount)
+
+	committees := make([][]int, committeeCount)
+	for position, index := range permutation {
+		committee := position % committeeCount
+		committees[committee] = append(committees[committee], index)
+	}
+
+	for _, committee := range committees {
+		sort.Ints(committee)
+	}
+
+	return committees
+}
+
+// Subset returns the validator set of the validators at the indexes, in the given order
+func (vs *ValidatorSet) Subset(indexes []int) *ValidatorSet {
+
+	var publicKeys [][]byte
+	for _, index := range indexes {
+		publicKeys = append(publicKeys, vs.publicKeys[index])
+	}
+
+	subset, err := NewValidatorSet(publicKeys)
+	if err != nil {
+		panic(err)
+	}
+
+	return subset
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignCommittees(t *testing.T) {
+
+	seed := []byte{1, 2, 3}
+	committees := AssignCommittees(10, seed, 1, 3)
+
+	if len(committees) != 3 {
+		t.Fatalf("expected 3 committees, got %d", len(committees))
+	}
+
+	// every validator is a member of one committee, and the sizes differ by at most one
+	assigned := make(map[int]bool)
+	for _, members := range committees {
+		if len(members) < 3 || len(members) > 4 {
+			t.Errorf("unexpected committee size %d", len(members))
+		}
+
+		for _, member := range members {
+			if assigned[member] {
+				t.Errorf("validator %d is assigned twice", member)
+			}
+			assigned[member] = true
+		}
+	}
+
+	if len(assigned) != 10 {
+		t.Errorf("expected 10 assigned validators, got %d", len(assigned))
+	}
+
+	if !reflect.DeepEqual(committees, AssignCommittees(10, seed, 1, 3)) {
+		t.Errorf("the assignment is not deterministic")
+	}
+
+	if reflect.DeepEqual(committees, AssignCommittees(10, []byte{4, 5, 6}, 1, 3)) && reflect.DeepEqual(committees, AssignCommittees(10, seed, 2, 3)) {
+		t.Errorf("the assignment does not depend on the seed and the epoch")
+	}
+}
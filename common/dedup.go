@@ -0,0 +1,213 @@
+package common
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Deduplicator remembers the IDs of the processed messages.
+// It is called by the demultiplexer under its lock, implementations do not need to be thread safe.
+type Deduplicator interface {
+	// Contains returns true if the message is already processed
+	Contains(round int, id MessageID) bool
+
+	// Add marks the message as processed
+	Add(round int, id MessageID)
+
+	// DeleteRoundsBefore forgets the messages of the rounds before the provided round
+	DeleteRoundsBefore(round int)
+}
+
+// NewDeduplicator creates the deduplication backend with the given name: "exact", "lru" or "bloom".
+// capacity is the number of IDs kept by the lru backend, and the number of IDs per filter generation of the bloom backend.
+func NewDeduplicator(backend string, capacity int, falsePositiveRate float64) (Deduplicator, error) {
+
+	switch backend {
+	case "", "exact":
+		return NewExactDeduplicator(), nil
+	case "lru":
+		if capacity < 1 {
+			return nil, fmt.Errorf("lru deduplicator requires a positive capacity")
+		}
+		return NewLRUDeduplicator(capacity), nil
+	case "bloom":
+		if capacity < 1 || falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+			return nil, fmt.Errorf("bloom deduplicator requires a positive capacity and a false positive rate between 0 and 1")
+		}
+		return NewBloomDeduplicator(capacity, falsePositiveRate), nil
+	default:
+		return nil, fmt.Errorf("unknown deduplicator %q", backend)
+	}
+}
+
+// ExactDeduplicator keeps all the IDs of the rounds that are not deleted
+type ExactDeduplicator struct {
+	rounds map[int]map[MessageID]struct{}
+}
+
+func NewExactDeduplicator() *ExactDeduplicator {
+	return &ExactDeduplicator{rounds: make(map[int]map[MessageID]struct{})}
+}
+
+func (d *ExactDeduplicator) Contains(round int, id MessageID) bool {
+	_, ok := d.rounds[round][id]
+	return ok
+}
+
+func (d *ExactDeduplicator) Add(round int, id MessageID) {
+
+	ids, ok := d.rounds[round]
+	if !ok {
+		ids = make(map[MessageID]struct{})
+		d.rounds[round] = ids
+	}
+
+	ids[id] = struct{}{}
+}
+
+func (d *ExactDeduplicator) DeleteRoundsBefore(round int) {
+
+	for r := range d.rounds {
+		if r < round {
+			delete(d.rounds, r)
+		}
+	}
+}
+
+// LRUDeduplicator keeps the most recently seen IDs up to its capacity.
+// A message is processed again if its ID is evicted.
+type LRUDeduplicator struct {
+	capacity int
+
+	// elements are lruEntry, the front is the most recently seen
+	entries *list.List
+	index   map[MessageID]*list.Element
+}
+
+type lruEntry struct {
+	round int
+	id    MessageID
+}
+
+func NewLRUDeduplicator(capacity int) *LRUDeduplicator {
+	return &LRUDeduplicator{capacity: capacity, entries: list.New(), index: make(map[MessageID]*list.Element)}
+}
+
+func (d *LRUDeduplicator) Contains(round int, id MessageID) bool {
+
+	element, ok := d.index[id]
+	if ok {
+		d.entries.MoveToFront(element)
+	}
+
+	return ok
+}
+
+func (d *LRUDeduplicator) Add(round int, id MessageID) {
+
+	if element, ok := d.index[id]; ok {
+		d.entries.MoveToFront(element)
+		return
+	}
+
+	d.index[id] = d.entries.PushFront(lruEntry{round: round, id: id})
+
+	if d.entries.Len() > d.capacity {
+		oldest := d.entries.Back()
+		d.entries.Remove(oldest)
+		delete(d.index, oldest.Value.(lruEntry).id)
+	}
+}
+
+func (d *LRUDeduplicator) DeleteRoundsBefore(round int) {
+
+	for element := d.entries.Front(); element != nil; {
+		next := element.Next()
+		if entry := element.Value.(lruEntry); entry.round < round {
+			d.entries.Remove(element)
+			delete(d.index, entry.id)
+		}
+		element = next
+	}
+}
+
+// BloomDeduplicator keeps IDs in two generations of Bloom filters.
+// When the current filter reaches its capacity, it becomes the previous filter and the older one is discarded.
+// A new message is dropped with the configured false positive rate.
+type BloomDeduplicator struct {
+	capacity  int
+	bitCount  uint64
+	hashCount int
+
+	current  []uint64
+	previous []uint64
+
+	// number of IDs added to the current filter
+	count int
+}
+
+// NewBloomDeduplicator creates filters sized for capacity IDs with the false positive rate
+func NewBloomDeduplicator(capacity int, falsePositiveRate float64) *BloomDeduplicator {
+
+	// optimal number of bits and hash functions
+	bitCount := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashCount := int(math.Max(1, math.Round(float64(bitCount)/float64(capacity)*math.Ln2)))
+
+	d := &BloomDeduplicator{capacity: capacity, bitCount: bitCount, hashCount: hashCount}
+	d.current = d.newFilter()
+	d.previous = d.newFilter()
+
+	return d
+}
+
+func (d *BloomDeduplicator) Contains(round int, id MessageID) bool {
+	return d.test(d.current, id) || d.test(d.previous, id)
+}
+
+func (d *BloomDeduplicator) Add(round int, id MessageID) {
+
+	if d.count == d.capacity {
+		d.previous = d.current
+		d.current = d.newFilter()
+		d.count = 0
+	}
+
+	for _, bit := range d.bits(id) {
+		d.current[bit/64] |= 1 << (bit % 64)
+	}
+	d.count++
+}
+
+// DeleteRoundsBefore does nothing, the round is part of the ID and the old IDs leave the filters by rotation
+func (d *BloomDeduplicator) DeleteRoundsBefore(round int) {}
+
+func (d *BloomDeduplicator) newFilter() []uint64 {
+	return make([]uint64, (d.bitCount+63)/64)
+}
+
+func (d *BloomDeduplicator) test(filter []uint64, id MessageID) bool {
+
+	for _, bit := range d.bits(id) {
+		if filter[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bits derives the positions from the two halves of the ID, IDs are already uniformly distributed
+func (d *BloomDeduplicator) bits(id MessageID) []uint64 {
+
+	h1 := binary.BigEndian.Uint64(id[:8])
+	h2 := binary.BigEndian.Uint64(id[8:])
+
+	bits := make([]uint64, d.hashCount)
+	for i := range bits {
+		bits[i] = (h1 + uint64(i)*h2) % d.bitCount
+	}
+
+	return bits
+}
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+func testMessageID(i int) MessageID {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(i))
+	digest := sha256.Sum256(data[:])
+
+	var id MessageID
+	copy(id[:], digest[:])
+	return id
+}
+
+func TestDeduplicators(t *testing.T) {
+
+	deduplicators := map[string]Deduplicator{
+		"exact": NewExactDeduplicator(),
+		"lru":   NewLRUDeduplicator(100),
+		"bloom": NewBloomDeduplicator(100, 0.001),
+	}
+
+	for name, d := range deduplicators {
+		t.Run(name, func(t *testing.T) {
+
+			for i := 0; i < 50; i++ {
+				d.Add(1, testMessageID(i))
+			}
+
+			for i := 0; i < 50; i++ {
+				if !d.Contains(1, testMessageID(i)) {
+					t.Fatalf("message %d is not found", i)
+				}
+			}
+
+			falsePositives := 0
+			for i := 50; i < 1050; i++ {
+				if d.Contains(1, testMessageID(i)) {
+					falsePositives++
+				}
+			}
+
+			if falsePositives > 5 {
+				t.Errorf("%d false positives out of 1000 messages", falsePositives)
+			}
+		})
+	}
+}
+
+func TestLRUDeduplicatorEviction(t *testing.T) {
+
+	d := NewLRUDeduplicator(2)
+	d.Add(1, testMessageID(0))
+	d.Add(1, testMessageID(1))
+
+	// makes 0 the most recently seen
+	d.Contains(1, testMessageID(0))
+	d.Add(2, testMessageID(2))
+
+	if !d.Contains(1, testMessageID(0)) || d.Contains(1, testMessageID(1)) {
+		t.Errorf("expected the least recently seen message to be evicted")
+	}
+
+	d.DeleteRoundsBefore(2)
+	if d.Contains(1, testMessageID(0)) || !d.Contains(2, testMessageID(2)) {
+		t.Errorf("expected the messages of round 1 to be deleted")
+	}
+}
+
+func TestBloomDeduplicatorRotation(t *testing.T) {
+
+	d := NewBloomDeduplicator(10, 0.01)
+	for i := 0; i < 20; i++ {
+		d.Add(1, testMessageID(i))
+	}
+
+	// the first generation is still kept as the previous filter
+	if !d.Contains(1, testMessageID(0)) || !d.Contains(1, testMessageID(19)) {
+		t.Errorf("expected the messages of the last two generations to be found")
+	}
+
+	d.Add(1, testMessageID(20))
+	found := 0
+	for i := 0; i < 10; i++ {
+		if d.Contains(1, testMessageID(i)) {
+			found++
+		}
+	}
+
+	if found > 2 {
+		t.Errorf("expected the first generation to be discarded, %d of its messages are found", found)
+	}
+}
+
+func TestMessageIDs(t *testing.T) {
+
+	chunk := BlockChunk{Issuer: []byte{1}, Round: 3, ChunkCount: 8, DataChunkCount: 6, ChunkIndex: 2, Payload: []byte("payload")}
+	chunk.Authenticator.MerkleRoot = []byte("root")
+
+	// payload is not part of the ID
+	samePosition := chunk
+	samePosition.Payload = []byte("other payload")
+	if chunk.ID() != samePosition.ID() {
+		t.Errorf("expected the same ID for the chunks at the same position")
+	}
+
+	otherIndex := chunk
+	otherIndex.ChunkIndex = 3
+	if chunk.ID() == otherIndex.ID() {
+		t.Errorf("expected different IDs for different chunk indexes")
+	}
+
+	vote := Vote{Issuer: []byte{1}, Tag: EchoTag, Round: 3, BlockHash: [][]byte{{1}}, Signature: []byte{1}}
+	otherTag := vote
+	otherTag.Tag = AcceptTag
+	if vote.ID() == otherTag.ID() {
+		t.Errorf("expected different IDs for different vote tags")
+	}
+}
@@ -1,13 +1,40 @@
 package common
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"sync"
 )
 
-const (
-	channelCapacity = 1024
-)
+// DefaultQueueCapacity is the default capacity of the per-round queues
+const DefaultQueueCapacity = 1024
+
+// DefaultFutureRoundWindow is the default number of rounds after the current round whose messages are accepted
+const DefaultFutureRoundWindow = 8
+
+// ErrRoundOutOfWindow is returned if a message belongs to a round beyond the acceptance window
+var ErrRoundOutOfWindow = errors.New("round is beyond the acceptance window")
+
+// ErrUnknownMessageKind is returned if the kind of a message is not registered
+var ErrUnknownMessageKind = errors.New("message kind is not registered")
+
+// KindConfig defines the queues and the validation of a message kind
+type KindConfig struct {
+	Queue QueueConfig
+
+	// Validate is called before a message is enqueued, and the message is rejected with the returned error.
+	// It is called without holding the lock of the demultiplexer. It can be nil.
+	Validate func(message Message) error
+}
+
+// messageKind keeps the per-round queues of a message kind
+type messageKind struct {
+	config KindConfig
+	stats  QueueStats
+	queues map[int]chan Message
+}
 
 // Demux provides message multiplexing service
 // Network and consensus layer communicate using demux
@@ -16,95 +43,173 @@ type Demux struct {
 
 	currentRound int
 
-	// it is used to filter already processed messages
-	processedMessageMap map[int]map[string]struct{}
+	// the highest round of the received messages, it is used to detect that the node is behind its peers
+	highestRound int
 
-	proposeVoteChanMap map[int]chan Vote
+	// messages of the rounds after currentRound+futureRoundWindow are rejected,
+	// so that peers can not make the node allocate queues for arbitrary rounds
+	futureRoundWindow int
+
+	// it is used to filter already processed messages
+	deduplicator Deduplicator
 
-	echoVoteChanMap map[int]chan Vote
+	kinds map[MessageKind]*messageKind
 
-	acceptVoteChanMap map[int]chan Vote
+	// address of the peer that delivered each enqueued message, so that the messages rejected later are attributed to their senders
+	senders map[int]map[MessageID]string
 
-	blockChunkChanMap map[int]chan BlockChunk
+	rejections *RejectionLog
 }
 
-// NewDemultiplexer creates a new demultiplexer with initial round value
+// NewDemultiplexer creates a new demultiplexer with initial round value.
+// The kinds of block chunks, block announcements, and votes are registered.
 func NewDemultiplexer(initialRound int) *Demux {
 
-	demux := &Demux{currentRound: initialRound}
+	demux := &Demux{currentRound: initialRound, highestRound: initialRound, futureRoundWindow: DefaultFutureRoundWindow}
 
-	demux.processedMessageMap = make(map[int]map[string]struct{})
-	demux.proposeVoteChanMap = make(map[int]chan Vote)
-	demux.echoVoteChanMap = make(map[int]chan Vote)
-	demux.acceptVoteChanMap = make(map[int]chan Vote)
-	demux.blockChunkChanMap = make(map[int]chan BlockChunk)
+	demux.deduplicator = NewExactDeduplicator()
+	demux.kinds = make(map[MessageKind]*messageKind)
+	demux.senders = make(map[int]map[MessageID]string)
+	demux.rejections = NewRejectionLog()
+
+	for _, kind := range []MessageKind{BlockChunkKind, BlockAnnouncementKind, ProposeVoteKind, EchoVoteKind, AcceptVoteKind, TimeoutVoteKind} {
+		err := demux.RegisterKind(kind, KindConfig{Queue: QueueConfig{Capacity: DefaultQueueCapacity, Policy: DropNewest}})
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	return demux
 }
 
-// EnqueBlockChunk enques a block chunk to be the consumed by consensus layer
-func (d *Demux) EnqueBlockChunk(chunk BlockChunk) {
+// RegisterKind registers a message kind, the messages of the kind are routed to their own per-round queues
+func (d *Demux) RegisterKind(kind MessageKind, config KindConfig) error {
 
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	if chunk.Round < d.currentRound {
-		// discarts a chunks because it belongs to a previous round
-		return
+	if _, ok := d.kinds[kind]; ok {
+		return fmt.Errorf("message kind %q is already registered", kind)
 	}
 
-	chunkRound := chunk.Round
-	chunkHash := string(chunk.Hash())
-	if d.isProcessed(chunkRound, chunkHash) {
-		// chunk is already processed
-		return
+	if config.Queue.Capacity < 1 {
+		return fmt.Errorf("illegal queue capacity %d", config.Queue.Capacity)
 	}
 
-	chunkChan := d.getCorrespondingBlockChunkChan(chunkRound)
-	chunkChan <- chunk
+	d.kinds[kind] = &messageKind{config: config, queues: make(map[int]chan Message)}
 
-	d.markAsProcessed(chunkRound, chunkHash)
+	return nil
 }
 
-// EnqueVote enques a vote to be consumed by the consensus layer
-func (d *Demux) EnqueVote(vote Vote) {
+// Kinds returns the registered message kinds
+func (d *Demux) Kinds() []MessageKind {
 
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	if vote.Round < d.currentRound {
-		// discarts a round because it belongs to a previous round
-		return
+	var kinds []MessageKind
+	for kind := range d.kinds {
+		kinds = append(kinds, kind)
 	}
 
-	voteRound := vote.Round
-	voteHash := string(vote.Hash())
-	if d.isProcessed(voteRound, voteHash) {
-		// vote is already processed
-		return
-	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
 
-	voteChan := d.getCorrespondingVoteChan(vote.Round, vote.Tag)
-	voteChan <- vote
+	return kinds
+}
 
-	d.markAsProcessed(voteRound, voteHash)
+// Enque enques a message that is not received from a peer, see EnqueFrom
+func (d *Demux) Enque(message Message) error {
+	return d.EnqueFrom("", message)
 }
 
-// GetVoteChan returns vote channel
-func (d *Demux) GetVoteChan(round int, tag byte) (chan Vote, error) {
+// EnqueFrom enques a message delivered by a peer to be consumed by the consensus layer, or by the subsystem that registered its kind.
+// It never blocks. Messages of the previous rounds and already processed messages are discarded silently.
+// An error is returned if the message is rejected.
+func (d *Demux) EnqueFrom(peer string, message Message) error {
+
+	kind := message.Kind()
+	round := message.MessageRound()
+	id := message.ID()
+
+	d.mutex.Lock()
+	k, err := d.admit(kind, round, id)
+	var validate func(Message) error
+	if k != nil {
+		validate = k.config.Validate
+	}
+	d.mutex.Unlock()
+
+	if k == nil {
+		return err
+	}
+
+	if validate != nil {
+		if err := validate(message); err != nil {
+			return err
+		}
+	}
 
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
+	// the round or the processed messages may have changed during the validation
 	if round < d.currentRound {
-		return nil, fmt.Errorf("the current round value is bigger than the provided round value")
+		return nil
 	}
 
-	return d.getCorrespondingVoteChan(round, tag), nil
+	d.updateHighestRound(round)
+	if d.deduplicator.Contains(round, id) {
+		return nil
+	}
+
+	enqueued, err := k.stats.enqueue(k.config.Queue.Policy, d.getQueue(k, round), message)
+
+	// a dropped message is not marked, so that it can be received again
+	if enqueued {
+		d.deduplicator.Add(round, id)
+		d.addSender(round, id, peer)
+	}
+
+	return err
 }
 
-// GetVoteBlockChunkChan returns Blockchunk channel
-func (d *Demux) GetVoteBlockChunkChan(round int) (chan BlockChunk, error) {
+// Reject logs a message that is rejected by its consumer, and counts it for its sender and its issuer
+func (d *Demux) Reject(message Message, err error) {
+
+	round := message.MessageRound()
+
+	d.mutex.Lock()
+	peer := d.senders[round][message.ID()]
+	d.mutex.Unlock()
+
+	issuer := MessageIssuer(message)
+	d.rejections.Count(peer, issuer, err)
+
+	log.Printf("rejected %s message of round %d from peer %q issued by %s: %s\n", message.Kind(), round, peer, EncodeIssuer(issuer), err)
+}
+
+// RejectRelayed rejects a message whose unsigned part is not valid, e.g. the accept proof of a vote.
+// The part may be altered by a relaying peer, the rejection is counted against the sending peer but not the issuer.
+func (d *Demux) RejectRelayed(message Message, err error) {
+
+	round := message.MessageRound()
+
+	d.mutex.Lock()
+	peer := d.senders[round][message.ID()]
+	d.mutex.Unlock()
+
+	d.rejections.Count(peer, nil, err)
+
+	log.Printf("rejected %s message of round %d relayed by peer %q: %s\n", message.Kind(), round, peer, err)
+}
+
+// Rejections returns the log of the rejected messages, it is shared with the network layer
+func (d *Demux) Rejections() *RejectionLog {
+	return d.rejections
+}
+
+// GetChan returns the channel of the messages of a kind in a round
+func (d *Demux) GetChan(round int, kind MessageKind) (chan Message, error) {
 
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
@@ -113,19 +218,24 @@ func (d *Demux) GetVoteBlockChunkChan(round int) (chan BlockChunk, error) {
 		return nil, fmt.Errorf("the current round value is bigger than the provided round value")
 	}
 
-	return d.getCorrespondingBlockChunkChan(round), nil
+	k, ok := d.kinds[kind]
+	if !ok {
+		return nil, ErrUnknownMessageKind
+	}
+
+	return d.getQueue(k, round), nil
 }
 
 // UpdateRound updates the round.
 // All messages blongs to the previous rounds discarted
-// Update round mustbe called by an increased round number otherwise this function panics
+// Update round mustbe called by an increased round number otherwise this function panics.
+// The round can jump forward if the node catches up with its peers.
 func (d *Demux) UpdateRound(round int) {
 
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	// Round value should increase one by one
-	if round < d.currentRound || round != (d.currentRound+1) {
+	if round <= d.currentRound {
 		panic(fmt.Errorf("illegal round value, current round value %d, provided round value %d", d.currentRound, round))
 	}
 
@@ -133,85 +243,183 @@ func (d *Demux) UpdateRound(round int) {
 	d.deletePreviousRoundMessages()
 }
 
-// All the following functions are helper functions.
-// They must be called from previous functions because
-// they are not thread safe!
+// CurrentRound returns the current round
+func (d *Demux) CurrentRound() int {
 
-func (d *Demux) deletePreviousRoundMessages() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.currentRound
+}
 
-	previousRound := d.currentRound - 1
+// HighestRound returns the highest round of the received messages that are in the window and validated
+func (d *Demux) HighestRound() int {
 
-	delete(d.processedMessageMap, previousRound)
-	delete(d.proposeVoteChanMap, previousRound)
-	delete(d.echoVoteChanMap, previousRound)
-	delete(d.acceptVoteChanMap, previousRound)
-	delete(d.blockChunkChanMap, previousRound)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
+	return d.highestRound
 }
 
-func (d *Demux) getProcessedMessageMap(round int) map[string]struct{} {
+// SetDeduplicator replaces the deduplication backend, the messages processed before the call are forgotten
+func (d *Demux) SetDeduplicator(deduplicator Deduplicator) {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.deduplicator = deduplicator
+}
 
-	if val, ok := d.processedMessageMap[round]; ok {
-		return val
+// SetQueueConfig sets the capacity and the overflow policy of the queues of a message kind.
+// The capacity applies to the queues created after the call.
+func (d *Demux) SetQueueConfig(kind MessageKind, config QueueConfig) {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	k, ok := d.kinds[kind]
+	if !ok {
+		panic(fmt.Errorf("message kind %q is not registered", kind))
 	}
 
-	val := make(map[string]struct{})
-	d.processedMessageMap[round] = val
+	if config.Capacity < 1 {
+		panic(fmt.Errorf("illegal queue capacity %d", config.Capacity))
+	}
 
-	return val
+	k.config.Queue = config
 }
 
-func (d *Demux) isProcessed(round int, hash string) bool {
+// SetValidator sets the function that validates the messages of a message kind before they are enqueued
+func (d *Demux) SetValidator(kind MessageKind, validate func(message Message) error) {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	k, ok := d.kinds[kind]
+	if !ok {
+		panic(fmt.Errorf("message kind %q is not registered", kind))
+	}
 
-	processedMessageMap := d.getProcessedMessageMap(round)
-	chunkHashString := string(hash)
-	_, ok := processedMessageMap[chunkHashString]
-	return ok
+	k.config.Validate = validate
 }
 
-func (d *Demux) markAsProcessed(round int, hash string) {
+// SetFutureRoundWindow sets the number of rounds after the current round whose messages are accepted
+func (d *Demux) SetFutureRoundWindow(window int) {
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if window < 1 {
+		panic(fmt.Errorf("illegal future round window %d", window))
+	}
 
-	processedMessageMap := d.getProcessedMessageMap(round)
-	processedMessageMap[hash] = struct{}{}
+	d.futureRoundWindow = window
 }
 
-func (d *Demux) getCorrespondingVoteChan(round int, tag byte) chan Vote {
+// QueueStats returns the counters of the queues of each message kind
+func (d *Demux) QueueStats() map[MessageKind]QueueStats {
 
-	var correspondingVoteMap map[int]chan Vote
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
-	switch tag {
-	case ProposeTag:
-		correspondingVoteMap = d.proposeVoteChanMap
-		break
-	case EchoTag:
-		correspondingVoteMap = d.echoVoteChanMap
-		break
+	stats := make(map[MessageKind]QueueStats)
+	for kind, k := range d.kinds {
+		s := k.stats
+		for _, queue := range k.queues {
+			s.Depth += len(queue)
+		}
+		stats[kind] = s
+	}
 
-	case AcceptTag:
-		correspondingVoteMap = d.acceptVoteChanMap
-		break
-	default:
-		panic(fmt.Errorf("unknown tag received %b", tag))
+	return stats
+}
+
+// All the following functions are helper functions.
+// They must be called from previous functions because
+// they are not thread safe!
+
+// admit returns the kind of a message if the message should be enqueued.
+// It returns nil if the message is discarded, and the error if the message is rejected.
+func (d *Demux) admit(kind MessageKind, round int, id MessageID) (*messageKind, error) {
+
+	k, ok := d.kinds[kind]
+	if !ok {
+		return nil, ErrUnknownMessageKind
+	}
+
+	if round < d.currentRound {
+		// discarts a message because it belongs to a previous round
+		return nil, nil
+	}
+
+	if !d.isInWindow(round) {
+		k.stats.OutOfWindow++
+		return nil, ErrRoundOutOfWindow
 	}
 
-	if val, ok := correspondingVoteMap[round]; ok {
-		return val
+	if d.deduplicator.Contains(round, id) {
+		// message is already processed
+		return nil, nil
+	}
+
+	return k, nil
+}
+
+func (d *Demux) deletePreviousRoundMessages() {
+
+	d.deduplicator.DeleteRoundsBefore(d.currentRound)
+
+	for round := range d.senders {
+		if round < d.currentRound {
+			delete(d.senders, round)
+		}
 	}
 
-	voteChan := make(chan Vote, channelCapacity)
-	correspondingVoteMap[round] = voteChan
+	for _, k := range d.kinds {
+		for round := range k.queues {
+			if round < d.currentRound {
+				delete(k.queues, round)
+			}
+		}
+	}
+}
+
+func (d *Demux) isInWindow(round int) bool {
+	return round <= d.currentRound+d.futureRoundWindow
+}
+
+// the highest round is updated only by the messages in the window that are validated,
+// otherwise a single forged message of a far round would make the node catch up in every round
+func (d *Demux) updateHighestRound(round int) {
+
+	if round > d.highestRound {
+		d.highestRound = round
+	}
+}
+
+func (d *Demux) addSender(round int, id MessageID, peer string) {
+
+	if peer == "" {
+		return
+	}
+
+	senders, ok := d.senders[round]
+	if !ok {
+		senders = make(map[MessageID]string)
+		d.senders[round] = senders
+	}
 
-	return voteChan
+	senders[id] = peer
 }
 
-func (d *Demux) getCorrespondingBlockChunkChan(round int) chan BlockChunk {
+func (d *Demux) getQueue(k *messageKind, round int) chan Message {
 
-	if val, ok := d.blockChunkChanMap[round]; ok {
-		return val
+	if queue, ok := k.queues[round]; ok {
+		return queue
 	}
 
-	chunkChan := make(chan BlockChunk, channelCapacity)
-	d.blockChunkChanMap[round] = chunkChan
+	queue := make(chan Message, k.config.Queue.Capacity)
+	k.queues[round] = queue
 
-	return chunkChan
+	return queue
 }
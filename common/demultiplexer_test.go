@@ -1,6 +1,10 @@
 package common
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -20,15 +24,15 @@ func TestDemultiplexer(t *testing.T) {
 	chunks, _ := ChunkBlock(block, chunkCount)
 
 	for i := range chunks {
-		demux.EnqueBlockChunk(chunks[i])
+		demux.Enque(chunks[i])
 	}
 
 	// try to reenque, it is not allowed
 	for i := range chunks {
-		demux.EnqueBlockChunk(chunks[i])
+		demux.Enque(chunks[i])
 	}
 
-	chunkChan, err := demux.GetVoteBlockChunkChan(currentRound)
+	chunkChan, err := demux.GetChan(currentRound, BlockChunkKind)
 
 	if err != nil {
 		t.Error(err)
@@ -42,15 +46,28 @@ func TestDemultiplexer(t *testing.T) {
 		t.Errorf("expected chunk count is %d received %d chunk", chunkCount, len(chunkChan))
 	}
 
+	announcement := BlockAnnouncement{Issuer: block.Issuer, Round: currentRound, MerkleRoot: getRandomByteSlice(32), ChunkCount: chunkCount, DataChunkCount: chunkCount}
+	demux.Enque(announcement)
+	demux.Enque(announcement)
+
+	announcementChan, err := demux.GetChan(currentRound, BlockAnnouncementKind)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(announcementChan) != 1 {
+		t.Errorf("expected announcement count is 1 received %d announcement", len(announcementChan))
+	}
+
 	demux.UpdateRound(2)
-	chunkChan, err = demux.GetVoteBlockChunkChan(currentRound)
+	chunkChan, err = demux.GetChan(currentRound, BlockChunkKind)
 
 	if err == nil {
 		t.Errorf("expecting non nil error because try to access the previous round value")
 	}
 
 	currentRound = 2
-	chunkChan, err = demux.GetVoteBlockChunkChan(currentRound)
+	chunkChan, err = demux.GetChan(currentRound, BlockChunkKind)
 
 	if err != nil {
 		t.Error(err)
@@ -67,12 +84,12 @@ func TestDemultiplexer(t *testing.T) {
 			BlockHash: [][]byte{getRandomByteSlice(32)},
 		}
 
-		demux.EnqueVote(v)
-		demux.EnqueVote(v)
-		demux.EnqueVote(v)
+		demux.Enque(v)
+		demux.Enque(v)
+		demux.Enque(v)
 	}
 
-	proposeVoteChan, err := demux.GetVoteChan(currentRound, ProposeTag)
+	proposeVoteChan, err := demux.GetChan(currentRound, VoteKind(ProposeTag))
 	if err != nil {
 		t.Error(err)
 	}
@@ -89,12 +106,12 @@ func TestDemultiplexer(t *testing.T) {
 			BlockHash: [][]byte{getRandomByteSlice(32)},
 		}
 
-		demux.EnqueVote(v)
-		demux.EnqueVote(v)
-		demux.EnqueVote(v)
+		demux.Enque(v)
+		demux.Enque(v)
+		demux.Enque(v)
 	}
 
-	echoVoteChan, err := demux.GetVoteChan(currentRound, EchoTag)
+	echoVoteChan, err := demux.GetChan(currentRound, VoteKind(EchoTag))
 	if err != nil {
 		t.Error(err)
 	}
@@ -103,7 +120,7 @@ func TestDemultiplexer(t *testing.T) {
 		t.Errorf("expected propose vote count is %d received %d propose vote", voteCount, len(echoVoteChan))
 	}
 
-	acceptVoteChan, err := demux.GetVoteChan(currentRound, AcceptTag)
+	acceptVoteChan, err := demux.GetChan(currentRound, VoteKind(AcceptTag))
 	if err != nil {
 		t.Error(err)
 	}
@@ -121,9 +138,9 @@ func TestDemultiplexer(t *testing.T) {
 		}
 
 		// only sigle value will be enqueued
-		demux.EnqueVote(v)
-		demux.EnqueVote(v)
-		demux.EnqueVote(v)
+		demux.Enque(v)
+		demux.Enque(v)
+		demux.Enque(v)
 	}
 
 	if len(acceptVoteChan) != voteCount {
@@ -131,3 +148,205 @@ func TestDemultiplexer(t *testing.T) {
 	}
 
 }
+
+func TestDemultiplexerOverflowPolicies(t *testing.T) {
+
+	newVote := func(i int) Vote {
+		return Vote{Issuer: []byte{byte(i)}, Tag: EchoTag, Round: 1, BlockHash: [][]byte{{1}}}
+	}
+
+	testCases := []struct {
+		policy        OverflowPolicy
+		expectedFirst byte
+		expectedErr   error
+		expectedStats QueueStats
+	}{
+		{policy: DropNewest, expectedFirst: 0, expectedStats: QueueStats{Depth: 2, MaxDepth: 2, Enqueued: 2, DroppedNewest: 2}},
+		{policy: DropOldest, expectedFirst: 2, expectedStats: QueueStats{Depth: 2, MaxDepth: 2, Enqueued: 4, DroppedOldest: 2}},
+		{policy: Reject, expectedFirst: 0, expectedErr: ErrQueueFull, expectedStats: QueueStats{Depth: 2, MaxDepth: 2, Enqueued: 2, Rejected: 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+
+			demux := NewDemultiplexer(1)
+			demux.SetQueueConfig(EchoVoteKind, QueueConfig{Capacity: 2, Policy: tc.policy})
+
+			var err error
+			for i := 0; i < 4; i++ {
+				// enqueueing never blocks
+				err = demux.Enque(newVote(i))
+			}
+
+			if err != tc.expectedErr {
+				t.Errorf("expected error %v, received %v", tc.expectedErr, err)
+			}
+
+			stats := demux.QueueStats()[EchoVoteKind]
+			if stats != tc.expectedStats {
+				t.Errorf("expected stats %+v, received %+v", tc.expectedStats, stats)
+			}
+
+			voteChan, _ := demux.GetChan(1, EchoVoteKind)
+			if first := (<-voteChan).(Vote); first.Issuer[0] != tc.expectedFirst {
+				t.Errorf("expected the first vote of issuer %d, received issuer %d", tc.expectedFirst, first.Issuer[0])
+			}
+
+			// a message that is not enqueued can be received again when there is room
+			if tc.policy != DropOldest {
+				demux.Enque(newVote(3))
+				if len(voteChan) != 2 {
+					t.Errorf("expected the dropped vote to be enqueued again")
+				}
+			}
+		})
+	}
+}
+
+func TestDemultiplexerFutureRoundWindow(t *testing.T) {
+
+	demux := NewDemultiplexer(1)
+	demux.SetFutureRoundWindow(2)
+
+	// the messages rejected by the validator do not raise the highest round
+	demux.SetValidator(EchoVoteKind, func(message Message) error {
+		if message.(Vote).Issuer[0] == 2 {
+			return fmt.Errorf("forged vote")
+		}
+		return nil
+	})
+
+	forged := Vote{Issuer: []byte{2}, Tag: EchoTag, Round: 3, BlockHash: [][]byte{{1}}}
+	if demux.Enque(forged) == nil {
+		t.Errorf("expected the forged vote to be rejected")
+	}
+
+	if demux.HighestRound() != 1 {
+		t.Errorf("expected the highest round to be 1, it is %d", demux.HighestRound())
+	}
+
+	vote := Vote{Issuer: []byte{1}, Tag: EchoTag, Round: 3, BlockHash: [][]byte{{1}}}
+	if err := demux.Enque(vote); err != nil {
+		t.Errorf("expected the vote of round 3 to be accepted: %s", err)
+	}
+
+	vote.Round = 4
+	if err := demux.Enque(vote); err != ErrRoundOutOfWindow {
+		t.Errorf("expected ErrRoundOutOfWindow, received %v", err)
+	}
+
+	chunk := BlockChunk{Issuer: []byte{1}, Round: 100}
+	if err := demux.Enque(chunk); err != ErrRoundOutOfWindow {
+		t.Errorf("expected ErrRoundOutOfWindow, received %v", err)
+	}
+
+	// the messages beyond the window do not raise the highest round
+	if demux.HighestRound() != 3 {
+		t.Errorf("expected the highest round to be 3, it is %d", demux.HighestRound())
+	}
+
+	stats := demux.QueueStats()
+	if stats[EchoVoteKind].OutOfWindow != 1 || stats[BlockChunkKind].OutOfWindow != 1 {
+		t.Errorf("out of window counters are not correct: %+v", stats)
+	}
+
+	// the window moves with the current round
+	demux.UpdateRound(2)
+	if err := demux.Enque(vote); err != nil {
+		t.Errorf("expected the vote of round 4 to be accepted: %s", err)
+	}
+}
+
+type testMessage struct {
+	Round int
+	Value int
+}
+
+func (m testMessage) Kind() MessageKind { return "test" }
+
+func (m testMessage) MessageRound() int { return m.Round }
+
+func (m testMessage) ID() MessageID { return testMessageID(m.Value) }
+
+func TestDemultiplexerRegisteredKind(t *testing.T) {
+
+	demux := NewDemultiplexer(1)
+
+	if err := demux.Enque(testMessage{Round: 1, Value: 1}); err != ErrUnknownMessageKind {
+		t.Errorf("expected ErrUnknownMessageKind, received %v", err)
+	}
+
+	if err := demux.Enque(Vote{Tag: 'X', Round: 1}); err != ErrUnknownMessageKind {
+		t.Errorf("expected ErrUnknownMessageKind for an unknown vote tag, received %v", err)
+	}
+
+	errInvalid := fmt.Errorf("invalid test message")
+	err := demux.RegisterKind("test", KindConfig{
+		Queue: QueueConfig{Capacity: 4, Policy: DropNewest},
+		Validate: func(message Message) error {
+			if message.(testMessage).Value < 0 {
+				return errInvalid
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := demux.RegisterKind("test", KindConfig{Queue: QueueConfig{Capacity: 4}}); err == nil {
+		t.Errorf("expected an error because the kind is already registered")
+	}
+
+	if err := demux.Enque(testMessage{Round: 1, Value: -1}); err != errInvalid {
+		t.Errorf("expected the validation error, received %v", err)
+	}
+
+	demux.Enque(testMessage{Round: 1, Value: 1})
+	demux.Enque(testMessage{Round: 1, Value: 1})
+
+	testChan, err := demux.GetChan(1, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(testChan) != 1 {
+		t.Errorf("expected 1 test message, received %d", len(testChan))
+	}
+
+	// messages travel in envelopes over the network
+	RegisterMessageType(testMessage{})
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(&Envelope{Message: testMessage{Round: 1, Value: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var envelope Envelope
+	if err := gob.NewDecoder(&buf).Decode(&envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := demux.Enque(envelope.Message); err != nil || len(testChan) != 2 {
+		t.Errorf("expected the decoded message to be enqueued, error: %v", err)
+	}
+}
+
+func TestDemultiplexerRejectionAttribution(t *testing.T) {
+
+	demux := NewDemultiplexer(0)
+
+	vote := Vote{Issuer: []byte("issuer"), Tag: EchoTag, Round: 1}
+	if err := demux.EnqueFrom("10.0.0.1:1234", vote); err != nil {
+		t.Fatal(err)
+	}
+
+	demux.Reject(vote, errors.New("invalid vote"))
+
+	if count := demux.Rejections().ByPeer()["10.0.0.1:1234"]["invalid vote"]; count != 1 {
+		t.Errorf("expected 1 rejection for the peer, got %d", count)
+	}
+
+	if count := demux.Rejections().ByIssuer()[EncodeIssuer(vote.Issuer)]["invalid vote"]; count != 1 {
+		t.Errorf("expected 1 rejection for the issuer, got %d", count)
+	}
+}
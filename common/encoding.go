@@ -0,0 +1,374 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Canonical binary encoding
+//
+// Digests of protocol messages are computed as SHA-256 over a canonical binary encoding.
+// The encoding is deterministic, and it is streamed into the hash function without
+// building intermediate buffers. It is defined as follows so that other tools can reproduce the digests.
+//
+// Primitive values:
+//   byte   1 byte
+//   int    8 bytes, big-endian two's complement (int64)
+//   uint   8 bytes, big-endian unsigned (uint64)
+//   bytes  4 bytes big-endian unsigned length, followed by the raw bytes. A nil slice is encoded as an empty slice.
+//   list   4 bytes big-endian unsigned element count, followed by the elements
+//
+// Every encoded structure starts with a header of two bytes: a type tag and the encoding version of the type.
+// Fields follow in the order given below. A nested structure is embedded using its own encoding, header included.
+// Signatures are never part of the encoding of the structure they sign.
+//
+//   Block              'B' 0x03 | Issuer bytes | PrevBlockHash bytes | Round int | TxRoot bytes | BeaconProof bytes
+//   Transaction        'T' 0x01 | Sender bytes | Nonce uint | Fee uint | Payload bytes
+//   TransactionList    'L' 0x01 | list of (Transaction, Signature bytes)
+//   ChunkAuthenticator 'M' 0x01 | MerkleRoot bytes | Path list of bytes | Index list of int
+//   BlockChunk         'C' 0x02 | Issuer bytes | Round int | ChunkCount int | DataChunkCount int | ChunkIndex int |
+//                                 Authenticator ChunkAuthenticator | LeaderProof bytes | Payload bytes
+//   Vote               'V' 0x02 | Issuer bytes | Tag byte | Round int | BlockHash list of bytes | LeaderProof bytes | Proof AcceptProof
+//   AcceptProof        'Q' 0x01 | EchoVotes list of (Vote, Signature bytes)
+//   BlockAnnouncement  'N' 0x01 | Issuer bytes | Round int | MerkleRoot bytes | ChunkCount int | DataChunkCount int
+//
+// Hash() of each of these types returns SHA-256 of its encoding.
+// A vote is signed over the Hash() of the vote with an empty Proof, see Vote.SigningHash.
+// The encoding of a Block covers only the header fields, the payload of a block is a TransactionList
+// committed by TxRoot. TxRoot is the root of the Merkle tree whose leaves are the digests of the transactions,
+// see TransactionRoot. The data that is chunked to disseminate a block is the encoding of the block
+// followed by the raw payload.
+//
+// The leaves of the Merkle tree built over the chunks of a block are not encoded structures,
+// the leaf of a chunk is SHA-256(ChunkIndex int | raw Payload).
+//
+// Message IDs
+//
+// The demultiplexer deduplicates messages using IDs computed from their header fields, payloads are not hashed.
+// An ID is the first 16 bytes of SHA-256 over the following fields, the first byte separates the message types.
+//
+//   BlockChunk        'c' | Issuer bytes | Round int | ChunkCount int | DataChunkCount int | ChunkIndex int | MerkleRoot bytes |
+//                           LeaderProof bytes
+//   Vote              'v' | Issuer bytes | Tag byte | Round int | BlockHash list of bytes | Signature bytes
+//   BlockAnnouncement 'n' | Issuer bytes | Round int | MerkleRoot bytes | ChunkCount int | DataChunkCount int | Signature bytes
+
+const (
+	blockTypeTag              = 'B'
+	chunkAuthenticatorTypeTag = 'M'
+	blockChunkTypeTag         = 'C'
+	voteTypeTag               = 'V'
+	acceptProofTypeTag        = 'Q'
+	blockAnnouncementTypeTag  = 'N'
+	transactionTypeTag        = 'T'
+	transactionListTypeTag    = 'L'
+)
+
+// encodingVersions keeps the current encoding version of each type
+var encodingVersions = map[byte]byte{
+	blockTypeTag:              3,
+	chunkAuthenticatorTypeTag: 1,
+	blockChunkTypeTag:         2,
+	voteTypeTag:               2,
+	acceptProofTypeTag:        1,
+	blockAnnouncementTypeTag:  1,
+	transactionTypeTag:        1,
+	transactionListTypeTag:    1,
+}
+
+// encoder writes the canonical encoding of values to an underlying writer.
+// The first error is kept, and all the following writes are ignored.
+type encoder struct {
+	w       io.Writer
+	err     error
+	scratch [8]byte
+}
+
+func newEncoder(w io.Writer) *encoder {
+	return &encoder{w: w}
+}
+
+func (e *encoder) write(data []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(data)
+}
+
+func (e *encoder) writeHeader(typeTag byte) {
+	e.scratch[0] = typeTag
+	e.scratch[1] = encodingVersions[typeTag]
+	e.write(e.scratch[:2])
+}
+
+func (e *encoder) writeByte(b byte) {
+	e.scratch[0] = b
+	e.write(e.scratch[:1])
+}
+
+func (e *encoder) writeInt(value int) {
+	binary.BigEndian.PutUint64(e.scratch[:], uint64(int64(value)))
+	e.write(e.scratch[:8])
+}
+
+func (e *encoder) writeUint(value uint64) {
+	binary.BigEndian.PutUint64(e.scratch[:], value)
+	e.write(e.scratch[:8])
+}
+
+func (e *encoder) writeLength(length int) {
+	binary.BigEndian.PutUint32(e.scratch[:4], uint32(length))
+	e.write(e.scratch[:4])
+}
+
+func (e *encoder) writeBytes(data []byte) {
+	e.writeLength(len(data))
+	e.write(data)
+}
+
+func (e *encoder) writeBytesList(list [][]byte) {
+	e.writeLength(len(list))
+	for i := range list {
+		e.writeBytes(list[i])
+	}
+}
+
+func (e *encoder) writeIntList(list []int64) {
+	e.writeLength(len(list))
+	for i := range list {
+		e.writeInt(int(list[i]))
+	}
+}
+
+// decoder reads values encoded by encoder.
+// The first error is kept, and all the following reads return zero values.
+type decoder struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{data: data}
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || len(d.data)-d.offset < n {
+		d.err = fmt.Errorf("unexpected end of data at offset %d, %d bytes required", d.offset, n)
+		return nil
+	}
+
+	value := d.data[d.offset : d.offset+n : d.offset+n]
+	d.offset += n
+	return value
+}
+
+func (d *decoder) readHeader(typeTag byte) {
+	header := d.read(2)
+	if d.err != nil {
+		return
+	}
+
+	if header[0] != typeTag || header[1] != encodingVersions[typeTag] {
+		d.err = fmt.Errorf("unexpected header %q version %d, expected %q version %d", header[0], header[1], typeTag, encodingVersions[typeTag])
+	}
+}
+
+func (d *decoder) readUint() uint64 {
+	value := d.read(8)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+func (d *decoder) readLength() int {
+	value := d.read(4)
+	if d.err != nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(value))
+}
+
+func (d *decoder) readBytes() []byte {
+	return d.read(d.readLength())
+}
+
+// finish returns an error if there are unread bytes
+func (d *decoder) finish() error {
+	if d.err == nil && d.offset != len(d.data) {
+		d.err = fmt.Errorf("%d trailing bytes", len(d.data)-d.offset)
+	}
+	return d.err
+}
+
+// canonicalEncodable is implemented by the types that have a canonical encoding
+type canonicalEncodable interface {
+	encode(e *encoder)
+}
+
+// digest streams the canonical encoding of the value into SHA-256
+func digest(value canonicalEncodable) []byte {
+
+	h := sha256.New()
+	e := newEncoder(h)
+	value.encode(e)
+	if e.err != nil {
+		panic(e.err)
+	}
+
+	return h.Sum(nil)
+}
+
+// encodeToCanonicalBytes returns the canonical encoding of the value
+func encodeToCanonicalBytes(value canonicalEncodable) []byte {
+
+	buf := bytes.Buffer{}
+	e := newEncoder(&buf)
+	value.encode(e)
+	if e.err != nil {
+		panic(e.err)
+	}
+
+	return buf.Bytes()
+}
+
+// MessageID identifies a message for deduplication
+type MessageID [16]byte
+
+// messageID streams the fields written by writeFields into SHA-256, and truncates the digest
+func messageID(typeTag byte, writeFields func(e *encoder)) MessageID {
+
+	h := sha256.New()
+	e := newEncoder(h)
+	e.writeByte(typeTag)
+	writeFields(e)
+	if e.err != nil {
+		panic(e.err)
+	}
+
+	var id MessageID
+	copy(id[:], h.Sum(nil))
+
+	return id
+}
+
+func (b *Block) encode(e *encoder) {
+	e.writeHeader(blockTypeTag)
+	e.writeBytes(b.Issuer)
+	e.writeBytes(b.PrevBlockHash)
+	e.writeInt(b.Round)
+	e.writeBytes(b.TxRoot)
+	e.writeBytes(b.BeaconProof)
+}
+
+func (b *Block) decode(d *decoder) {
+	d.readHeader(blockTypeTag)
+	b.Issuer = d.readBytes()
+	b.PrevBlockHash = d.readBytes()
+	b.Round = int(int64(d.readUint()))
+	b.TxRoot = d.readBytes()
+	b.BeaconProof = d.readBytes()
+}
+
+func (c *ChunkAuthenticator) encode(e *encoder) {
+	e.writeHeader(chunkAuthenticatorTypeTag)
+	e.writeBytes(c.MerkleRoot)
+	e.writeBytesList(c.Path)
+	e.writeIntList(c.Index)
+}
+
+func (c *BlockChunk) encode(e *encoder) {
+	e.writeHeader(blockChunkTypeTag)
+	e.writeBytes(c.Issuer)
+	e.writeInt(c.Round)
+	e.writeInt(c.ChunkCount)
+	e.writeInt(c.DataChunkCount)
+	e.writeInt(c.ChunkIndex)
+	c.Authenticator.encode(e)
+	e.writeBytes(c.LeaderProof)
+	e.writeBytes(c.Payload)
+}
+
+func (v *Vote) encode(e *encoder) {
+	e.writeHeader(voteTypeTag)
+	e.writeBytes(v.Issuer)
+	e.writeByte(v.Tag)
+	e.writeInt(v.Round)
+	e.writeBytesList(v.BlockHash)
+	e.writeBytes(v.LeaderProof)
+	v.Proof.encode(e)
+}
+
+func (ap *AcceptProof) encode(e *encoder) {
+	e.writeHeader(acceptProofTypeTag)
+	e.writeLength(len(ap.EchoVotes))
+	for i := range ap.EchoVotes {
+		ap.EchoVotes[i].encode(e)
+		e.writeBytes(ap.EchoVotes[i].Signature)
+	}
+}
+
+func (a *BlockAnnouncement) encode(e *encoder) {
+	e.writeHeader(blockAnnouncementTypeTag)
+	e.writeBytes(a.Issuer)
+	e.writeInt(a.Round)
+	e.writeBytes(a.MerkleRoot)
+	e.writeInt(a.ChunkCount)
+	e.writeInt(a.DataChunkCount)
+}
+
+func (t *Transaction) encode(e *encoder) {
+	e.writeHeader(transactionTypeTag)
+	e.writeBytes(t.Sender)
+	e.writeUint(t.Nonce)
+	e.writeUint(t.Fee)
+	e.writeBytes(t.Payload)
+}
+
+func (t *Transaction) decode(d *decoder) {
+	d.readHeader(transactionTypeTag)
+	t.Sender = d.readBytes()
+	t.Nonce = d.readUint()
+	t.Fee = d.readUint()
+	t.Payload = d.readBytes()
+}
+
+// transactionList is the payload of a block
+type transactionList []Transaction
+
+func (l transactionList) encode(e *encoder) {
+	e.writeHeader(transactionListTypeTag)
+	e.writeLength(len(l))
+	for i := range l {
+		l[i].encode(e)
+		e.writeBytes(l[i].Signature)
+	}
+}
+
+func (l *transactionList) decode(d *decoder) {
+	d.readHeader(transactionListTypeTag)
+	count := d.readLength()
+
+	// every transaction occupies at least the header and the length prefixes
+	if count > len(d.data) {
+		d.err = fmt.Errorf("transaction count %d exceeds the data size", count)
+		return
+	}
+
+	transactions := make([]Transaction, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		var t Transaction
+		t.decode(d)
+		t.Signature = d.readBytes()
+		transactions = append(transactions, t)
+	}
+
+	*l = transactions
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// expected digests are computed by an independent implementation of the encoding
+func TestCanonicalDigests(t *testing.T) {
+
+	block := Block{
+		Issuer:        []byte{1, 2, 3},
+		PrevBlockHash: []byte{4, 5},
+		Round:         7,
+		TxRoot:        bytes.Repeat([]byte{6}, 32),
+		BeaconProof:   []byte{9, 8},
+		Payload:       []byte("not part of the digest"),
+	}
+
+	expectDigest(t, "block", block.Hash(), "ad8b2b14a97d72e71836c4742749431d5cf81bcea21e27a5e740ff1dbacb9ab3")
+
+	tx := Transaction{
+		Sender:    bytes.Repeat([]byte{1}, 32),
+		Nonce:     42,
+		Fee:       3,
+		Payload:   []byte("pay"),
+		Signature: []byte{0xff},
+	}
+
+	expectDigest(t, "transaction", tx.Hash(), "0ef5e0b8120f0a8f9b79cf61f1b08497ce4be3700405405b2257adfd5e0bbfbe")
+
+	echoVote := Vote{
+		Issuer:    []byte{0xaa},
+		Tag:       EchoTag,
+		Round:     7,
+		BlockHash: [][]byte{{0x10, 0x11}},
+		Signature: []byte{0x01},
+	}
+
+	acceptVote := Vote{
+		Issuer:    []byte{0xbb},
+		Tag:       AcceptTag,
+		Round:     7,
+		BlockHash: [][]byte{{0x10, 0x11}},
+		Proof:     AcceptProof{EchoVotes: []Vote{echoVote}},
+	}
+
+	// the signature of the echo vote is part of the accept proof
+	acceptVote.Proof.EchoVotes[0].Signature = []byte{0x99, 0x98}
+	expectDigest(t, "vote", acceptVote.Hash(), "95e11e3a2304d9bb3fc6d1b980aa1b9aee5aacd8f6478763b051a7b58a985193")
+
+	chunk := BlockChunk{
+		Issuer:         []byte{1},
+		Round:          7,
+		ChunkCount:     4,
+		DataChunkCount: 3,
+		ChunkIndex:     2,
+		Authenticator: ChunkAuthenticator{
+			MerkleRoot: []byte{0x20},
+			Path:       [][]byte{{0x21}, {0x22}},
+			Index:      []int64{1, 0},
+		},
+		Payload: []byte("payload"),
+	}
+
+	expectDigest(t, "chunk", chunk.Hash(), "a4d1985f83e66c4e1642650f5ffcf46240f2567f669bcdc83ea24670e3b1279e")
+
+	announcement := BlockAnnouncement{
+		Issuer:         []byte{1},
+		Round:          7,
+		MerkleRoot:     []byte{0x20},
+		ChunkCount:     4,
+		DataChunkCount: 3,
+		Signature:      []byte{0xff},
+	}
+
+	// signatures are not part of the digest
+	expectDigest(t, "announcement", announcement.Hash(), "ecfceea250e57d2751987a24acdacd024e322ff778828521c6b49d252b5683d1")
+}
+
+func expectDigest(t *testing.T, name string, digest []byte, expected string) {
+	t.Helper()
+	if hex.EncodeToString(digest) != expected {
+		t.Errorf("unexpected %s digest, expected %s, computed %x", name, expected, digest)
+	}
+}
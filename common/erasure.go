@@ -0,0 +1,291 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// galois field GF(2^8) generated by the polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d)
+const galoisPolynomial = 0x11d
+
+var (
+	galoisExpTable [510]byte
+	galoisLogTable [256]byte
+	galoisMulTable [256][256]byte
+)
+
+func init() {
+
+	x := 1
+	for i := 0; i < 255; i++ {
+		galoisExpTable[i] = byte(x)
+		galoisExpTable[i+255] = byte(x)
+		galoisLogTable[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= galoisPolynomial
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			galoisMulTable[a][b] = galoisExpTable[int(galoisLogTable[a])+int(galoisLogTable[b])]
+		}
+	}
+}
+
+func galoisMultiply(a byte, b byte) byte {
+	return galoisMulTable[a][b]
+}
+
+func galoisInverse(a byte) byte {
+	if a == 0 {
+		panic(fmt.Errorf("zero has no inverse in GF(2^8)"))
+	}
+	return galoisExpTable[255-int(galoisLogTable[a])]
+}
+
+func galoisPower(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return galoisExpTable[(int(galoisLogTable[a])*n)%255]
+}
+
+// erasureCoder implements a systematic Reed-Solomon code over GF(2^8).
+// The first dataShards shards of an encoding are the data itself, the remaining ones are parity.
+// Any dataShards of the totalShards shards are enough to reconstruct the data.
+type erasureCoder struct {
+	dataShards  int
+	totalShards int
+
+	// encoding matrix, totalShards rows and dataShards columns. The top square is the identity matrix.
+	matrix [][]byte
+}
+
+func newErasureCoder(dataShards int, totalShards int) (*erasureCoder, error) {
+
+	if dataShards <= 0 || totalShards < dataShards {
+		return nil, fmt.Errorf("invalid erasure coding parameters, data shards %d, total shards %d", dataShards, totalShards)
+	}
+
+	if totalShards > 256 {
+		return nil, fmt.Errorf("total shard count can not exceed 256, provided %d", totalShards)
+	}
+
+	// Vandermonde matrix, any dataShards rows of it are linearly independent
+	vandermonde := make([][]byte, totalShards)
+	for r := range vandermonde {
+		vandermonde[r] = make([]byte, dataShards)
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = galoisPower(byte(r), c)
+		}
+	}
+
+	// multiplying by the inverse of the top square makes the code systematic
+	// and keeps the independence property of rows
+	topInverse, err := invertMatrix(vandermonde[:dataShards])
+	if err != nil {
+		return nil, err
+	}
+
+	coder := &erasureCoder{
+		dataShards:  dataShards,
+		totalShards: totalShards,
+		matrix:      multiplyMatrix(vandermonde, topInverse),
+	}
+
+	return coder, nil
+}
+
+// Encode splits data into dataShards equal sized shards, and appends parity shards.
+// The length of the data is prepended to the data to be able to remove padding on reconstruction.
+func (e *erasureCoder) Encode(data []byte) [][]byte {
+
+	prefixedLength := len(data) + 8
+	shardSize := (prefixedLength + e.dataShards - 1) / e.dataShards
+
+	buffer := make([]byte, shardSize*e.totalShards)
+	binary.BigEndian.PutUint64(buffer, uint64(len(data)))
+	copy(buffer[8:], data)
+
+	shards := make([][]byte, e.totalShards)
+	for i := range shards {
+		shards[i] = buffer[i*shardSize : (i+1)*shardSize : (i+1)*shardSize]
+	}
+
+	for p := e.dataShards; p < e.totalShards; p++ {
+		for c := 0; c < e.dataShards; c++ {
+			multiplyAndAdd(e.matrix[p][c], shards[c], shards[p])
+		}
+	}
+
+	return shards
+}
+
+// Reconstruct recovers the data from shards. Missing shards must be nil.
+// At least dataShards of the shards must be present, and all present shards must have the same size.
+func (e *erasureCoder) Reconstruct(shards [][]byte) ([]byte, error) {
+
+	if len(shards) != e.totalShards {
+		return nil, fmt.Errorf("expected %d shards, provided %d shards", e.totalShards, len(shards))
+	}
+
+	shardSize := -1
+	var presentIndexes []int
+	for i := range shards {
+		if shards[i] == nil {
+			continue
+		}
+
+		if shardSize == -1 {
+			shardSize = len(shards[i])
+		} else if len(shards[i]) != shardSize {
+			return nil, fmt.Errorf("shard %d has size %d, expected size %d", i, len(shards[i]), shardSize)
+		}
+
+		if len(presentIndexes) < e.dataShards {
+			presentIndexes = append(presentIndexes, i)
+		}
+	}
+
+	if len(presentIndexes) < e.dataShards {
+		return nil, fmt.Errorf("not enough shards to reconstruct, required %d, available %d", e.dataShards, len(presentIndexes))
+	}
+
+	if shardSize < 1 {
+		return nil, fmt.Errorf("shard size is 0")
+	}
+
+	data := make([]byte, shardSize*e.dataShards)
+
+	// fast path: all data shards are available
+	if presentIndexes[e.dataShards-1] == e.dataShards-1 {
+		for i := 0; i < e.dataShards; i++ {
+			copy(data[i*shardSize:], shards[i])
+		}
+		return removeLengthPrefix(data)
+	}
+
+	subMatrix := make([][]byte, e.dataShards)
+	for i, shardIndex := range presentIndexes {
+		subMatrix[i] = e.matrix[shardIndex]
+	}
+
+	decodeMatrix, err := invertMatrix(subMatrix)
+	if err != nil {
+		return nil, err
+	}
+
+	for d := 0; d < e.dataShards; d++ {
+		output := data[d*shardSize : (d+1)*shardSize]
+		if shards[d] != nil {
+			copy(output, shards[d])
+			continue
+		}
+
+		for i, shardIndex := range presentIndexes {
+			multiplyAndAdd(decodeMatrix[d][i], shards[shardIndex], output)
+		}
+	}
+
+	return removeLengthPrefix(data)
+}
+
+func removeLengthPrefix(data []byte) ([]byte, error) {
+
+	if len(data) < 8 {
+		return nil, fmt.Errorf("reconstructed data is too short")
+	}
+
+	length := binary.BigEndian.Uint64(data)
+	if length > uint64(len(data)-8) {
+		return nil, fmt.Errorf("reconstructed data length %d exceeds available %d bytes", length, len(data)-8)
+	}
+
+	return data[8 : 8+length], nil
+}
+
+// multiplyAndAdd computes output += coefficient * input
+func multiplyAndAdd(coefficient byte, input []byte, output []byte) {
+
+	if coefficient == 0 {
+		return
+	}
+
+	table := &galoisMulTable[coefficient]
+	for i := range input {
+		output[i] ^= table[input[i]]
+	}
+}
+
+func multiplyMatrix(left [][]byte, right [][]byte) [][]byte {
+
+	result := make([][]byte, len(left))
+	for r := range left {
+		result[r] = make([]byte, len(right[0]))
+		for c := range result[r] {
+			var value byte
+			for i := range right {
+				value ^= galoisMultiply(left[r][i], right[i][c])
+			}
+			result[r][c] = value
+		}
+	}
+
+	return result
+}
+
+// invertMatrix inverts a square matrix using Gauss-Jordan elimination
+func invertMatrix(matrix [][]byte) ([][]byte, error) {
+
+	size := len(matrix)
+
+	// augmented matrix [matrix | identity]
+	work := make([][]byte, size)
+	for r := range work {
+		work[r] = make([]byte, 2*size)
+		copy(work[r], matrix[r])
+		work[r][size+r] = 1
+	}
+
+	for c := 0; c < size; c++ {
+
+		pivot := -1
+		for r := c; r < size; r++ {
+			if work[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+
+		if pivot == -1 {
+			return nil, fmt.Errorf("matrix is singular")
+		}
+
+		work[c], work[pivot] = work[pivot], work[c]
+
+		scale := galoisInverse(work[c][c])
+		for i := range work[c] {
+			work[c][i] = galoisMultiply(work[c][i], scale)
+		}
+
+		for r := 0; r < size; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			multiplyAndAdd(work[r][c], work[c], work[r])
+		}
+	}
+
+	inverse := make([][]byte, size)
+	for r := range inverse {
+		inverse[r] = work[r][size:]
+	}
+
+	return inverse, nil
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestErasureCoder(t *testing.T) {
+
+	dataShards := 10
+	totalShards := 16
+
+	coder, err := newErasureCoder(dataShards, totalShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := getRandomByteSlice(100003)
+	shards := coder.Encode(data)
+
+	if len(shards) != totalShards {
+		t.Fatalf("expected %d shards, received %d shards", totalShards, len(shards))
+	}
+
+	// the code is systematic, the first shards contain the length prefixed data
+	reconstructed, err := coder.Reconstruct(shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(reconstructed, data) == false {
+		t.Errorf("reconstructed data is not equal to the original data")
+	}
+
+	// removes random shards, keeps only the required number of shards
+	for trial := 0; trial < 10; trial++ {
+
+		partialShards := make([][]byte, totalShards)
+		for _, index := range rand.Perm(totalShards)[:dataShards] {
+			partialShards[index] = shards[index]
+		}
+
+		reconstructed, err = coder.Reconstruct(partialShards)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Equal(reconstructed, data) == false {
+			t.Errorf("reconstructed data is not equal to the original data, trial %d", trial)
+		}
+	}
+
+	partialShards := make([][]byte, totalShards)
+	for _, index := range rand.Perm(totalShards)[:dataShards-1] {
+		partialShards[index] = shards[index]
+	}
+
+	_, err = coder.Reconstruct(partialShards)
+	if err == nil {
+		t.Errorf("expected an error because there are not enough shards")
+	}
+}
@@ -0,0 +1,427 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// BlockProof proves that a block header is part of a decided round.
+// The accept certificate of the round proves that the Merkle roots of the round are final, the chunks prove the header against the Merkle root of the block.
+// A quorum of echo votes is not enough, the round may still be decided empty by a quorum of timeout votes.
+type BlockProof struct {
+	Round             int
+	MerkleRoots       [][]byte
+	AcceptCertificate AcceptCertificate
+
+	// index of the block in the decided round
+	BlockIndex int
+
+	// data chunks of the block that contain the header and the proven bytes
+	Chunks []BlockChunk
+}
+
+// TransactionProof proves that a transaction is part of a decided round
+type TransactionProof struct {
+	BlockProof
+
+	Transaction Transaction
+
+	// Merkle path of the transaction to TxRoot of the block
+	Path  [][]byte
+	Index []int64
+
+	// position of the transaction in the block and the number of transactions, the path must lead to the position
+	TxIndex int
+	TxCount int
+}
+
+// PayloadRangeProof proves that a range of the payload of a block is part of a decided round
+type PayloadRangeProof struct {
+	BlockProof
+
+	Offset int
+	Length int
+}
+
+// NewTransactionProof creates the proof of the transaction with the given hash.
+// chunks are the chunks of the block, they are created with the chunk counts of the decided round.
+func NewTransactionProof(decidedRound DecidedRound, blockIndex int, chunks []BlockChunk, txHash []byte) (TransactionProof, error) {
+
+	if blockIndex < 0 || blockIndex >= len(decidedRound.Blocks) {
+		return TransactionProof{}, fmt.Errorf("block index %d is out of range", blockIndex)
+	}
+
+	block := decidedRound.Blocks[blockIndex]
+	transactions, err := block.Transactions()
+	if err != nil {
+		return TransactionProof{}, err
+	}
+
+	var content []merkletree.Content
+	txIndex := -1
+	for i := range transactions {
+		content = append(content, transactions[i])
+		if txIndex == -1 && bytes.Equal(transactions[i].Hash(), txHash) {
+			txIndex = i
+		}
+	}
+
+	if txIndex == -1 {
+		return TransactionProof{}, fmt.Errorf("transaction is not in block %d of round %d", blockIndex, decidedRound.Round)
+	}
+
+	tree, err := merkletree.NewTree(content)
+	if err != nil {
+		return TransactionProof{}, err
+	}
+
+	path, index, err := tree.GetMerklePath(transactions[txIndex])
+	if err != nil {
+		return TransactionProof{}, err
+	}
+
+	blockProof, err := newBlockProof(decidedRound, blockIndex, chunks, 0, 0)
+	if err != nil {
+		return TransactionProof{}, err
+	}
+
+	return TransactionProof{BlockProof: blockProof, Transaction: transactions[txIndex], Path: path, Index: index, TxIndex: txIndex, TxCount: len(transactions)}, nil
+}
+
+// NewPayloadRangeProof creates the proof of length bytes of the payload of a block starting from offset.
+// chunks are the chunks of the block, they are created with the chunk counts of the decided round.
+func NewPayloadRangeProof(decidedRound DecidedRound, blockIndex int, chunks []BlockChunk, offset int, length int) (PayloadRangeProof, error) {
+
+	if blockIndex < 0 || blockIndex >= len(decidedRound.Blocks) {
+		return PayloadRangeProof{}, fmt.Errorf("block index %d is out of range", blockIndex)
+	}
+
+	payloadSize := len(decidedRound.Blocks[blockIndex].Payload)
+	if offset < 0 || length < 1 || offset+length > payloadSize {
+		return PayloadRangeProof{}, fmt.Errorf("range [%d, %d) is out of the payload of size %d", offset, offset+length, payloadSize)
+	}
+
+	blockProof, err := newBlockProof(decidedRound, blockIndex, chunks, offset, length)
+	if err != nil {
+		return PayloadRangeProof{}, err
+	}
+
+	return PayloadRangeProof{BlockProof: blockProof, Offset: offset, Length: length}, nil
+}
+
+// VerifyTransactionProof verifies the proof against the validators of the round, minVoteCount is the quorum size of the validators.
+// It does not require anything else, so it can be used by light clients.
+func VerifyTransactionProof(proof TransactionProof, validators *ValidatorSet, minVoteCount int) error {
+
+	header, err := proof.verify(validators, minVoteCount)
+	if err != nil {
+		return err
+	}
+
+	// the path must have the depth of the transaction tree, so that it can not stop at an inner node
+	if err := verifyLeafPosition(proof.Index, proof.TxIndex, proof.TxCount); err != nil {
+		return fmt.Errorf("transaction %d: %s", proof.TxIndex, err)
+	}
+
+	ok, err := VerifyContentWithPath(header.TxRoot, proof.Transaction, proof.Path, proof.Index)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("transaction is not included in the transaction root of the block")
+	}
+
+	return nil
+}
+
+// VerifyPayloadRangeProof verifies the proof against the validators of the round, and returns the proven bytes.
+// minVoteCount is the quorum size of the validators. It does not require anything else, so it can be used by light clients.
+func VerifyPayloadRangeProof(proof PayloadRangeProof, validators *ValidatorSet, minVoteCount int) ([]byte, error) {
+
+	if _, err := proof.verify(validators, minVoteCount); err != nil {
+		return nil, err
+	}
+
+	if proof.Offset < 0 || proof.Length < 1 {
+		return nil, fmt.Errorf("range [%d, %d) is not valid", proof.Offset, proof.Offset+proof.Length)
+	}
+
+	layout, err := newChunkLayout(proof.Chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadStart, err := layout.payloadStart()
+	if err != nil {
+		return nil, err
+	}
+
+	return layout.read(payloadStart+proof.Offset, proof.Length)
+}
+
+// VerifyEchoVotes checks that the echo votes are for the Merkle roots of the round, they have distinct issuers and valid signatures
+func VerifyEchoVotes(echoVotes []Vote, round int, merkleRoots [][]byte) error {
+
+	issuers := make(map[string]struct{})
+	for i := range echoVotes {
+		vote := echoVotes[i]
+		if vote.Tag != EchoTag || vote.Round != round || !equalHashLists(vote.BlockHash, merkleRoots) {
+			return fmt.Errorf("echo vote %d is not for the decided merkle roots", i)
+		}
+
+		if _, ok := issuers[string(vote.Issuer)]; ok {
+			return fmt.Errorf("echo vote %d has a duplicate issuer", i)
+		}
+		issuers[string(vote.Issuer)] = struct{}{}
+
+		if !vote.VerifySignature() {
+			return fmt.Errorf("echo vote %d has an invalid signature", i)
+		}
+	}
+
+	return nil
+}
+
+// verify checks the accept certificate and the chunks, and returns the proven block header.
+// The accept votes must be issued by the validators.
+func (p *BlockProof) verify(validators *ValidatorSet, minVoteCount int) (Block, error) {
+
+	if p.BlockIndex < 0 || p.BlockIndex >= len(p.MerkleRoots) {
+		return Block{}, fmt.Errorf("block index %d is out of range", p.BlockIndex)
+	}
+
+	if err := validators.CheckVotes(p.AcceptCertificate.AcceptVotes); err != nil {
+		return Block{}, err
+	}
+
+	if err := p.AcceptCertificate.Verify(p.Round, p.MerkleRoots, minVoteCount); err != nil {
+		return Block{}, err
+	}
+
+	merkleRoot := p.MerkleRoots[p.BlockIndex]
+	for i := range p.Chunks {
+		if err := VerifyChunk(merkleRoot, p.Chunks[i]); err != nil {
+			return Block{}, err
+		}
+	}
+
+	layout, err := newChunkLayout(p.Chunks)
+	if err != nil {
+		return Block{}, err
+	}
+
+	header, err := layout.header()
+	if err != nil {
+		return Block{}, err
+	}
+
+	if header.Round != p.Round {
+		return Block{}, fmt.Errorf("block belongs to round %d", header.Round)
+	}
+
+	return header, nil
+}
+
+func newBlockProof(decidedRound DecidedRound, blockIndex int, chunks []BlockChunk, offset int, length int) (BlockProof, error) {
+
+	if len(chunks) == 0 || !bytes.Equal(chunks[0].Authenticator.MerkleRoot, decidedRound.MerkleRoots[blockIndex]) {
+		return BlockProof{}, fmt.Errorf("chunks do not belong to block %d of round %d", blockIndex, decidedRound.Round)
+	}
+
+	if len(decidedRound.AcceptCertificate.AcceptVotes) == 0 {
+		return BlockProof{}, fmt.Errorf("round %d has no accept certificate", decidedRound.Round)
+	}
+
+	var dataChunks []BlockChunk
+	for i := range chunks {
+		if chunks[i].ChunkIndex < chunks[i].DataChunkCount {
+			dataChunks = append(dataChunks, chunks[i])
+		}
+	}
+
+	layout, err := newChunkLayout(dataChunks)
+	if err != nil {
+		return BlockProof{}, err
+	}
+
+	payloadStart, err := layout.payloadStart()
+	if err != nil {
+		return BlockProof{}, err
+	}
+
+	// chunks of the header, and chunks of the range
+	needed := make(map[int]bool)
+	for i := 0; i <= (payloadStart-1)/layout.chunkSize; i++ {
+		needed[i] = true
+	}
+
+	if length > 0 {
+		for i := (payloadStart + offset) / layout.chunkSize; i <= (payloadStart+offset+length-1)/layout.chunkSize; i++ {
+			needed[i] = true
+		}
+	}
+
+	proof := BlockProof{
+		Round:             decidedRound.Round,
+		MerkleRoots:       decidedRound.MerkleRoots,
+		AcceptCertificate: decidedRound.AcceptCertificate,
+		BlockIndex:        blockIndex,
+	}
+
+	for i := range dataChunks {
+		if needed[dataChunks[i].ChunkIndex] {
+			proof.Chunks = append(proof.Chunks, dataChunks[i])
+		}
+	}
+
+	return proof, nil
+}
+
+// chunkLayout maps the positions of the chunked block data to data chunks.
+// If the block is erasure coded, the block data starts after the 8 bytes length prefix.
+type chunkLayout struct {
+	chunks    map[int][]byte
+	chunkSize int
+
+	// start of the block data
+	dataStart int
+}
+
+func newChunkLayout(chunks []BlockChunk) (chunkLayout, error) {
+
+	layout := chunkLayout{chunks: make(map[int][]byte)}
+	if len(chunks) == 0 {
+		return layout, fmt.Errorf("no chunks provided")
+	}
+
+	chunkCount := chunks[0].ChunkCount
+	dataChunkCount := chunks[0].DataChunkCount
+	for i := range chunks {
+		c := chunks[i]
+		if c.ChunkCount != chunkCount || c.DataChunkCount != dataChunkCount {
+			return layout, fmt.Errorf("chunk %d does not agree on chunk counts", c.ChunkIndex)
+		}
+
+		if c.ChunkIndex < 0 || c.ChunkIndex >= dataChunkCount {
+			return layout, fmt.Errorf("chunk %d is not a data chunk", c.ChunkIndex)
+		}
+
+		if _, ok := layout.chunks[c.ChunkIndex]; ok {
+			return layout, fmt.Errorf("chunk %d is duplicated", c.ChunkIndex)
+		}
+
+		layout.chunks[c.ChunkIndex] = c.Payload
+	}
+
+	// all the data chunks except the last one have the same size
+	firstChunk, ok := layout.chunks[0]
+	if !ok || len(firstChunk) == 0 {
+		return layout, fmt.Errorf("first chunk is missing")
+	}
+	layout.chunkSize = len(firstChunk)
+
+	if dataChunkCount < chunkCount {
+		layout.dataStart = 8
+
+		prefix, err := layout.read(0, 8)
+		if err != nil {
+			return layout, err
+		}
+
+		// the padding after the data can not be proven
+		dataSize := binary.BigEndian.Uint64(prefix)
+		if dataSize > uint64(layout.chunkSize*dataChunkCount) {
+			return layout, fmt.Errorf("block data size %d exceeds the chunks", dataSize)
+		}
+
+		for index, payload := range layout.chunks {
+			end := 8 + int(dataSize) - index*layout.chunkSize
+			if end < 0 {
+				end = 0
+			}
+
+			if end < len(payload) {
+				layout.chunks[index] = payload[:end]
+			}
+		}
+	}
+
+	return layout, nil
+}
+
+// read returns length bytes starting from the position, all the chunks of the range must be available
+func (l chunkLayout) read(position int, length int) ([]byte, error) {
+
+	var data []byte
+	for length > 0 {
+		index := position / l.chunkSize
+		payload, ok := l.chunks[index]
+		offset := position % l.chunkSize
+		if !ok || offset >= len(payload) {
+			return nil, fmt.Errorf("chunk %d that contains position %d is not available", index, position)
+		}
+
+		n := len(payload) - offset
+		if n > length {
+			n = length
+		}
+
+		data = append(data, payload[offset:offset+n]...)
+		position += n
+		length -= n
+	}
+
+	return data, nil
+}
+
+// header decodes the block header from the contiguous chunks starting from the first chunk
+func (l chunkLayout) header() (Block, error) {
+
+	var data []byte
+	for i := 0; ; i++ {
+		payload, ok := l.chunks[i]
+		if !ok {
+			break
+		}
+		data = append(data, payload...)
+	}
+
+	block := Block{}
+	d := newDecoder(data[l.dataStart:])
+	block.decode(d)
+	if d.err != nil {
+		return Block{}, fmt.Errorf("could not decode the block header: %s", d.err)
+	}
+
+	return block, nil
+}
+
+// payloadStart returns the position of the first byte of the payload
+func (l chunkLayout) payloadStart() (int, error) {
+
+	header, err := l.header()
+	if err != nil {
+		return 0, err
+	}
+
+	return l.dataStart + len(encodeToCanonicalBytes(&header)), nil
+}
+
+func equalHashLists(list1 [][]byte, list2 [][]byte) bool {
+
+	if len(list1) != len(list2) {
+		return false
+	}
+
+	for i := range list1 {
+		if !bytes.Equal(list1[i], list2[i]) {
+			return false
+		}
+	}
+
+	return true
+}
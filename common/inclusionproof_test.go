@@ -0,0 +1,149 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestInclusionProofs(t *testing.T) {
+
+	_, clientKey, _ := ed25519.GenerateKey(nil)
+	var transactions []Transaction
+	for i := 0; i < 20; i++ {
+		transactions = append(transactions, NewTransaction(clientKey, uint64(i), 0, getRandomByteSlice(100)))
+	}
+	block := NewBlock([]byte{1}, []byte("previous block hash"), 7, transactions)
+
+	testCases := []struct {
+		name           string
+		dataChunkCount int
+		chunkCount     int
+	}{
+		{name: "without erasure coding", dataChunkCount: 16, chunkCount: 16},
+		{name: "with erasure coding", dataChunkCount: 10, chunkCount: 16},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			chunks, merkleRoot := ChunkBlockWithErasureCoding(block, tc.dataChunkCount, tc.chunkCount)
+			if tc.dataChunkCount == tc.chunkCount {
+				chunks, merkleRoot = ChunkBlock(block, tc.chunkCount)
+			}
+
+			decidedRound := DecidedRound{Round: 7, Blocks: []Block{block}, MerkleRoots: [][]byte{merkleRoot}}
+			var publicKeys [][]byte
+			for i := 0; i < 4; i++ {
+				publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+				publicKeys = append(publicKeys, publicKey)
+				if i == 3 {
+					continue
+				}
+
+				vote := Vote{Issuer: publicKey, Tag: AcceptTag, Round: 7, BlockHash: [][]byte{merkleRoot}}
+				vote.Signature = ed25519.Sign(privateKey, vote.Hash())
+				decidedRound.AcceptCertificate.AcceptVotes = append(decidedRound.AcceptCertificate.AcceptVotes, vote)
+			}
+
+			validators, err := NewValidatorSet(publicKeys)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the quorum of the 4 validators with majority quorums
+			minVoteCount := 3
+
+			// a quorum of echo votes does not prove that the round is final
+			echoRound := decidedRound
+			echoRound.AcceptCertificate = AcceptCertificate{}
+			if _, err := NewTransactionProof(echoRound, 0, chunks, transactions[13].Hash()); err == nil {
+				t.Errorf("expected an error because the round has no accept certificate")
+			}
+
+			txProof, err := NewTransactionProof(decidedRound, 0, chunks, transactions[13].Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(txProof.Chunks) >= tc.dataChunkCount {
+				t.Errorf("transaction proof is not compact, it has %d chunks", len(txProof.Chunks))
+			}
+
+			if err := VerifyTransactionProof(txProof, validators, minVoteCount); err != nil {
+				t.Fatal(err)
+			}
+
+			echoProof := txProof
+			echoProof.AcceptCertificate = AcceptCertificate{}
+			for _, vote := range txProof.AcceptCertificate.AcceptVotes {
+				vote.Tag = EchoTag
+				echoProof.AcceptCertificate.AcceptVotes = append(echoProof.AcceptCertificate.AcceptVotes, vote)
+			}
+			if err := VerifyTransactionProof(echoProof, validators, minVoteCount); err == nil {
+				t.Errorf("expected an error because the proof has echo votes instead of accept votes")
+			}
+
+			forgedProof := txProof
+			forgedProof.Transaction = transactions[12]
+			if err := VerifyTransactionProof(forgedProof, validators, minVoteCount); err == nil {
+				t.Errorf("expected an error because the transaction does not match the path")
+			}
+
+			truncatedProof := txProof
+			truncatedProof.Path = txProof.Path[:len(txProof.Path)-1]
+			truncatedProof.Index = txProof.Index[:len(txProof.Index)-1]
+			if err := VerifyTransactionProof(truncatedProof, validators, minVoteCount); err == nil {
+				t.Errorf("expected an error because the path does not reach the leaves")
+			}
+
+			movedProof := txProof
+			movedProof.TxIndex = 12
+			if err := VerifyTransactionProof(movedProof, validators, minVoteCount); err == nil {
+				t.Errorf("expected an error because the path does not lead to the transaction index")
+			}
+
+			if err := VerifyTransactionProof(txProof, validators, minVoteCount+1); err == nil {
+				t.Errorf("expected an error because there is no quorum of validators")
+			}
+
+			otherValidators, err := NewValidatorSet(publicKeys[1:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyTransactionProof(txProof, otherValidators, 2); err == nil {
+				t.Errorf("expected an error because an accept vote is not issued by a validator")
+			}
+
+			offset := len(block.Payload) - 300
+			rangeProof, err := NewPayloadRangeProof(decidedRound, 0, chunks, offset, 250)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			provenBytes, err := VerifyPayloadRangeProof(rangeProof, validators, minVoteCount)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(provenBytes, block.Payload[offset:offset+250]) {
+				t.Errorf("proven bytes do not match the payload")
+			}
+
+			tamperedProof := rangeProof
+			tamperedProof.Chunks = append([]BlockChunk(nil), rangeProof.Chunks...)
+			last := len(tamperedProof.Chunks) - 1
+			tamperedProof.Chunks[last].Payload = append([]byte(nil), tamperedProof.Chunks[last].Payload...)
+			tamperedProof.Chunks[last].Payload[0]++
+			if _, err := VerifyPayloadRangeProof(tamperedProof, validators, minVoteCount); err == nil {
+				t.Errorf("expected an error because a chunk is tampered")
+			}
+
+			outOfRange := rangeProof
+			outOfRange.Length = len(block.Payload)
+			if _, err := VerifyPayloadRangeProof(outOfRange, validators, minVoteCount); err == nil {
+				t.Errorf("expected an error because the range is not covered by the chunks")
+			}
+		})
+	}
+}
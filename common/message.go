@@ -0,0 +1,119 @@
+package common
+
+import (
+	"encoding/gob"
+	"fmt"
+)
+
+// MessageKind identifies a kind of message routed by the demultiplexer, every kind has its own per-round queues
+type MessageKind string
+
+const (
+	BlockChunkKind        MessageKind = "chunk"
+	BlockAnnouncementKind MessageKind = "announcement"
+	ProposeVoteKind       MessageKind = "propose"
+	EchoVoteKind          MessageKind = "echo"
+	AcceptVoteKind        MessageKind = "accept"
+	TimeoutVoteKind       MessageKind = "timeout"
+)
+
+// Message is a protocol message routed by the demultiplexer.
+// A subsystem adds a new message by implementing this interface, registering its type with RegisterMessageType,
+// and registering its kind with Demux.RegisterKind.
+type Message interface {
+	// Kind selects the queues of the message
+	Kind() MessageKind
+
+	// MessageRound returns the round the message belongs to
+	MessageRound() int
+
+	// ID identifies the message for deduplication
+	ID() MessageID
+}
+
+// IssuedMessage is implemented by the messages that are signed by an issuer,
+// so that the rejected messages are attributed to their issuers
+type IssuedMessage interface {
+	MessageIssuer() []byte
+}
+
+// MessageIssuer returns the issuer of a message, or nil if the message does not have an issuer
+func MessageIssuer(message Message) []byte {
+
+	if issued, ok := message.(IssuedMessage); ok {
+		return issued.MessageIssuer()
+	}
+
+	return nil
+}
+
+// Envelope carries a message of any registered type over the network
+type Envelope struct {
+	// Shard is the namespace of the message, the message is delivered to the demux of the shard chain
+	Shard int
+
+	Message Message
+}
+
+// RegisterMessageType registers the concrete type of a message, so that it can be carried by an Envelope
+func RegisterMessageType(message Message) {
+	gob.Register(message)
+}
+
+func init() {
+	RegisterMessageType(BlockChunk{})
+	RegisterMessageType(BlockAnnouncement{})
+	RegisterMessageType(Vote{})
+}
+
+// VoteKind returns the message kind of the votes with the tag
+func VoteKind(tag byte) MessageKind {
+	switch tag {
+	case ProposeTag:
+		return ProposeVoteKind
+	case EchoTag:
+		return EchoVoteKind
+	case AcceptTag:
+		return AcceptVoteKind
+	case TimeoutTag:
+		return TimeoutVoteKind
+	default:
+		return MessageKind(fmt.Sprintf("vote-%d", tag))
+	}
+}
+
+func (c BlockChunk) Kind() MessageKind {
+	return BlockChunkKind
+}
+
+func (c BlockChunk) MessageRound() int {
+	return c.Round
+}
+
+func (c BlockChunk) MessageIssuer() []byte {
+	return c.Issuer
+}
+
+func (a BlockAnnouncement) Kind() MessageKind {
+	return BlockAnnouncementKind
+}
+
+func (a BlockAnnouncement) MessageRound() int {
+	return a.Round
+}
+
+func (a BlockAnnouncement) MessageIssuer() []byte {
+	return a.Issuer
+}
+
+func (v Vote) Kind() MessageKind {
+	return VoteKind(v.Tag)
+}
+
+func (v Vote) MessageRound() int {
+	return v.Round
+}
+
+func (v Vote) MessageIssuer() []byte {
+	return v.Issuer
+}
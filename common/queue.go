@@ -0,0 +1,119 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQueueFull is returned to the sender if a queue with the Reject policy is full
+var ErrQueueFull = errors.New("demux queue is full")
+
+// OverflowPolicy defines what happens to a message if its queue is full
+type OverflowPolicy int
+
+const (
+	// DropNewest discards the received message
+	DropNewest OverflowPolicy = iota
+
+	// DropOldest discards the oldest message in the queue to make room for the received message
+	DropOldest
+
+	// Reject discards the received message, and returns ErrQueueFull to the sender
+	Reject
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Reject:
+		return "reject"
+	default:
+		panic(fmt.Errorf("undefined enum value %d", p))
+	}
+}
+
+// ParseOverflowPolicy parses the policy names used in the node config
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch name {
+	case "", "drop-newest":
+		return DropNewest, nil
+	case "drop-oldest":
+		return DropOldest, nil
+	case "reject":
+		return Reject, nil
+	default:
+		return DropNewest, fmt.Errorf("unknown overflow policy %q", name)
+	}
+}
+
+// QueueConfig defines the capacity and the overflow policy of the per-round queues of a message kind
+type QueueConfig struct {
+	Capacity int
+	Policy   OverflowPolicy
+}
+
+// QueueStats keeps the counters of the queues of a message kind
+type QueueStats struct {
+	// number of messages waiting in the queues of all rounds
+	Depth int
+
+	// the highest number of messages observed in the queue of a single round
+	MaxDepth int
+
+	Enqueued      uint64
+	DroppedNewest uint64
+	DroppedOldest uint64
+	Rejected      uint64
+
+	// number of messages rejected because their rounds are beyond the acceptance window
+	OutOfWindow uint64
+}
+
+// enqueue puts a message to a queue without blocking, the overflow policy is applied if the queue is full.
+// It returns false if the message is not enqueued.
+func (s *QueueStats) enqueue(policy OverflowPolicy, queue chan Message, message Message) (bool, error) {
+
+	enqueued := trySend(queue, message)
+
+	if !enqueued {
+		switch policy {
+		case DropNewest:
+			s.DroppedNewest++
+			return false, nil
+		case Reject:
+			s.Rejected++
+			return false, ErrQueueFull
+		case DropOldest:
+			// the consumer may receive concurrently, so the oldest message may already be gone
+			select {
+			case <-queue:
+				s.DroppedOldest++
+			default:
+			}
+
+			if enqueued = trySend(queue, message); !enqueued {
+				s.DroppedNewest++
+				return false, nil
+			}
+		}
+	}
+
+	s.Enqueued++
+	if depth := len(queue); depth > s.MaxDepth {
+		s.MaxDepth = depth
+	}
+
+	return true, nil
+}
+
+func trySend(queue chan Message, message Message) bool {
+	select {
+	case queue <- message:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrDuplicateVote is returned if a validator votes twice for the same merkle roots
+var ErrDuplicateVote = errors.New("validator already voted for the merkle roots")
+
+// ErrConflictingVote is returned if a validator votes for different merkle roots in the same phase
+var ErrConflictingVote = errors.New("validator already voted for different merkle roots")
+
+// QuorumTracker counts the votes of a phase of a round by issuer, each validator counts once.
+// The votes of a validator after its first vote are rejected, so that a validator can not count twice
+// by changing the signature or the proof of its vote, and can not vote for different merkle roots.
+// It is not safe for concurrent use.
+type QuorumTracker struct {
+	validators *ValidatorSet
+	threshold  int
+
+	// vote of each validator keyed by validator index
+	votes map[int]Vote
+
+	// votes keyed by the merkle roots they vote for, in the order they are added
+	votesByRoots map[string][]Vote
+}
+
+// NewQuorumTracker creates a tracker whose quorum is threshold votes of the validators
+func NewQuorumTracker(validators *ValidatorSet, threshold int) *QuorumTracker {
+
+	if threshold < 1 || threshold > validators.Size() {
+		panic(fmt.Errorf("illegal quorum threshold %d of %d validators", threshold, validators.Size()))
+	}
+
+	return &QuorumTracker{
+		validators:   validators,
+		threshold:    threshold,
+		votes:        make(map[int]Vote),
+		votesByRoots: make(map[string][]Vote),
+	}
+}
+
+// Add adds a vote. It returns ErrNotValidator, ErrDuplicateVote or ErrConflictingVote if the vote is not counted.
+func (q *QuorumTracker) Add(vote Vote) error {
+
+	index := q.validators.Index(vote.Issuer)
+	if index == -1 {
+		return ErrNotValidator
+	}
+
+	if previous, ok := q.votes[index]; ok {
+		if equalHashLists(previous.BlockHash, vote.BlockHash) {
+			return ErrDuplicateVote
+		}
+		return ErrConflictingVote
+	}
+
+	q.votes[index] = vote
+	key := merkleRootsKey(vote.BlockHash)
+	q.votesByRoots[key] = append(q.votesByRoots[key], vote)
+
+	return nil
+}
+
+// Count returns the number of validators that voted for the merkle roots
+func (q *QuorumTracker) Count(merkleRoots [][]byte) int {
+	return len(q.votesByRoots[merkleRootsKey(merkleRoots)])
+}
+
+// HasQuorum returns true if a quorum of validators voted for the merkle roots
+func (q *QuorumTracker) HasQuorum(merkleRoots [][]byte) bool {
+	return q.Count(merkleRoots) >= q.threshold
+}
+
+// Votes returns the votes for the merkle roots
+func (q *QuorumTracker) Votes(merkleRoots [][]byte) []Vote {
+
+	var votes []Vote
+	votes = append(votes, q.votesByRoots[merkleRootsKey(merkleRoots)]...)
+
+	return votes
+}
+
+// Missing returns the indexes of the validators that have not voted yet
+func (q *QuorumTracker) Missing() []int {
+
+	var missing []int
+	for i := 0; i < q.validators.Size(); i++ {
+		if _, ok := q.votes[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+
+	return missing
+}
+
+// merkleRootsKey encodes the merkle roots with their lengths, so that different lists of roots have different keys
+func merkleRootsKey(merkleRoots [][]byte) string {
+
+	var key []byte
+	for _, merkleRoot := range merkleRoots {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(merkleRoot)))
+		key = append(key, length[:]...)
+		key = append(key, merkleRoot...)
+	}
+
+	return string(key)
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestQuorumTracker(t *testing.T) {
+
+	var publicKeys [][]byte
+	var privateKeys []ed25519.PrivateKey
+	for i := 0; i < 4; i++ {
+		publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+		publicKeys = append(publicKeys, publicKey)
+		privateKeys = append(privateKeys, privateKey)
+	}
+
+	validators, err := NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merkleRoots := [][]byte{[]byte("merkle root")}
+	otherMerkleRoots := [][]byte{[]byte("another merkle root")}
+	echoVote := func(i int, merkleRoots [][]byte) Vote {
+		vote := Vote{Issuer: publicKeys[i], Tag: EchoTag, Round: 1, BlockHash: merkleRoots}
+		vote.Signature = ed25519.Sign(privateKeys[i], vote.SigningHash())
+		return vote
+	}
+
+	tracker := NewQuorumTracker(validators, 3)
+
+	for i := 0; i < 2; i++ {
+		if err := tracker.Add(echoVote(i, merkleRoots)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the same validator with a different signature does not count twice
+	duplicate := echoVote(0, merkleRoots)
+	duplicate.Signature = append([]byte{}, duplicate.Signature...)
+	duplicate.Signature[0] ^= 1
+	if err := tracker.Add(duplicate); err != ErrDuplicateVote {
+		t.Errorf("expected ErrDuplicateVote, got %v", err)
+	}
+
+	if err := tracker.Add(echoVote(1, otherMerkleRoots)); err != ErrConflictingVote {
+		t.Errorf("expected ErrConflictingVote, got %v", err)
+	}
+
+	stranger, _, _ := ed25519.GenerateKey(nil)
+	if err := tracker.Add(Vote{Issuer: stranger, Tag: EchoTag, Round: 1, BlockHash: merkleRoots}); err != ErrNotValidator {
+		t.Errorf("expected ErrNotValidator, got %v", err)
+	}
+
+	if tracker.HasQuorum(merkleRoots) {
+		t.Errorf("expected no quorum with the votes of 2 validators")
+	}
+
+	if err := tracker.Add(echoVote(3, otherMerkleRoots)); err != nil {
+		t.Fatal(err)
+	}
+
+	if missing := tracker.Missing(); len(missing) != 1 || missing[0] != 2 {
+		t.Errorf("expected validator 2 to be missing, got %v", missing)
+	}
+
+	if err := tracker.Add(echoVote(2, merkleRoots)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !tracker.HasQuorum(merkleRoots) || tracker.HasQuorum(otherMerkleRoots) {
+		t.Errorf("expected a quorum only for the first merkle roots")
+	}
+
+	if err := VerifyEchoVotes(tracker.Votes(merkleRoots), 1, merkleRoots); err != nil {
+		t.Fatal(err)
+	}
+}
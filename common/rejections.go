@@ -0,0 +1,82 @@
+package common
+
+import (
+	"encoding/base64"
+	"sync"
+)
+
+// RejectionLog counts the rejected messages by the address of the sending peer and by the issuer.
+// It is safe for concurrent use.
+type RejectionLog struct {
+	mutex sync.Mutex
+
+	// number of rejected messages keyed by peer address and reason
+	peers map[string]map[string]uint64
+
+	// number of rejected messages keyed by base64 encoded issuer and reason
+	issuers map[string]map[string]uint64
+}
+
+func NewRejectionLog() *RejectionLog {
+	return &RejectionLog{peers: make(map[string]map[string]uint64), issuers: make(map[string]map[string]uint64)}
+}
+
+// Count counts a rejection. The peer is empty if the message is not received from a peer,
+// and the issuer is nil if the message does not have an issuer.
+func (r *RejectionLog) Count(peer string, issuer []byte, err error) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if peer != "" {
+		countReason(r.peers, peer, err)
+	}
+
+	if issuer != nil {
+		countReason(r.issuers, EncodeIssuer(issuer), err)
+	}
+}
+
+// ByPeer returns the number of rejected messages keyed by peer address and reason
+func (r *RejectionLog) ByPeer() map[string]map[string]uint64 {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return copyCounts(r.peers)
+}
+
+// ByIssuer returns the number of rejected messages keyed by base64 encoded issuer and reason
+func (r *RejectionLog) ByIssuer() map[string]map[string]uint64 {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return copyCounts(r.issuers)
+}
+
+// EncodeIssuer encodes the public key of an issuer to be printed
+func EncodeIssuer(issuer []byte) string {
+	return base64.StdEncoding.EncodeToString(issuer)
+}
+
+func countReason(counts map[string]map[string]uint64, key string, err error) {
+
+	if counts[key] == nil {
+		counts[key] = make(map[string]uint64)
+	}
+	counts[key][err.Error()]++
+}
+
+func copyCounts(counts map[string]map[string]uint64) map[string]map[string]uint64 {
+
+	copied := make(map[string]map[string]uint64)
+	for key, reasons := range counts {
+		copied[key] = make(map[string]uint64)
+		for reason, count := range reasons {
+			copied[key][reason] = count
+		}
+	}
+
+	return copied
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -46,7 +47,10 @@ type StatList struct {
 	Events     []Event
 }
 
+// StatLogger is safe for concurrent use, the events are read by the HTTP API while the consensus is running
 type StatLogger struct {
+	mutex sync.Mutex
+
 	round      int
 	roundStart time.Time
 	nodeID     int
@@ -59,36 +63,75 @@ func NewStatLogger(nodeID int) *StatLogger {
 }
 
 func (s *StatLogger) NewRound(round int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	s.round = round
 	s.roundStart = time.Now()
 }
 
 func (s *StatLogger) LogPropose(elapsedTime int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	log.Printf("stats\t%d\t%d\t%s\t%d\t", s.nodeID, s.round, "PROPOSE", elapsedTime)
 	s.events = append(s.events, Event{Round: s.round, Type: Proposed, ElapsedTime: int(elapsedTime)})
 }
 
 func (s *StatLogger) LogBlockReceive(elapsedTime int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	log.Printf("stats\t%d\t%d\t%s\t%d\t", s.nodeID, s.round, "BLOCK_RECEIVED", elapsedTime)
 	s.events = append(s.events, Event{Round: s.round, Type: BlockReceived, ElapsedTime: int(elapsedTime)})
 }
 
 func (s *StatLogger) LogEcho(elapsedTime int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	log.Printf("stats\t%d\t%d\t%s\t%d\t", s.nodeID, s.round, "ECHO", elapsedTime)
 	s.events = append(s.events, Event{Round: s.round, Type: Echo, ElapsedTime: int(elapsedTime)})
 }
 
 func (s *StatLogger) LogAccept(elapsedTime int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	log.Printf("stats\t%d\t%d\t%s\t%d\t", s.nodeID, s.round, "ACCEPT", elapsedTime)
 	s.events = append(s.events, Event{Round: s.round, Type: Accept, ElapsedTime: int(elapsedTime)})
 }
 
 func (s *StatLogger) LogEndOfRound() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	elapsedTime := time.Since(s.roundStart).Milliseconds()
 	log.Printf("stats\t%d\t%d\t%s\t%d\t", s.nodeID, s.round, "END_OF_ROUND", elapsedTime)
 	s.events = append(s.events, Event{Round: s.round, Type: EndOfRound, ElapsedTime: int(elapsedTime)})
 }
 
 func (s *StatLogger) GetEvents() []Event {
-	return s.events
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := make([]Event, len(s.events))
+	copy(events, s.events)
+
+	return events
+}
+
+// GetRoundEvents returns the events of a round
+func (s *StatLogger) GetRoundEvents(round int) []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var events []Event
+	for _, event := range s.events {
+		if event.Round == round {
+			events = append(events, event)
+		}
+	}
+
+	return events
 }
@@ -0,0 +1,169 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// Transaction defines a transaction issued by a client
+type Transaction struct {
+	// Publick Key of the sender
+	Sender []byte
+
+	// Sequence number of the transaction for the sender
+	Nonce uint64
+
+	// Fee offered by the sender, transactions with higher fees can be prioritized by leaders
+	Fee uint64
+
+	// Transaction payload
+	Payload []byte
+
+	// Signature on the hash of the Transaction
+	Signature []byte
+}
+
+// NewTransaction creates a transaction signed by the provided key
+func NewTransaction(privateKey ed25519.PrivateKey, nonce uint64, fee uint64, payload []byte) Transaction {
+
+	tx := Transaction{
+		Sender:  privateKey.Public().(ed25519.PublicKey),
+		Nonce:   nonce,
+		Fee:     fee,
+		Payload: payload,
+	}
+
+	tx.Signature = ed25519.Sign(privateKey, tx.Hash())
+
+	return tx
+}
+
+// Hash produces the digest of a Transaction.
+// It considers all fields of a Transaction except the signature.
+func (t Transaction) Hash() []byte {
+	return digest(&t)
+}
+
+// Verify checks the signature of the transaction
+func (t Transaction) Verify() bool {
+
+	if len(t.Sender) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(t.Sender, t.Hash(), t.Signature)
+}
+
+// Size returns the number of bytes the transaction occupies in the payload of a block
+func (t Transaction) Size() int {
+	// header, length prefixes, nonce and fee
+	return 2 + 4 + len(t.Sender) + 8 + 8 + 4 + len(t.Payload) + 4 + len(t.Signature)
+}
+
+// CalculateHash is defined in merkletree interface.
+// The leaf of a transaction is its digest.
+func (t Transaction) CalculateHash() ([]byte, error) {
+	return t.Hash(), nil
+}
+
+// Equals is defined in merkletree interface
+func (t Transaction) Equals(other merkletree.Content) (bool, error) {
+
+	otherHash, err := other.CalculateHash()
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(t.Hash(), otherHash), nil
+}
+
+// TransactionRoot returns the root of the Merkle tree constructed using transactions.
+// The root of an empty transaction list is SHA-256 of the empty string.
+func TransactionRoot(transactions []Transaction) []byte {
+
+	if len(transactions) == 0 {
+		emptyRoot := sha256.Sum256(nil)
+		return emptyRoot[:]
+	}
+
+	var content []merkletree.Content
+	for i := range transactions {
+		content = append(content, transactions[i])
+	}
+
+	tree, err := merkletree.NewTree(content)
+	if err != nil {
+		panic(err)
+	}
+
+	return tree.MerkleRoot()
+}
+
+// EncodeTransactions encodes transactions to be used as the payload of a block
+func EncodeTransactions(transactions []Transaction) []byte {
+	return encodeToCanonicalBytes(transactionList(transactions))
+}
+
+// DecodeTransactions decodes the payload of a block
+func DecodeTransactions(payload []byte) ([]Transaction, error) {
+
+	var transactions transactionList
+	d := newDecoder(payload)
+	transactions.decode(d)
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("could not decode transactions: %s", err)
+	}
+
+	return transactions, nil
+}
+
+// NewBlock creates a block whose payload is the list of transactions.
+// The header of the block commits to the transactions with TxRoot.
+func NewBlock(issuer []byte, previousBlockHash []byte, round int, transactions []Transaction) Block {
+
+	return Block{
+		Issuer:        issuer,
+		PrevBlockHash: previousBlockHash,
+		Round:         round,
+		TxRoot:        TransactionRoot(transactions),
+		Payload:       EncodeTransactions(transactions),
+	}
+}
+
+// Transactions decodes the transactions of the block
+func (b *Block) Transactions() ([]Transaction, error) {
+	return DecodeTransactions(b.Payload)
+}
+
+// ValidateBody decodes the transactions of the block, verifies their signatures, checks that a transaction is included once,
+// and checks that they match the transaction root in the block header. The transactions of the previous rounds are checked by the consensus.
+func (b *Block) ValidateBody() ([]Transaction, error) {
+
+	transactions, err := b.Transactions()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]bool)
+	for i := range transactions {
+		if !transactions[i].Verify() {
+			return nil, fmt.Errorf("transaction %d has an invalid signature", i)
+		}
+
+		hash := string(transactions[i].Hash())
+		if hashes[hash] {
+			return nil, fmt.Errorf("transaction %d is included more than once", i)
+		}
+		hashes[hash] = true
+	}
+
+	if !bytes.Equal(TransactionRoot(transactions), b.TxRoot) {
+		return nil, fmt.Errorf("transaction root does not match the transactions of the block")
+	}
+
+	return transactions, nil
+}
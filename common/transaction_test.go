@@ -0,0 +1,73 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestBlockTransactions(t *testing.T) {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var transactions []Transaction
+	for i := 0; i < 100; i++ {
+		transactions = append(transactions, NewTransaction(privateKey, uint64(i), 0, getRandomByteSlice(512)))
+	}
+
+	block := NewBlock(getRandomByteSlice(32), getRandomByteSlice(32), 1, transactions)
+
+	// the body survives the chunk pipeline
+	chunks, _ := ChunkBlockWithErasureCoding(block, 12, 16)
+	reconstructedBlock, err := ReconstructBlock(chunks[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedTransactions, err := reconstructedBlock.ValidateBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reconstructedBlock.Payload) != len(transactions)*transactions[0].Size()+6 {
+		t.Errorf("transaction sizes do not add up to the payload size %d", len(reconstructedBlock.Payload))
+	}
+
+	if len(decodedTransactions) != len(transactions) {
+		t.Fatalf("expected %d transactions, decoded %d transactions", len(transactions), len(decodedTransactions))
+	}
+
+	for i := range transactions {
+		if !bytes.Equal(transactions[i].Hash(), decodedTransactions[i].Hash()) || !bytes.Equal(transactions[i].Signature, decodedTransactions[i].Signature) {
+			t.Errorf("transaction %d is not decoded correctly", i)
+		}
+	}
+
+	// a modified transaction must be detected
+	tamperedTransactions := append([]Transaction(nil), transactions...)
+	tamperedTransactions[3].Nonce++
+	tamperedBlock := block
+	tamperedBlock.Payload = EncodeTransactions(tamperedTransactions)
+	if _, err := tamperedBlock.ValidateBody(); err == nil {
+		t.Errorf("expected an error because a transaction is modified")
+	}
+
+	// a leader can not include a transaction twice, even if the transaction root commits to both copies
+	duplicateBlock := NewBlock(getRandomByteSlice(32), getRandomByteSlice(32), 1, append(transactions[:2:2], transactions[0]))
+	if _, err := duplicateBlock.ValidateBody(); err == nil {
+		t.Errorf("expected an error because a transaction is included twice")
+	}
+
+	// the transaction root of an empty block is well defined
+	emptyBlock := NewBlock(getRandomByteSlice(32), getRandomByteSlice(32), 1, nil)
+	if _, err := emptyBlock.ValidateBody(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := DecodeTransactions(block.Payload[:len(block.Payload)-1]); err == nil {
+		t.Errorf("expected an error because the payload is truncated")
+	}
+}
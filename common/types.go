@@ -2,8 +2,9 @@ package common
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/sha256"
-	"fmt"
+	"encoding/binary"
 
 	"github.com/cbergoon/merkletree"
 )
@@ -17,6 +18,9 @@ const (
 
 	// AcceptTag show a vote belogs to accept phase of the consensus instance
 	AcceptTag = 'A'
+
+	// TimeoutTag show a vote is cast because the consensus instance timed out before deciding any block
+	TimeoutTag = 'T'
 )
 
 // Block defines blockchain block structure
@@ -27,48 +31,109 @@ type Block struct {
 
 	Round int
 
+	// Root of the Merkle tree constructed using the transactions of the block
+	TxRoot []byte
+
+	// VRF proof of the issuer over the seed of the epoch, it is the contribution of the issuer to the randomness beacon
+	BeaconProof []byte
+
+	// Encoded list of transactions, see EncodeTransactions
 	Payload []byte
 }
 
 // Hash produces the digest of a Block.
-// It considers all fields of a Block.
+// It considers the header fields of a Block, the payload is committed by TxRoot.
 func (b *Block) Hash() []byte {
+	return digest(b)
+}
+
+// Encode returns the canonical binary encoding of a Block
+func (b *Block) Encode() []byte {
+	return encodeToCanonicalBytes(b)
+}
+
+// GenesisBlocks returns the blocks of round 0, the blocks of round 1 refer to them
+func GenesisBlocks() []Block {
+	return []Block{{Issuer: []byte("initial block"), Round: 0, Payload: []byte("hello world")}}
+}
+
+// HashBlocks produces the digest of the blocks of a round.
+// It is the hash of the block if there is a single block, otherwise it is the hash of the concatenated block hashes.
+// The blocks of the next round refer to the previous round using this digest.
+func HashBlocks(blocks []Block) []byte {
+
+	if len(blocks) == 1 {
+		return blocks[0].Hash()
+	}
 
-	str := fmt.Sprintf("%x,%x,%d,%x", b.Issuer, b.PrevBlockHash, b.Round, b.Payload)
 	h := sha256.New()
-	_, err := h.Write([]byte(str))
-	if err != nil {
-		panic(err)
+	for i := range blocks {
+		_, err := h.Write(blocks[i].Hash())
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	return h.Sum(nil)
 }
 
-// AcceptProof proof of the accept. Should contain mf+1 echo messahes from different nodes for the
-// same Merkleroot
-type AcceptProof struct {
-	EchoVotes []Vote
+// DecidedRound keeps the outcome of a consensus round
+type DecidedRound struct {
+	Round int
+
+	// Decided micro blocks sorted by their Merkle roots
+	Blocks []Block
+
+	// Merkle roots of the chunks of the decided micro blocks
+	MerkleRoots [][]byte
+
+	// Echo votes that made the node accept the micro blocks
+	AcceptProof AcceptProof
+
+	// Accept votes that made the round final, their accept proofs are removed.
+	// It is empty if the round is empty.
+	AcceptCertificate AcceptCertificate
+
+	// Timeout votes that made the node decide an empty round, it is empty if the round has micro blocks
+	TimeoutCertificate TimeoutCertificate
 }
 
-// Hash hashes a AcceptProof
-func (ap AcceptProof) Hash() []byte {
+// IsEmpty returns true if no micro block is decided in the round
+func (d DecidedRound) IsEmpty() bool {
+	return len(d.Blocks) == 0
+}
 
-	if len(ap.EchoVotes) == 0 {
-		return nil
-	}
+// IsFinal returns true if the round has an accept certificate, or a timeout certificate if it is empty
+func (d DecidedRound) IsFinal() bool {
 
-	var hashSlice []byte
-	for i := range ap.EchoVotes {
-		hashSlice = append(hashSlice, ap.EchoVotes[i].Hash()...)
+	if d.IsEmpty() {
+		return len(d.TimeoutCertificate.TimeoutVotes) > 0
 	}
 
-	h := sha256.New()
-	_, err := h.Write(hashSlice)
-	if err != nil {
-		panic(err)
+	return len(d.AcceptCertificate.AcceptVotes) > 0
+}
+
+// NextBlockHash returns the hash that the micro blocks of the next round extend.
+// An empty round does not change the hash.
+func (d DecidedRound) NextBlockHash(previousBlockHash []byte) []byte {
+
+	if d.IsEmpty() {
+		return previousBlockHash
 	}
 
-	return h.Sum(nil)
+	return HashBlocks(d.Blocks)
+}
+
+// AcceptProof proof of the accept. Should contain mf+1 echo messahes from different nodes for the
+// same Merkleroot
+type AcceptProof struct {
+	EchoVotes []Vote
+}
+
+// Hash hashes a AcceptProof.
+// Signatures of echo votes are part of the digest.
+func (ap AcceptProof) Hash() []byte {
+	return digest(&ap)
 }
 
 // Vote defines a consensus vote.
@@ -83,22 +148,48 @@ type Vote struct {
 
 	BlockHash [][]byte
 
+	// LeaderProof proves that the issuer of a propose vote is a leader of the round, it is empty if the leaders are known in advance
+	LeaderProof []byte
+
 	Proof AcceptProof
 
 	Signature []byte
 }
 
-// Hash hashes a vote
+// Hash hashes a vote.
+// It considers all fields of a vote except the signature.
 func (v Vote) Hash() []byte {
+	return digest(&v)
+}
 
-	str := fmt.Sprintf("%x,%d,%d,%x,%x", v.Issuer, v.Tag, v.Round, v.BlockHash, v.Proof.Hash())
-	h := sha256.New()
-	_, err := h.Write([]byte(str))
-	if err != nil {
-		panic(err)
-	}
+// SigningHash returns the digest signed by the issuer of a vote, it is the digest of the vote without its accept proof.
+// The proof is authenticated by the signatures of its echo votes, so that an accept vote can be verified without its proof.
+func (v Vote) SigningHash() []byte {
+	v.Proof = AcceptProof{}
+	return v.Hash()
+}
 
-	return h.Sum(nil)
+// VerifySignature returns true if the vote is signed by its issuer
+func (v Vote) VerifySignature() bool {
+	return len(v.Issuer) == ed25519.PublicKeySize && ed25519.Verify(v.Issuer, v.SigningHash(), v.Signature)
+}
+
+// Encode returns the canonical binary encoding of a vote
+func (v Vote) Encode() []byte {
+	return encodeToCanonicalBytes(&v)
+}
+
+// ID returns the message ID of a vote. The accept proof is not signed by the issuer, it is part of the ID
+// so that a copy of the vote with a truncated proof relayed first does not make the copies with the complete proof duplicates.
+func (v Vote) ID() MessageID {
+	return messageID('v', func(e *encoder) {
+		e.writeBytes(v.Issuer)
+		e.writeByte(v.Tag)
+		e.writeInt(v.Round)
+		e.writeBytesList(v.BlockHash)
+		e.writeBytes(v.Signature)
+		e.writeBytes(v.Proof.Hash())
+	})
 }
 
 // BlockChunk defines a chunk of a block.
@@ -110,45 +201,71 @@ type BlockChunk struct {
 	// Round of the block
 	Round int
 
-	// The number of expected chunks to reconstruct a block
+	// The number of chunks a block is split into
 	ChunkCount int
 
+	// The number of chunks required to reconstruct a block.
+	// It is equal to ChunkCount if the block is not erasure coded.
+	DataChunkCount int
+
 	// Chunk index
 	ChunkIndex int
 
 	// Chunk authenticator to validate chunk
 	Authenticator ChunkAuthenticator
 
+	// LeaderProof is the leader proof of the propose vote of the block
+	LeaderProof []byte
+
 	// Chunk payload
 	Payload []byte
 
-	// Signature on the hash of the BlockChunk
-	Signature []byte
-
 	payloadHash []byte
 }
 
 // Hash produces the digest of a BlockChunk.
 // It considers all fields of a BlockChunk.
 func (c BlockChunk) Hash() []byte {
+	return digest(&c)
+}
 
-	str := fmt.Sprintf("%x,%d,%d,%d,%x,%x", c.Issuer, c.Round, c.ChunkCount, c.ChunkIndex, c.Authenticator.Hash(), c.Payload)
-	h := sha256.New()
-	_, err := h.Write([]byte(str))
-	if err != nil {
-		panic(err)
-	}
+// Encode returns the canonical binary encoding of a BlockChunk
+func (c BlockChunk) Encode() []byte {
+	return encodeToCanonicalBytes(&c)
+}
 
-	return h.Sum(nil)
+// ID returns the message ID of a BlockChunk.
+// The payload is not considered, it is authenticated against the Merkle root later.
+// The leader proof is considered, so that a copy with a tampered proof does not shadow the chunk.
+func (c BlockChunk) ID() MessageID {
+	return messageID('c', func(e *encoder) {
+		e.writeBytes(c.Issuer)
+		e.writeInt(c.Round)
+		e.writeInt(c.ChunkCount)
+		e.writeInt(c.DataChunkCount)
+		e.writeInt(c.ChunkIndex)
+		e.writeBytes(c.Authenticator.MerkleRoot)
+		e.writeBytes(c.LeaderProof)
+	})
 }
 
 // CalculateHash is defined in merkletree interface.
-// This method calculates the hash of the payload.
+// This method calculates the hash of the chunk index and the payload.
+// The index is part of the leaf so that a chunk can not be presented under a different index,
+// erasure decoding relies on the position of a chunk.
 func (c BlockChunk) CalculateHash() ([]byte, error) {
 
 	if c.payloadHash == nil {
+		var index [8]byte
+		binary.BigEndian.PutUint64(index[:], uint64(c.ChunkIndex))
+
 		h := sha256.New()
-		_, err := h.Write(c.Payload)
+		_, err := h.Write(index[:])
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = h.Write(c.Payload)
 		if err != nil {
 			return nil, err
 		}
@@ -192,13 +309,66 @@ type ChunkAuthenticator struct {
 // Hash produces the digest of a ChunkAuthenticator.
 // It considers all fields of a ChunkAuthenticator.
 func (c *ChunkAuthenticator) Hash() []byte {
+	return digest(c)
+}
 
-	str := fmt.Sprintf("%x,%x,%v", c.MerkleRoot, c.Path, c.Index)
-	h := sha256.New()
-	_, err := h.Write([]byte(str))
-	if err != nil {
-		panic(err)
-	}
+// BlockAnnouncement announces a block of a round.
+// It is signed once per block, and chunks of the block are authenticated
+// by their Merkle path against the announced Merkle root.
+type BlockAnnouncement struct {
+	// Publick Key of the issuer
+	Issuer []byte
 
-	return h.Sum(nil)
+	// Round of the block
+	Round int
+
+	// Root of the Merkle tree constructed using the chunks of the block
+	MerkleRoot []byte
+
+	// The number of chunks the block is split into
+	ChunkCount int
+
+	// The number of chunks required to reconstruct the block
+	DataChunkCount int
+
+	// Signature on the hash of the BlockAnnouncement
+	Signature []byte
+}
+
+// Hash produces the digest of a BlockAnnouncement.
+// It considers all fields of a BlockAnnouncement except the signature.
+func (a BlockAnnouncement) Hash() []byte {
+	return digest(&a)
+}
+
+// ID returns the message ID of a BlockAnnouncement
+func (a BlockAnnouncement) ID() MessageID {
+	return messageID('n', func(e *encoder) {
+		e.writeBytes(a.Issuer)
+		e.writeInt(a.Round)
+		e.writeBytes(a.MerkleRoot)
+		e.writeInt(a.ChunkCount)
+		e.writeInt(a.DataChunkCount)
+		e.writeBytes(a.Signature)
+	})
+}
+
+// SyncRequest requests the decided rounds starting from FromRound.
+// If ToRound is 0, the peer returns the rounds up to its last decided round.
+type SyncRequest struct {
+	// Shard is the chain of the requested rounds
+	Shard int
+
+	FromRound int
+
+	ToRound int
+}
+
+// SyncResponse contains decided rounds in increasing order.
+// A peer may return less rounds than requested to limit the size of the response.
+type SyncResponse struct {
+	Rounds []DecidedRound
+
+	// the last decided round of the peer
+	LastRound int
 }
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// ErrNotValidator is returned if a message is issued by a public key that is not in the validator set
+var ErrNotValidator = errors.New("issuer is not a validator")
+
+// ValidatorSet is the ordered set of the public keys of the nodes.
+// All the nodes build the same set from the registered nodes, the index of a validator is its position in the set.
+type ValidatorSet struct {
+	publicKeys []ed25519.PublicKey
+	indexes    map[string]int
+}
+
+// NewValidatorSet creates a validator set of the public keys in the given order
+func NewValidatorSet(publicKeys [][]byte) (*ValidatorSet, error) {
+
+	vs := &ValidatorSet{indexes: make(map[string]int)}
+
+	for i, publicKey := range publicKeys {
+		if len(publicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key of validator %d has %d bytes", i, len(publicKey))
+		}
+
+		if _, ok := vs.indexes[string(publicKey)]; ok {
+			return nil, fmt.Errorf("public key of validator %d is duplicate", i)
+		}
+
+		vs.indexes[string(publicKey)] = i
+		vs.publicKeys = append(vs.publicKeys, ed25519.PublicKey(publicKey))
+	}
+
+	return vs, nil
+}
+
+// Size returns the number of validators
+func (vs *ValidatorSet) Size() int {
+	return len(vs.publicKeys)
+}
+
+// Contains returns true if the public key belongs to a validator
+func (vs *ValidatorSet) Contains(publicKey []byte) bool {
+	_, ok := vs.indexes[string(publicKey)]
+	return ok
+}
+
+// Index returns the index of the validator with the public key, or -1 if the public key does not belong to a validator
+func (vs *ValidatorSet) Index(publicKey []byte) int {
+
+	if index, ok := vs.indexes[string(publicKey)]; ok {
+		return index
+	}
+
+	return -1
+}
+
+// PublicKey returns the public key of the validator at the index
+func (vs *ValidatorSet) PublicKey(index int) ed25519.PublicKey {
+	return vs.publicKeys[index]
+}
+
+// CheckMessage checks that a message is issued by a validator.
+// The echo votes in the proof of an accept vote must be issued by validators too.
+func (vs *ValidatorSet) CheckMessage(message Message) error {
+
+	if issued, ok := message.(IssuedMessage); ok && !vs.Contains(issued.MessageIssuer()) {
+		return ErrNotValidator
+	}
+
+	if vote, ok := message.(Vote); ok {
+		return vs.CheckVotes(vote.Proof.EchoVotes)
+	}
+
+	return nil
+}
+
+// CheckVotes checks that all the votes are issued by validators
+func (vs *ValidatorSet) CheckVotes(votes []Vote) error {
+
+	for i := range votes {
+		if !vs.Contains(votes[i].Issuer) {
+			return fmt.Errorf("vote %d: %w", i, ErrNotValidator)
+		}
+	}
+
+	return nil
+}
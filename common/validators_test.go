@@ -0,0 +1,51 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func TestValidatorSet(t *testing.T) {
+
+	var publicKeys [][]byte
+	var privateKeys []ed25519.PrivateKey
+	for i := 0; i < 3; i++ {
+		publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+		publicKeys = append(publicKeys, publicKey)
+		privateKeys = append(privateKeys, privateKey)
+	}
+
+	if _, err := NewValidatorSet([][]byte{publicKeys[0], publicKeys[1], publicKeys[0]}); err == nil {
+		t.Errorf("expected an error because a public key is duplicate")
+	}
+
+	validators, err := NewValidatorSet(publicKeys[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if validators.Size() != 2 || validators.Index(publicKeys[1]) != 1 || validators.Index(publicKeys[2]) != -1 {
+		t.Errorf("unexpected validator indexes")
+	}
+
+	echoVote := Vote{Issuer: publicKeys[0], Tag: EchoTag, Round: 1, BlockHash: [][]byte{[]byte("merkle root")}}
+	echoVote.Signature = ed25519.Sign(privateKeys[0], echoVote.SigningHash())
+	if err := validators.CheckMessage(echoVote); err != nil {
+		t.Fatal(err)
+	}
+
+	// a valid signature of a key that is not registered
+	forged := echoVote
+	forged.Issuer = publicKeys[2]
+	forged.Signature = ed25519.Sign(privateKeys[2], forged.SigningHash())
+	if err := validators.CheckMessage(forged); !errors.Is(err, ErrNotValidator) {
+		t.Errorf("expected ErrNotValidator, got %v", err)
+	}
+
+	acceptVote := Vote{Issuer: publicKeys[1], Tag: AcceptTag, Round: 1, BlockHash: echoVote.BlockHash, Proof: AcceptProof{EchoVotes: []Vote{echoVote, forged}}}
+	acceptVote.Signature = ed25519.Sign(privateKeys[1], acceptVote.SigningHash())
+	if err := validators.CheckMessage(acceptVote); !errors.Is(err, ErrNotValidator) {
+		t.Errorf("expected ErrNotValidator for an echo vote of the accept proof, got %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KindVerification defines how the messages of a kind are verified
+type KindVerification struct {
+	// Verify checks the message without the consensus state, such as its signatures and Merkle paths
+	Verify func(message Message) error
+
+	// Digest commits to all the content checked by Verify, it keys the cache of the verified messages.
+	// The messages of the kind are not cached if it is nil.
+	Digest func(message Message) MessageID
+}
+
+// VerificationStats keeps the counters of the verification of a message kind
+type VerificationStats struct {
+	Verified  uint64
+	Failed    uint64
+	CacheHits uint64
+
+	// total time the messages waited for a worker
+	WaitTime time.Duration
+
+	// total time spent verifying the messages, and the longest verification of a single message
+	VerifyTime    time.Duration
+	MaxVerifyTime time.Duration
+}
+
+// Verifier verifies the received messages using a pool of workers before they are enqueued to the demultiplexer,
+// so that the consensus layer does not spend its time on signatures and Merkle paths.
+// The messages that are verified once are cached, so that the forwarded copies are not verified again.
+type Verifier struct {
+	jobs chan verificationJob
+
+	mutex sync.Mutex
+	kinds map[MessageKind]KindVerification
+	stats map[MessageKind]*VerificationStats
+
+	// it keeps the digests of the verified messages, it is nil if caching is disabled
+	cache *LRUDeduplicator
+}
+
+type verificationJob struct {
+	message      Message
+	verification KindVerification
+	submitted    time.Time
+	result       chan error
+}
+
+// NewVerifier creates a verifier with workerCount workers. It caches up to cacheCapacity verified messages,
+// caching is disabled if cacheCapacity is 0. The block chunks, block announcements, and votes are registered.
+func NewVerifier(workerCount int, cacheCapacity int) *Verifier {
+
+	if workerCount < 1 {
+		panic(fmt.Errorf("illegal verification worker count %d", workerCount))
+	}
+
+	v := &Verifier{
+		jobs:  make(chan verificationJob, workerCount),
+		kinds: make(map[MessageKind]KindVerification),
+		stats: make(map[MessageKind]*VerificationStats),
+	}
+
+	if cacheCapacity > 0 {
+		v.cache = NewLRUDeduplicator(cacheCapacity)
+	}
+
+	voteVerification := KindVerification{Verify: verifyVote, Digest: voteDigest}
+	verifications := map[MessageKind]KindVerification{
+		BlockChunkKind:        {Verify: verifyBlockChunk, Digest: blockChunkDigest},
+		BlockAnnouncementKind: {Verify: verifyBlockAnnouncement, Digest: blockAnnouncementDigest},
+		ProposeVoteKind:       voteVerification,
+		EchoVoteKind:          voteVerification,
+		AcceptVoteKind:        voteVerification,
+		TimeoutVoteKind:       voteVerification,
+	}
+
+	for kind, verification := range verifications {
+		if err := v.RegisterKind(kind, verification); err != nil {
+			panic(err)
+		}
+	}
+
+	for i := 0; i < workerCount; i++ {
+		go v.worker()
+	}
+
+	return v
+}
+
+// RegisterKind registers the verification of a message kind
+func (v *Verifier) RegisterKind(kind MessageKind, verification KindVerification) error {
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if _, ok := v.kinds[kind]; ok {
+		return fmt.Errorf("verification of message kind %q is already registered", kind)
+	}
+
+	if verification.Verify == nil {
+		return fmt.Errorf("verification of message kind %q has no verify function", kind)
+	}
+
+	v.kinds[kind] = verification
+	v.stats[kind] = &VerificationStats{}
+
+	return nil
+}
+
+// Kinds returns the message kinds whose verification is registered
+func (v *Verifier) Kinds() []MessageKind {
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	var kinds []MessageKind
+	for kind := range v.kinds {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+
+	return kinds
+}
+
+// Verify verifies a message on a worker and waits for the result.
+// The messages of the kinds that are not registered are not verified.
+// The verifier is shared by the shard chains, the membership of the issuer in the committee of the round is checked by the caller
+// before the message is submitted, e.g. by RapidchainConsensus.CheckMessage. It can be used as the Validate function of a demultiplexer kind.
+func (v *Verifier) Verify(message Message) error {
+
+	v.mutex.Lock()
+	verification, ok := v.kinds[message.Kind()]
+	v.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	job := verificationJob{message: message, verification: verification, submitted: time.Now(), result: make(chan error, 1)}
+	v.jobs <- job
+
+	return <-job.result
+}
+
+// Stats returns the counters of the verification of each message kind
+func (v *Verifier) Stats() map[MessageKind]VerificationStats {
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	stats := make(map[MessageKind]VerificationStats)
+	for kind, s := range v.stats {
+		stats[kind] = *s
+	}
+
+	return stats
+}
+
+func (v *Verifier) worker() {
+
+	for job := range v.jobs {
+		job.result <- v.verify(job)
+	}
+}
+
+func (v *Verifier) verify(job verificationJob) error {
+
+	start := time.Now()
+	kind := job.message.Kind()
+	round := job.message.MessageRound()
+
+	var digest MessageID
+	cached := v.cache != nil && job.verification.Digest != nil
+	if cached {
+		digest = job.verification.Digest(job.message)
+	}
+
+	v.mutex.Lock()
+	stats := v.stats[kind]
+	stats.WaitTime += start.Sub(job.submitted)
+	if cached && v.cache.Contains(round, digest) {
+		stats.CacheHits++
+		v.mutex.Unlock()
+		return nil
+	}
+	v.mutex.Unlock()
+
+	err := job.verification.Verify(job.message)
+	elapsed := time.Since(start)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	stats.VerifyTime += elapsed
+	if elapsed > stats.MaxVerifyTime {
+		stats.MaxVerifyTime = elapsed
+	}
+
+	if err != nil {
+		stats.Failed++
+		return err
+	}
+
+	stats.Verified++
+	if cached {
+		v.cache.Add(round, digest)
+	}
+
+	return nil
+}
+
+// verifyVote checks the signature of a vote, and the signatures of the echo votes in the proof of an accept vote
+func verifyVote(message Message) error {
+
+	vote := message.(Vote)
+	if !vote.VerifySignature() {
+		return fmt.Errorf("vote has an invalid signature")
+	}
+
+	if vote.Tag == AcceptTag {
+		return VerifyEchoVotes(vote.Proof.EchoVotes, vote.Round, vote.BlockHash)
+	}
+
+	return nil
+}
+
+// verifyBlockChunk checks the Merkle path of a chunk against the Merkle root it carries.
+// The root is authenticated later by the signed announcement of the block.
+func verifyBlockChunk(message Message) error {
+
+	chunk := message.(BlockChunk)
+	return VerifyChunk(chunk.Authenticator.MerkleRoot, chunk)
+}
+
+func verifyBlockAnnouncement(message Message) error {
+
+	announcement := message.(BlockAnnouncement)
+	if len(announcement.Issuer) != ed25519.PublicKeySize || !ed25519.Verify(announcement.Issuer, announcement.Hash(), announcement.Signature) {
+		return fmt.Errorf("block announcement has an invalid signature")
+	}
+
+	return nil
+}
+
+// the digests differ from the message IDs, they also cover the accept proofs, the Merkle paths, and the payloads
+
+func voteDigest(message Message) MessageID {
+
+	vote := message.(Vote)
+	return messageID('V', func(e *encoder) {
+		e.writeBytes(vote.Hash())
+		e.writeBytes(vote.Signature)
+	})
+}
+
+func blockChunkDigest(message Message) MessageID {
+
+	chunk := message.(BlockChunk)
+	return messageID('C', func(e *encoder) {
+		e.writeBytes(chunk.Hash())
+	})
+}
+
+func blockAnnouncementDigest(message Message) MessageID {
+
+	announcement := message.(BlockAnnouncement)
+	return messageID('N', func(e *encoder) {
+		e.writeBytes(announcement.Hash())
+		e.writeBytes(announcement.Signature)
+	})
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+
+	verifier := NewVerifier(4, 16)
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	vote := Vote{Issuer: publicKey, Tag: EchoTag, Round: 3, BlockHash: [][]byte{[]byte("merkle root")}}
+	vote.Signature = ed25519.Sign(privateKey, vote.Hash())
+
+	// the forwarded copy is not verified again
+	for i := 0; i < 2; i++ {
+		if err := verifier.Verify(vote); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	forged := vote
+	forged.BlockHash = [][]byte{[]byte("another merkle root")}
+	if verifier.Verify(forged) == nil {
+		t.Errorf("vote with an invalid signature is verified")
+	}
+
+	acceptVote := Vote{Issuer: publicKey, Tag: AcceptTag, Round: 3, BlockHash: vote.BlockHash, Proof: AcceptProof{EchoVotes: []Vote{forged}}}
+	acceptVote.Signature = ed25519.Sign(privateKey, acceptVote.Hash())
+	if verifier.Verify(acceptVote) == nil {
+		t.Errorf("accept vote with an invalid echo vote is verified")
+	}
+
+	chunks, _ := ChunkBlock(NewBlock(publicKey, nil, 3, nil), 4)
+	if err := verifier.Verify(chunks[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := chunks[2]
+	tampered.Authenticator.Path = [][]byte{chunks[2].Authenticator.Path[1], chunks[2].Authenticator.Path[0]}
+	if verifier.Verify(tampered) == nil {
+		t.Errorf("chunk with an invalid merkle path is verified")
+	}
+
+	stats := verifier.Stats()
+	if echo := stats[EchoVoteKind]; echo.Verified != 1 || echo.CacheHits != 1 || echo.Failed != 1 {
+		t.Errorf("unexpected echo vote stats %+v", echo)
+	}
+
+	if accept := stats[AcceptVoteKind]; accept.Failed != 1 {
+		t.Errorf("unexpected accept vote stats %+v", accept)
+	}
+
+	if chunk := stats[BlockChunkKind]; chunk.Verified != 1 || chunk.Failed != 1 || chunk.VerifyTime == 0 {
+		t.Errorf("unexpected chunk stats %+v", chunk)
+	}
+}
+
+func TestDemultiplexerValidator(t *testing.T) {
+
+	demux := NewDemultiplexer(0)
+	verifier := NewVerifier(2, 0)
+	for _, kind := range verifier.Kinds() {
+		demux.SetValidator(kind, verifier.Verify)
+	}
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	vote := Vote{Issuer: publicKey, Tag: ProposeTag, Round: 1, BlockHash: [][]byte{[]byte("merkle root")}}
+
+	if demux.Enque(vote) == nil {
+		t.Errorf("unsigned vote is enqueued")
+	}
+
+	vote.Signature = ed25519.Sign(privateKey, vote.Hash())
+	if err := demux.Enque(vote); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := demux.GetChan(1, ProposeVoteKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(queue) != 1 {
+		t.Errorf("expected 1 vote in the queue, got %d", len(queue))
+	}
+}
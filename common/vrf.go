@@ -0,0 +1,327 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+)
+
+// VRF implements ECVRF-EDWARDS25519-SHA512-TAI of RFC 9381 with the ed25519 keys of the nodes.
+// The output of a key for an input is unique and it is verifiable by anyone who has the public key,
+// but it can not be predicted without the private key.
+//
+// The curve arithmetic uses math/big, it is not constant time.
+
+// VRFProofSize is the size of a VRF proof, the encoded Gamma point, the challenge, and the response
+const VRFProofSize = 32 + vrfChallengeSize + 32
+
+// VRFOutputSize is the size of a VRF output
+const VRFOutputSize = sha512.Size
+
+// ErrInvalidVRFProof is returned if a VRF proof is not valid for the public key and the input
+var ErrInvalidVRFProof = errors.New("vrf proof is not valid")
+
+const vrfSuite = 0x03
+
+const vrfChallengeSize = 16
+
+// VRFProve returns the VRF output of the private key for the input, and its proof
+func VRFProve(privateKey ed25519.PrivateKey, alpha []byte) (output []byte, proof []byte) {
+
+	h := sha512.Sum512(privateKey.Seed())
+	x := clampedScalar(h[:32])
+
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	y, ok := decodePoint(publicKey)
+	if !ok {
+		panic(errors.New("public key is not a valid point"))
+	}
+
+	hashPoint := vrfHashToCurve(publicKey, alpha)
+	hashString := hashPoint.encode()
+
+	gamma := hashPoint.mul(x)
+
+	nonceHash := sha512.New()
+	nonceHash.Write(h[32:])
+	nonceHash.Write(hashString)
+	k := littleEndianInt(nonceHash.Sum(nil))
+	k.Mod(k, curveOrder)
+
+	c := vrfChallenge(y, hashPoint, gamma, basePoint.mul(k), hashPoint.mul(k))
+
+	s := new(big.Int).Mul(c, x)
+	s.Add(s, k)
+	s.Mod(s, curveOrder)
+
+	proof = make([]byte, 0, VRFProofSize)
+	proof = append(proof, gamma.encode()...)
+	proof = append(proof, littleEndianBytes(c, vrfChallengeSize)...)
+	proof = append(proof, littleEndianBytes(s, 32)...)
+
+	return vrfProofToHash(gamma), proof
+}
+
+// VRFVerify verifies the proof of the public key for the input, and returns the VRF output
+func VRFVerify(publicKey []byte, alpha []byte, proof []byte) ([]byte, error) {
+
+	if len(publicKey) != ed25519.PublicKeySize || len(proof) != VRFProofSize {
+		return nil, ErrInvalidVRFProof
+	}
+
+	y, ok := decodePoint(publicKey)
+	if !ok || y.mul(cofactor).isIdentity() {
+		return nil, ErrInvalidVRFProof
+	}
+
+	gamma, ok := decodePoint(proof[:32])
+	if !ok {
+		return nil, ErrInvalidVRFProof
+	}
+
+	c := littleEndianInt(proof[32 : 32+vrfChallengeSize])
+	s := littleEndianInt(proof[32+vrfChallengeSize:])
+	if s.Cmp(curveOrder) >= 0 {
+		return nil, ErrInvalidVRFProof
+	}
+
+	hashPoint := vrfHashToCurve(publicKey, alpha)
+
+	// U = s*B - c*Y, V = s*H - c*Gamma
+	u := basePoint.mul(s).add(y.mul(c).negate())
+	v := hashPoint.mul(s).add(gamma.mul(c).negate())
+
+	if vrfChallenge(y, hashPoint, gamma, u, v).Cmp(c) != 0 {
+		return nil, ErrInvalidVRFProof
+	}
+
+	return vrfProofToHash(gamma), nil
+}
+
+// vrfHashToCurve maps the input to a point using the try and increment method
+func vrfHashToCurve(publicKey []byte, alpha []byte) *edwardsPoint {
+
+	for counter := 0; counter < 256; counter++ {
+		h := sha512.New()
+		h.Write([]byte{vrfSuite, 0x01})
+		h.Write(publicKey)
+		h.Write(alpha)
+		h.Write([]byte{byte(counter), 0x00})
+
+		if p, ok := decodePoint(h.Sum(nil)[:32]); ok {
+			return p.mul(cofactor)
+		}
+	}
+
+	panic(errors.New("could not hash the input to a point"))
+}
+
+func vrfChallenge(points ...*edwardsPoint) *big.Int {
+
+	h := sha512.New()
+	h.Write([]byte{vrfSuite, 0x02})
+	for _, p := range points {
+		h.Write(p.encode())
+	}
+	h.Write([]byte{0x00})
+
+	return littleEndianInt(h.Sum(nil)[:vrfChallengeSize])
+}
+
+func vrfProofToHash(gamma *edwardsPoint) []byte {
+
+	h := sha512.New()
+	h.Write([]byte{vrfSuite, 0x03})
+	h.Write(gamma.mul(cofactor).encode())
+	h.Write([]byte{0x00})
+
+	return h.Sum(nil)
+}
+
+// edwards25519 arithmetic
+
+var (
+	fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	curveOrder = mustParseInt("7237005577332262213973186563042994240857116359379907606001950938285454250989")
+	curveD     = mustParseInt("37095705934669439343138083508754565189542113879843219016388785533085940283555")
+	sqrtM1     = mustParseInt("19681161376707505956807079304988542015446066515923890162744021073123829784752")
+	cofactor   = big.NewInt(8)
+	basePoint  = mustDecodePoint("5866666666666666666666666666666666666666666666666666666666666666")
+)
+
+// edwardsPoint is a point in extended coordinates, x = X/Z, y = Y/Z, x*y = T/Z
+type edwardsPoint struct {
+	x, y, z, t *big.Int
+}
+
+func identityPoint() *edwardsPoint {
+	return &edwardsPoint{x: big.NewInt(0), y: big.NewInt(1), z: big.NewInt(1), t: big.NewInt(0)}
+}
+
+// add adds two points using the complete addition formula of RFC 8032
+func (p *edwardsPoint) add(q *edwardsPoint) *edwardsPoint {
+
+	a := fieldMul(fieldSub(p.y, p.x), fieldSub(q.y, q.x))
+	b := fieldMul(fieldAdd(p.y, p.x), fieldAdd(q.y, q.x))
+	c := fieldMul(fieldMul(p.t, q.t), fieldMul(big.NewInt(2), curveD))
+	d := fieldMul(fieldMul(p.z, q.z), big.NewInt(2))
+	e, f, g, h := fieldSub(b, a), fieldSub(d, c), fieldAdd(d, c), fieldAdd(b, a)
+
+	return &edwardsPoint{x: fieldMul(e, f), y: fieldMul(g, h), z: fieldMul(f, g), t: fieldMul(e, h)}
+}
+
+func (p *edwardsPoint) negate() *edwardsPoint {
+	return &edwardsPoint{x: fieldSub(big.NewInt(0), p.x), y: p.y, z: p.z, t: fieldSub(big.NewInt(0), p.t)}
+}
+
+// mul multiplies the point by a non negative scalar
+func (p *edwardsPoint) mul(scalar *big.Int) *edwardsPoint {
+
+	result := identityPoint()
+	for i := scalar.BitLen() - 1; i >= 0; i-- {
+		result = result.add(result)
+		if scalar.Bit(i) == 1 {
+			result = result.add(p)
+		}
+	}
+
+	return result
+}
+
+func (p *edwardsPoint) isIdentity() bool {
+	return fieldMod(p.x).Sign() == 0 && fieldSub(p.y, p.z).Sign() == 0
+}
+
+// encode encodes the point as in RFC 8032, the little endian y coordinate with the sign of x in the highest bit
+func (p *edwardsPoint) encode() []byte {
+
+	zInverse := new(big.Int).ModInverse(p.z, fieldPrime)
+	x := fieldMul(p.x, zInverse)
+	y := fieldMul(p.y, zInverse)
+
+	encoded := littleEndianBytes(y, 32)
+	encoded[31] |= byte(x.Bit(0) << 7)
+
+	return encoded
+}
+
+// decodePoint decodes a point encoded as in RFC 8032, it returns false if the encoding is not valid
+func decodePoint(encoded []byte) (*edwardsPoint, bool) {
+
+	if len(encoded) != 32 {
+		return nil, false
+	}
+
+	yBytes := append([]byte{}, encoded...)
+	sign := uint(yBytes[31] >> 7)
+	yBytes[31] &= 0x7f
+
+	y := littleEndianInt(yBytes)
+	if y.Cmp(fieldPrime) >= 0 {
+		return nil, false
+	}
+
+	// x^2 = (y^2 - 1) / (d*y^2 + 1)
+	ySquare := fieldMul(y, y)
+	u := fieldSub(ySquare, big.NewInt(1))
+	v := fieldAdd(fieldMul(curveD, ySquare), big.NewInt(1))
+
+	// candidate root x = u*v^3 * (u*v^7)^((p-5)/8)
+	exponent := new(big.Int).Sub(fieldPrime, big.NewInt(5))
+	exponent.Rsh(exponent, 3)
+	v3 := fieldMul(fieldMul(v, v), v)
+	v7 := fieldMul(fieldMul(v3, v3), v)
+	x := fieldMul(fieldMul(u, v3), new(big.Int).Exp(fieldMul(u, v7), exponent, fieldPrime))
+
+	vxSquare := fieldMul(v, fieldMul(x, x))
+	switch {
+	case vxSquare.Cmp(u) == 0:
+	case vxSquare.Cmp(fieldSub(big.NewInt(0), u)) == 0:
+		x = fieldMul(x, sqrtM1)
+	default:
+		return nil, false
+	}
+
+	if x.Sign() == 0 && sign == 1 {
+		return nil, false
+	}
+
+	if x.Bit(0) != sign {
+		x = fieldSub(big.NewInt(0), x)
+	}
+
+	return &edwardsPoint{x: x, y: y, z: big.NewInt(1), t: fieldMul(x, y)}, true
+}
+
+func mustDecodePoint(hexEncoded string) *edwardsPoint {
+
+	encoded, ok := new(big.Int).SetString(hexEncoded, 16)
+	if !ok {
+		panic(errors.New("illegal point encoding"))
+	}
+
+	p, ok := decodePoint(encoded.FillBytes(make([]byte, 32)))
+	if !ok {
+		panic(errors.New("illegal point encoding"))
+	}
+
+	return p
+}
+
+func mustParseInt(decimal string) *big.Int {
+
+	value, ok := new(big.Int).SetString(decimal, 10)
+	if !ok {
+		panic(errors.New("illegal integer"))
+	}
+
+	return value
+}
+
+// clampedScalar returns the secret scalar of an ed25519 key from the first half of the hash of its seed
+func clampedScalar(hashPrefix []byte) *big.Int {
+
+	scalar := append([]byte{}, hashPrefix...)
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+
+	return littleEndianInt(scalar)
+}
+
+func fieldMod(a *big.Int) *big.Int {
+	return new(big.Int).Mod(a, fieldPrime)
+}
+
+func fieldAdd(a, b *big.Int) *big.Int {
+	return fieldMod(new(big.Int).Add(a, b))
+}
+
+func fieldSub(a, b *big.Int) *big.Int {
+	return fieldMod(new(big.Int).Sub(a, b))
+}
+
+func fieldMul(a, b *big.Int) *big.Int {
+	return fieldMod(new(big.Int).Mul(a, b))
+}
+
+func littleEndianInt(data []byte) *big.Int {
+
+	reversed := make([]byte, len(data))
+	for i := range data {
+		reversed[len(data)-1-i] = data[i]
+	}
+
+	return new(big.Int).SetBytes(reversed)
+}
+
+func littleEndianBytes(value *big.Int, size int) []byte {
+
+	encoded := value.FillBytes(make([]byte, size))
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+
+	return encoded
+}
@@ -0,0 +1,48 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+func TestVRF(t *testing.T) {
+
+	// example 16 of RFC 9381, ECVRF-EDWARDS25519-SHA512-TAI
+	seed, _ := hex.DecodeString("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	expectedProof, _ := hex.DecodeString("8657106690b5526245a92b003bb079ccd1a92130477671f6fc01ad16f26f723f26f8a57ccaed74ee1b190bed1f479d9727d2d0f9b005a6e456a35d4fb0daab1268a1b0db10836d9826a528ca76567805")
+	expectedOutput, _ := hex.DecodeString("90cf1df3b703cce59e2a35b925d411164068269d7b2d29f3301c03dd757876ff66b71dda49d2de59d03450451af026798e8f81cd2e333de5cdf4f3e140fdd8ae")
+
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	output, proof := VRFProve(privateKey, nil)
+	if !bytes.Equal(proof, expectedProof) || !bytes.Equal(output, expectedOutput) {
+		t.Fatalf("unexpected proof %x and output %x", proof, output)
+	}
+
+	verifiedOutput, err := VRFVerify(publicKey, nil, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(verifiedOutput, expectedOutput) {
+		t.Errorf("unexpected verified output %x", verifiedOutput)
+	}
+
+	if _, err := VRFVerify(publicKey, []byte("another input"), proof); err != ErrInvalidVRFProof {
+		t.Errorf("expected ErrInvalidVRFProof for another input, got %v", err)
+	}
+
+	tampered := append([]byte{}, proof...)
+	tampered[40] ^= 1
+	if _, err := VRFVerify(publicKey, nil, tampered); err != ErrInvalidVRFProof {
+		t.Errorf("expected ErrInvalidVRFProof for a tampered proof, got %v", err)
+	}
+
+	otherPublicKey, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VRFVerify(otherPublicKey, nil, proof); err != ErrInvalidVRFProof {
+		t.Errorf("expected ErrInvalidVRFProof for another public key, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package consensus
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"sort"
@@ -11,73 +12,149 @@ import (
 )
 
 type blockReceiver struct {
-	blockCount     int
-	chunkCount     int
-	blockMap       map[string][]common.BlockChunk
-	wg             sync.WaitGroup
-	receivedBlocks map[string]common.Block
+	leaders            leaderSet
+	blockCount         int
+	chunkCount         int
+	requiredChunkCount int
+	previousBlockHash  []byte
+	committed          func(txHash []byte) bool
+	blockMap           map[string]map[int]common.BlockChunk
+	wg                 sync.WaitGroup
+	mutex              sync.Mutex
+	receivedBlocks     map[string]common.Block
+	invalidBlocks      map[string]error
 }
 
-func newBlockReceiver(leaderCount int, chunkCount int) *blockReceiver {
+// newBlockReceiver creates a block receiver. A block is reconstructed as soon as requiredChunkCount of its chunkCount chunks are received.
+// requiredChunkCount is smaller than chunkCount only if blocks are erasure coded.
+// The blocks that do not extend previousBlockHash or include a committed transaction are not valid, and the chunks that are not issued by the leaders are not expected.
+func newBlockReceiver(leaders leaderSet, blockCount int, chunkCount int, requiredChunkCount int, previousBlockHash []byte, committed func(txHash []byte) bool) *blockReceiver {
 
 	r := &blockReceiver{
-		blockCount:     leaderCount,
-		chunkCount:     chunkCount,
-		blockMap:       make(map[string][]common.BlockChunk),
-		receivedBlocks: make(map[string]common.Block),
+		leaders:            leaders,
+		blockCount:         blockCount,
+		chunkCount:         chunkCount,
+		requiredChunkCount: requiredChunkCount,
+		previousBlockHash:  previousBlockHash,
+		committed:          committed,
+		blockMap:           make(map[string]map[int]common.BlockChunk),
+		receivedBlocks:     make(map[string]common.Block),
+		invalidBlocks:      make(map[string]error),
 	}
 
 	return r
 }
 
-// AddChunk stores a chunk of a block to reconstruct the whole block later
-func (r *blockReceiver) AddChunk(chunk common.BlockChunk) {
+// AddChunk stores a chunk of a block to reconstruct the whole block later.
+// It returns an error if the chunk is not expected, the chunk is not stored in that case.
+// A chunk whose index is already received is ignored, the chunks are verified against their merkle roots so it is the same chunk.
+func (r *blockReceiver) AddChunk(chunk common.BlockChunk) error {
+
+	// a chunk of a validator that is not a leader can not take the place of the block of a leader
+	if !r.leaders.contains(chunk.Issuer) {
+		return ErrNotLeader
+	}
+
+	if chunk.ChunkCount != r.chunkCount || chunk.DataChunkCount != r.requiredChunkCount {
+		return fmt.Errorf("unexpected chunk counts, chunk count %d data chunk count %d", chunk.ChunkCount, chunk.DataChunkCount)
+	}
+
 	key := string(chunk.Authenticator.MerkleRoot)
-	chunkSlice := r.blockMap[key]
-	r.blockMap[key] = append(chunkSlice, chunk)
+	if _, ok := r.blockMap[key]; !ok && len(r.blockMap) == r.blockCount {
+		return fmt.Errorf("there are more blocks than expected, the number of blocks is %d", r.blockCount)
+	}
+
+	chunks, ok := r.blockMap[key]
+	if !ok {
+		chunks = make(map[int]common.BlockChunk)
+		r.blockMap[key] = chunks
+	}
 
-	if len(r.blockMap) > r.blockCount {
-		panic(fmt.Errorf("there are more blocks than expected, the number of blocks is %d", len(r.blockMap)))
+	if _, ok := chunks[chunk.ChunkIndex]; ok {
+		return nil
 	}
+	chunks[chunk.ChunkIndex] = chunk
 
-	if len(r.blockMap[key]) == r.chunkCount {
-		// it means that we have the all chunks of the microblock
+	if len(chunks) == r.requiredChunkCount {
+		// it means that we have enough distinct chunks of the microblock
 		// we can walidate it here
+		receivedChunks := make([]common.BlockChunk, 0, len(chunks))
+		for _, c := range chunks {
+			receivedChunks = append(receivedChunks, c)
+		}
+
 		r.wg.Add(1)
 		go func() {
 			defer r.wg.Done()
 
-			receivedChunks := r.blockMap[key]
 			sort.Slice(receivedChunks, func(i, j int) bool {
 				return receivedChunks[i].ChunkIndex < receivedChunks[j].ChunkIndex
 			})
 
-			block := common.MergeChunks(receivedChunks)
-			log.Printf("[%s] chunked count of the recived block is %d payload is %d bytes\n", encodeBase64([]byte(key[:15])), len(receivedChunks), len(block.Payload))
-			r.receivedBlocks[key] = block
-			payloadSize := len(block.Payload)
+			block, err := r.validateBlock(receivedChunks)
 
-			// emulating cost of validating a micro block
-			sleepTime := (float64(0.133) * float64(payloadSize/512))
-			sleepDuration := time.Duration(sleepTime) * time.Millisecond
-			log.Printf("the node will sleep to emulate tx validation, and merkle tree construction %s \n", sleepDuration)
-			time.Sleep(sleepDuration)
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+
+			if err != nil {
+				r.invalidBlocks[key] = err
+				return
+			}
+			r.receivedBlocks[key] = block
 
 		}()
 
 	}
 
+	return nil
+}
+
+// validateBlock reconstructs a block, and validates it
+func (r *blockReceiver) validateBlock(chunks []common.BlockChunk) (common.Block, error) {
+
+	block, err := common.ReconstructBlock(chunks)
+	if err != nil {
+		return block, err
+	}
+
+	log.Printf("[%s] chunked count of the recived block is %d payload is %d bytes\n", encodeBase64(chunks[0].Authenticator.MerkleRoot[:15]), len(chunks), len(block.Payload))
+
+	if !validateBlock(block, r.previousBlockHash) {
+		return block, ErrBlockNotValid
+	}
+
+	// the beacon proof of the block is verified against the key of its issuer, it must be the leader that proposed it
+	if !bytes.Equal(block.Issuer, chunks[0].Issuer) {
+		return block, fmt.Errorf("block issuer %x is not the issuer of its chunks", block.Issuer)
+	}
+
+	// validates transactions, and the transaction merkle tree of the micro block
+	startTime := time.Now()
+	transactions, err := block.ValidateBody()
+	if err != nil {
+		return block, err
+	}
+
+	// a transaction of a previous round can not be replayed
+	for i := range transactions {
+		if r.committed(transactions[i].Hash()) {
+			return block, fmt.Errorf("transaction %d is already committed", i)
+		}
+	}
+	log.Printf("validated %d transactions in %s\n", len(transactions), time.Since(startTime))
+
+	return block, nil
 }
 
-// ReceivedAll checks whether all chunks are recived or not to reconstruct the blocks of a round
+// ReceivedAll checks whether enough chunks are recived or not to reconstruct the blocks of a round
 func (r *blockReceiver) ReceivedAll() bool {
 
 	if len(r.blockMap) != r.blockCount {
 		return false
 	}
 
-	for _, chunkSlice := range r.blockMap {
-		if len(chunkSlice) != r.chunkCount {
+	for _, chunks := range r.blockMap {
+		if len(chunks) < r.requiredChunkCount {
 			return false
 		}
 	}
@@ -85,12 +162,10 @@ func (r *blockReceiver) ReceivedAll() bool {
 	return true
 }
 
-// GetBlocks recunstruct blocks using chunks, and returns the blocks by sorting the resulting block slice according to block hashes
-func (r *blockReceiver) GetBlocks() ([]common.Block, [][]byte) {
-
-	if r.ReceivedAll() == false {
-		panic(fmt.Errorf("not received all block chunks to reconstruct block/s"))
-	}
+// GetBlocks recunstruct blocks using chunks, and returns the valid blocks by sorting the resulting block slice according to block hashes.
+// The blocks that do not have enough chunks are not returned, if the block receive phase timed out.
+// The errors of the invalid blocks are keyed by their merkle roots.
+func (r *blockReceiver) GetBlocks() ([]common.Block, [][]byte, map[string]error) {
 
 	// waiting for the block validation before returning blocks
 	r.wg.Wait()
@@ -109,5 +184,5 @@ func (r *blockReceiver) GetBlocks() ([]common.Block, [][]byte) {
 		blocks = append(blocks, r.receivedBlocks[key])
 	}
 
-	return blocks, merkleRoots
+	return blocks, merkleRoots, r.invalidBlocks
 }
@@ -0,0 +1,160 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+func TestBlockReceiverRejectsInvalidChunksAndBlocks(t *testing.T) {
+
+	config := registery.NodeConfig{LeaderCount: 2, BlockChunkCount: 4, DataChunkCount: 4}
+	previousBlockHash := []byte("previous block hash")
+
+	validChunks, validRoot := common.ChunkBlockWithCounts(common.NewBlock([]byte("leader 1"), previousBlockHash, 3, nil), config.RequiredChunkCount(), config.BlockChunkCount)
+	invalidChunks, _ := common.ChunkBlockWithCounts(common.NewBlock([]byte("leader 2"), []byte("another hash"), 3, nil), config.RequiredChunkCount(), config.BlockChunkCount)
+	extraChunks, _ := common.ChunkBlockWithCounts(common.NewBlock([]byte("leader 3"), previousBlockHash, 3, nil), config.RequiredChunkCount(), config.BlockChunkCount)
+
+	leaders := leaderSet{"leader 1": {}, "leader 2": {}}
+	setIssuer(validChunks, "leader 1")
+	setIssuer(invalidChunks, "leader 2")
+	setIssuer(extraChunks, "leader 1")
+
+	receiver := newBlockReceiver(leaders, config.LeaderCount, config.BlockChunkCount, config.RequiredChunkCount(), previousBlockHash, notCommitted)
+
+	// a validator that is not a leader can not take the place of a leader
+	notLeader := extraChunks[0]
+	notLeader.Issuer = []byte("not a leader")
+	if err := receiver.AddChunk(notLeader); err != ErrNotLeader {
+		t.Errorf("expected ErrNotLeader, got %v", err)
+	}
+
+	for i := range validChunks {
+		if err := receiver.AddChunk(validChunks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wrongCount := invalidChunks[0]
+	wrongCount.ChunkCount = 8
+	if receiver.AddChunk(wrongCount) == nil {
+		t.Errorf("chunk with unexpected chunk count is added")
+	}
+
+	for i := range invalidChunks {
+		if err := receiver.AddChunk(invalidChunks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if receiver.AddChunk(extraChunks[0]) == nil {
+		t.Errorf("chunk of an extra block is added")
+	}
+
+	if !receiver.ReceivedAll() {
+		t.Fatalf("expected to receive all blocks")
+	}
+
+	blocks, merkleRoots, invalidBlocks := receiver.GetBlocks()
+	if len(blocks) != 1 || !bytes.Equal(merkleRoots[0], validRoot) {
+		t.Errorf("expected only the valid block, got %d blocks", len(blocks))
+	}
+
+	if err := invalidBlocks[string(invalidChunks[0].Authenticator.MerkleRoot)]; err != ErrBlockNotValid {
+		t.Errorf("expected the block that does not extend the chain to be invalid, got %v", err)
+	}
+
+	// a leader can not propose a block on behalf of another validator, the beacon proof of a block is bound to its issuer
+	receiver = newBlockReceiver(leaders, 1, config.BlockChunkCount, config.RequiredChunkCount(), previousBlockHash, notCommitted)
+	for i := range extraChunks {
+		extraChunks[i].Issuer = []byte("leader 2")
+		if err := receiver.AddChunk(extraChunks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, invalidBlocks := receiver.GetBlocks(); len(invalidBlocks) != 1 {
+		t.Errorf("expected the block of another issuer to be invalid")
+	}
+}
+
+func TestBlockReceiverDuplicateChunks(t *testing.T) {
+
+	config := registery.NodeConfig{LeaderCount: 1, BlockChunkCount: 6, DataChunkCount: 4}
+	previousBlockHash := []byte("previous block hash")
+
+	chunks, merkleRoot := common.ChunkBlockWithCounts(common.NewBlock([]byte("leader 1"), previousBlockHash, 3, nil), config.RequiredChunkCount(), config.BlockChunkCount)
+	setIssuer(chunks, "leader 1")
+
+	receiver := newBlockReceiver(leaderSet{"leader 1": {}}, config.LeaderCount, config.BlockChunkCount, config.RequiredChunkCount(), previousBlockHash, notCommitted)
+
+	// the re-delivered chunks do not count towards the required chunks
+	for _, c := range []common.BlockChunk{chunks[0], chunks[0], chunks[1], chunks[1], chunks[5]} {
+		if err := receiver.AddChunk(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if receiver.ReceivedAll() {
+		t.Fatalf("expected 3 distinct chunks to be not enough to reconstruct the block")
+	}
+
+	if err := receiver.AddChunk(chunks[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	if !receiver.ReceivedAll() {
+		t.Fatalf("expected to receive the block")
+	}
+
+	blocks, merkleRoots, invalidBlocks := receiver.GetBlocks()
+	if len(blocks) != 1 || !bytes.Equal(merkleRoots[0], merkleRoot) || len(invalidBlocks) != 0 {
+		t.Errorf("expected the block to be reconstructed, invalid blocks %v", invalidBlocks)
+	}
+}
+
+func setIssuer(chunks []common.BlockChunk, issuer string) {
+	for i := range chunks {
+		chunks[i].Issuer = []byte(issuer)
+	}
+}
+
+func TestBlockReceiverCommittedTransactions(t *testing.T) {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := registery.NodeConfig{LeaderCount: 1, BlockChunkCount: 4, DataChunkCount: 4}
+	previousBlockHash := []byte("previous block hash")
+
+	committedTx := common.NewTransaction(privateKey, 0, 1, []byte{0})
+	newTx := common.NewTransaction(privateKey, 1, 1, []byte{1})
+	committed := func(txHash []byte) bool { return bytes.Equal(txHash, committedTx.Hash()) }
+
+	for _, transactions := range [][]common.Transaction{{newTx}, {newTx, committedTx}} {
+		chunks, _ := common.ChunkBlockWithCounts(common.NewBlock([]byte("leader 1"), previousBlockHash, 3, transactions), config.RequiredChunkCount(), config.BlockChunkCount)
+		setIssuer(chunks, "leader 1")
+
+		receiver := newBlockReceiver(leaderSet{"leader 1": {}}, config.LeaderCount, config.BlockChunkCount, config.RequiredChunkCount(), previousBlockHash, committed)
+		for _, c := range chunks {
+			if err := receiver.AddChunk(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the block that replays a committed transaction is not valid
+		blocks, _, invalidBlocks := receiver.GetBlocks()
+		if replayed := len(transactions) == 2; replayed != (len(invalidBlocks) == 1) || replayed == (len(blocks) == 1) {
+			t.Errorf("unexpected validity of the block with %d transactions, invalid blocks %v", len(transactions), invalidBlocks)
+		}
+	}
+}
+
+func notCommitted(txHash []byte) bool {
+	return false
+}
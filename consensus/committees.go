@@ -0,0 +1,251 @@
+package consensus
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/network"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+// interval between the requests of the reference rounds while the seed of an epoch is not known
+const referencePollInterval = time.Second
+
+// Committees assigns the validators to the committees of each epoch, each committee runs its own shard chain.
+// The seeds of the epochs are derived by the beacon of the chain of the reference committee.
+// The members of the reference committee add the rounds they decide, the other nodes request the reference rounds
+// from the members of the reference committee and verify them. It is safe for concurrent use.
+type Committees struct {
+	config     registery.NodeConfig
+	validators *common.ValidatorSet
+
+	// connect returns the peers of the shard 0 to request the reference rounds from, members are the indexes of the validators
+	connect func(members []int) network.PeerSet
+
+	mutex sync.Mutex
+
+	// beacon of the reference chain, and the hash of its last round
+	beacon        *common.Beacon
+	referenceHash []byte
+
+	// committees keyed by epoch
+	epochs map[int]*epochCommittees
+
+	// peers of the reference committee of an epoch
+	referencePeers      network.PeerSet
+	referencePeersEpoch int
+}
+
+// epochCommittees keeps the members of the committees of an epoch
+type epochCommittees struct {
+	members    [][]int
+	validators []*common.ValidatorSet
+}
+
+// NewCommittees creates the committees of the config.
+// The reference rounds are requested from the peers returned by connect, the peer sets are closed when they are not needed anymore.
+func NewCommittees(config registery.NodeConfig, validators *common.ValidatorSet, connect func(members []int) network.PeerSet) *Committees {
+
+	if config.CommitteeCount < 1 || config.CommitteeCount > validators.Size() {
+		panic(fmt.Errorf("illegal committee count %d of %d validators", config.CommitteeCount, validators.Size()))
+	}
+
+	return &Committees{
+		config:        config,
+		validators:    validators,
+		connect:       connect,
+		beacon:        common.NewBeacon(config.EpochSeed, config.EpochLength),
+		referenceHash: common.HashBlocks(common.GenesisBlocks()),
+		epochs:        make(map[int]*epochCommittees),
+	}
+}
+
+// Epoch returns the epoch of the round
+func (c *Committees) Epoch(round int) int {
+	return c.beacon.Epoch(round)
+}
+
+// Members returns the indexes of the validators of each committee in the epoch of the round.
+// It blocks until the seed of the epoch is known.
+func (c *Committees) Members(round int) [][]int {
+	return c.committees(round).members
+}
+
+// ShardOf returns the committee of the validator in the epoch of the round, it returns -1 if the public key is not a validator
+func (c *Committees) ShardOf(publicKey []byte, round int) int {
+
+	index := c.validators.Index(publicKey)
+	for shard, members := range c.Members(round) {
+		for _, member := range members {
+			if member == index {
+				return shard
+			}
+		}
+	}
+
+	return -1
+}
+
+// Committee returns the committee of a shard, it provides the validators of the shard chain in each round
+func (c *Committees) Committee(shard int) Committee {
+	return shardCommittee{committees: c, shard: shard}
+}
+
+// AddReferenceRound adds a round of the reference chain that is decided or verified by the node.
+// The rounds that are already added are ignored, the other rounds must be added in order.
+func (c *Committees) AddReferenceRound(decidedRound common.DecidedRound) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if decidedRound.Round <= c.beacon.LastRound() {
+		return nil
+	}
+
+	if err := c.beacon.AddRound(decidedRound); err != nil {
+		return err
+	}
+	c.referenceHash = decidedRound.NextBlockHash(c.referenceHash)
+
+	return nil
+}
+
+// ReferenceBeacon returns the beacon of the reference chain
+func (c *Committees) ReferenceBeacon() *common.Beacon {
+	return c.beacon
+}
+
+// committees returns the committees of the epoch of the round, the reference rounds are requested until the seed of the epoch is known
+func (c *Committees) committees(round int) *epochCommittees {
+
+	for {
+		if committees, ok := c.knownCommittees(round); ok {
+			return committees
+		}
+
+		if !c.followReference() {
+			time.Sleep(referencePollInterval)
+		}
+	}
+}
+
+// knownCommittees returns the committees of the epoch of the round, it returns false if the seed of the epoch is not known yet
+func (c *Committees) knownCommittees(round int) (*epochCommittees, bool) {
+
+	epoch := c.beacon.Epoch(round)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	committees, ok := c.epochs[epoch]
+	if !ok {
+		seed, known := c.beacon.Seed(round)
+		if !known {
+			return nil, false
+		}
+
+		committees = c.assign(seed, epoch)
+		c.epochs[epoch] = committees
+	}
+
+	return committees, true
+}
+
+// assign assigns the validators to the committees of an epoch
+func (c *Committees) assign(seed []byte, epoch int) *epochCommittees {
+
+	committees := &epochCommittees{members: common.AssignCommittees(c.validators.Size(), seed, epoch, c.config.CommitteeCount)}
+	for _, members := range committees.members {
+		committees.validators = append(committees.validators, c.validators.Subset(members))
+	}
+
+	log.Printf("validators are assigned to %d committees in epoch %d\n", len(committees.members), epoch)
+
+	return committees
+}
+
+// followReference requests the reference rounds after the last added round from the reference committee of its epoch,
+// and adds the verified rounds. It returns false if no round is added.
+func (c *Committees) followReference() bool {
+
+	fromRound := c.beacon.LastRound() + 1
+	peerSet := c.referencePeersOf(fromRound)
+
+	added := 0
+	_, err := peerSet.RequestSync(common.SyncRequest{FromRound: fromRound}, func(response common.SyncResponse) error {
+
+		for _, decidedRound := range response.Rounds {
+			round := c.beacon.LastRound() + 1
+			if decidedRound.Round < round {
+				continue
+			}
+
+			// the rounds of the next epoch are certified by the reference committee of the next epoch
+			validators := c.committees(round).validators[common.ReferenceCommittee]
+
+			c.mutex.Lock()
+			previousHash := c.referenceHash
+			c.mutex.Unlock()
+
+			if err := VerifyDecidedRound(decidedRound, round, previousHash, c.config, validators); err != nil {
+				return err
+			}
+
+			if err := c.AddReferenceRound(decidedRound); err != nil {
+				return err
+			}
+			added++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("following the reference chain stopped after round %d: %s\n", c.beacon.LastRound(), err)
+	}
+
+	return added > 0
+}
+
+// referencePeersOf returns the peers of the reference committee in the epoch of the round, the peers of the previous epochs are closed
+func (c *Committees) referencePeersOf(round int) network.PeerSet {
+
+	epoch := c.beacon.Epoch(round)
+	members := c.committees(round).members[common.ReferenceCommittee]
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.referencePeersEpoch != epoch {
+		c.referencePeers.Close()
+		c.referencePeers = c.connect(members)
+		c.referencePeersEpoch = epoch
+	}
+
+	return c.referencePeers
+}
+
+// shardCommittee provides the validators of a shard chain
+type shardCommittee struct {
+	committees *Committees
+	shard      int
+}
+
+// Validators returns the members of the committee in the epoch of the round
+func (s shardCommittee) Validators(round int) *common.ValidatorSet {
+	return s.committees.committees(round).validators[s.shard]
+}
+
+// KnownValidators returns the members of the committee in the epoch of the round, it returns false if the seed of the epoch is not known yet
+func (s shardCommittee) KnownValidators(round int) (*common.ValidatorSet, bool) {
+
+	committees, ok := s.committees.knownCommittees(round)
+	if !ok {
+		return nil, false
+	}
+
+	return committees.validators[s.shard], true
+}
@@ -0,0 +1,72 @@
+package consensus
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/network"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+func TestCommittees(t *testing.T) {
+
+	publicKeys, _ := generateValidatorKeys(6)
+	validators, err := common.NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed := []byte{1, 2, 3}
+	config := registery.NodeConfig{EpochSeed: seed, EpochLength: 2, CommitteeCount: 2}
+
+	// the reference rounds are added by the test, they are not requested from peers
+	committees := NewCommittees(config, validators, func(members []int) network.PeerSet {
+		t.Fatalf("unexpected request of the reference rounds")
+		return network.PeerSet{}
+	})
+
+	members := committees.Members(1)
+	if !reflect.DeepEqual(members, common.AssignCommittees(6, seed, 1, 2)) {
+		t.Fatalf("unexpected committees of the first epoch %v", members)
+	}
+
+	for shard := range members {
+		committee := committees.Committee(shard).Validators(2)
+		if committee.Size() != 3 {
+			t.Fatalf("expected 3 members in committee %d, got %d", shard, committee.Size())
+		}
+
+		for _, member := range members[shard] {
+			if committees.ShardOf(publicKeys[member], 1) != shard || !committee.Contains(publicKeys[member]) {
+				t.Errorf("validator %d is not a member of committee %d", member, shard)
+			}
+		}
+	}
+
+	// the committees of the second epoch are not known before the reference rounds of the first epoch
+	if _, ok := committees.Committee(1).KnownValidators(3); ok {
+		t.Errorf("expected the committees of the second epoch to be unknown")
+	}
+
+	// the reference rounds of the first epoch derive the seed of the second epoch
+	for round := 1; round <= 2; round++ {
+		if err := committees.AddReferenceRound(common.DecidedRound{Round: round}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the rounds that are already added are ignored
+	if err := committees.AddReferenceRound(common.DecidedRound{Round: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := common.AssignCommittees(6, common.NextEpochSeed(seed, 1, nil), 2, 2)
+	if members := committees.Members(3); !reflect.DeepEqual(members, expected) {
+		t.Errorf("unexpected committees of the second epoch %v, expected %v", members, expected)
+	}
+
+	if committee, ok := committees.Committee(1).KnownValidators(3); !ok || !committee.Contains(publicKeys[expected[1][0]]) || committee.Contains(publicKeys[expected[0][0]]) {
+		t.Errorf("unexpected committee 1 of the second epoch")
+	}
+}
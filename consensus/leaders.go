@@ -0,0 +1,190 @@
+package consensus
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+// ErrNotLeader is returned if a proposal or a chunk is issued by a validator that is not a leader of the round
+var ErrNotLeader = errors.New("issuer is not an elected leader of the round")
+
+// the leader election methods of the config
+const (
+	// the validators are permuted using the round as the source of randomness, the leaders are known in advance
+	permutationElection = "permutation"
+
+	// each validator is a leader if its VRF output for the round is below a threshold, the leaders are known once they propose
+	vrfElection = "vrf"
+)
+
+// leaderSet keeps the public keys of the leaders of a round
+type leaderSet map[string]struct{}
+
+// contains returns true if the public key belongs to a leader
+func (ls leaderSet) contains(publicKey []byte) bool {
+	_, ok := ls[string(publicKey)]
+	return ok
+}
+
+// sortition elects the leaders of a round
+type sortition struct {
+	validators  *common.ValidatorSet
+	leaderCount int
+
+	// input of the VRF, it is nil if the leaders are elected by permutation
+	alpha []byte
+
+	// leaders elected by permutation
+	leaders leaderSet
+}
+
+// newSortition creates the sortition of a round using the election method of the config, seed is the seed of the epoch of the round
+func newSortition(config registery.NodeConfig, validators *common.ValidatorSet, seed []byte, round int) *sortition {
+
+	s := &sortition{validators: validators, leaderCount: config.LeaderCount}
+	if s.leaderCount > validators.Size() {
+		s.leaderCount = validators.Size()
+	}
+
+	switch config.LeaderElection {
+	case "", permutationElection:
+		s.leaders = electLeaders(validators, seed, round, s.leaderCount)
+	case vrfElection:
+		s.alpha = leaderElectionInput(seed, round)
+	default:
+		panic(fmt.Errorf("unknown leader election %q", config.LeaderElection))
+	}
+
+	return s
+}
+
+// isVRF returns true if the leaders are elected by VRF
+func (s *sortition) isVRF() bool {
+	return s.alpha != nil
+}
+
+// expectedLeaderCount returns the number of the leaders, it is the expected number of the leaders if the leaders are elected by VRF
+func (s *sortition) expectedLeaderCount() int {
+	return s.leaderCount
+}
+
+// prove returns the proof that the key is a leader of the round, and false if the key is not a leader.
+// The proof is empty if the leaders are elected by permutation.
+func (s *sortition) prove(privateKey ed25519.PrivateKey) ([]byte, bool) {
+
+	if !s.isVRF() {
+		return nil, s.leaders.contains(privateKey.Public().(ed25519.PublicKey))
+	}
+
+	output, proof := common.VRFProve(privateKey, s.alpha)
+
+	return proof, s.isBelowThreshold(output)
+}
+
+// verify checks that the issuer is a leader of the round using the proof of the issuer
+func (s *sortition) verify(issuer []byte, proof []byte) error {
+
+	if !s.isVRF() {
+		if !s.leaders.contains(issuer) {
+			return ErrNotLeader
+		}
+		return nil
+	}
+
+	output, err := common.VRFVerify(issuer, s.alpha, proof)
+	if err != nil {
+		return err
+	}
+
+	if !s.isBelowThreshold(output) {
+		return ErrNotLeader
+	}
+
+	return nil
+}
+
+// isBelowThreshold returns true if the VRF output elects a leader, the probability is leaderCount/validatorCount
+func (s *sortition) isBelowThreshold(output []byte) bool {
+
+	if s.leaderCount >= s.validators.Size() {
+		return true
+	}
+
+	// threshold = 2^64 * leaderCount / validatorCount
+	threshold, _ := bits.Div64(uint64(s.leaderCount), 0, uint64(s.validators.Size()))
+
+	return binary.BigEndian.Uint64(output[:8]) < threshold
+}
+
+// leaderElectionInput returns the VRF input of the leader election of a round
+func leaderElectionInput(epochSeed []byte, round int) []byte {
+
+	alpha := append([]byte("rapidchain leader election"), epochSeed...)
+	var encodedRound [8]byte
+	binary.BigEndian.PutUint64(encodedRound[:], uint64(round))
+
+	return append(alpha, encodedRound[:]...)
+}
+
+// electLeaders elects leaderCount leaders of a round from the validators.
+// All the nodes elect the same leaders, the validators are permuted using the seed of the epoch and the round as the source of randomness.
+func electLeaders(validators *common.ValidatorSet, seed []byte, round int, leaderCount int) leaderSet {
+
+	if leaderCount > validators.Size() {
+		leaderCount = validators.Size()
+	}
+
+	leaders := make(leaderSet)
+	digest := sha256.Sum256(leaderElectionInput(seed, round))
+	source := rand.NewSource(int64(binary.BigEndian.Uint64(digest[:8])))
+	permutation := rand.New(source).Perm(validators.Size())
+	for _, index := range permutation[:leaderCount] {
+		leaders[string(validators.PublicKey(index))] = struct{}{}
+	}
+
+	return leaders
+}
+
+// sortition returns the sortition of the round using the seed of its epoch
+func (c *RapidchainConsensus) sortition(round int) *sortition {
+
+	seed, ok := c.beacon.Seed(round)
+	if !ok {
+		panic(fmt.Errorf("seed of round %d is not known, the beacon is at round %d", round, c.beacon.LastRound()))
+	}
+
+	return newSortition(c.nodeConfig, c.validatorsOf(round), seed, round)
+}
+
+// IsLeader returns true if the node is elected as a leader of the round
+func (c *RapidchainConsensus) IsLeader(round int) bool {
+	_, isLeader := c.sortition(round).prove(c.privateKey)
+	return isLeader
+}
+
+// Leaders returns the indexes of the leaders of the round in the validators of the round.
+// It returns nil if the leaders are elected by VRF, they are not known before they propose.
+func (c *RapidchainConsensus) Leaders(round int) []int {
+
+	s := c.sortition(round)
+	if s.isVRF() {
+		return nil
+	}
+
+	var indexes []int
+	for i := 0; i < s.validators.Size(); i++ {
+		if s.leaders.contains(s.validators.PublicKey(i)) {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return indexes
+}
@@ -0,0 +1,114 @@
+package consensus
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/network"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+func TestProposeVotesOfLeaders(t *testing.T) {
+
+	publicKeys, privateKeys := generateValidatorKeys(8)
+	validators, err := common.NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := registery.NodeConfig{LeaderCount: 2, LeaderElection: "permutation"}
+
+	// all the nodes elect the same leaders, and the election does not depend on the previous rounds
+	seed := []byte{1, 2, 3}
+	leaders := newSortition(config, validators, seed, 4)
+	if len(leaders.leaders) != 2 {
+		t.Fatalf("expected 2 leaders, got %d", len(leaders.leaders))
+	}
+	for key := range electLeaders(validators, seed, 4, 2) {
+		if !leaders.leaders.contains([]byte(key)) {
+			t.Fatalf("expected the same leaders in round 4")
+		}
+	}
+
+	demux := common.NewDemultiplexer(0)
+	for i := range publicKeys {
+		if leaders.leaders.contains(publicKeys[i]) {
+			continue
+		}
+
+		vote := common.Vote{Issuer: publicKeys[i], Tag: common.ProposeTag, Round: 4, BlockHash: [][]byte{{byte(i)}}}
+		vote.Signature = signHash(vote.SigningHash(), privateKeys[i])
+		if err := demux.Enque(vote); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the validators that are not leaders can not take the slots of the leaders
+	proposeVotes := receiveMultipleProposeVotes(4, demux, &network.PeerSet{}, leaders, time.After(100*time.Millisecond))
+	if len(proposeVotes) != 0 {
+		t.Errorf("expected no propose votes, got %d", len(proposeVotes))
+	}
+
+	if rejected := demux.Rejections().ByIssuer(); len(rejected) != 6 {
+		t.Errorf("expected the propose votes of 6 validators to be rejected, got %d", len(rejected))
+	}
+}
+
+func TestVRFSortition(t *testing.T) {
+
+	publicKeys, privateKeys := generateValidatorKeys(8)
+	validators, err := common.NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := registery.NodeConfig{LeaderCount: 4, LeaderElection: "vrf"}
+	seed := []byte{1, 2, 3}
+	s := newSortition(config, validators, seed, 5)
+
+	leaderCount := 0
+	for i := range privateKeys {
+		proof, isLeader := s.prove(privateKeys[i])
+
+		err := s.verify(publicKeys[i], proof)
+		if isLeader != (err == nil) {
+			t.Fatalf("validator %d: the verification does not match the proof, leader %t, error %v", i, isLeader, err)
+		}
+
+		if isLeader {
+			leaderCount++
+		} else if err != ErrNotLeader {
+			t.Errorf("validator %d: expected ErrNotLeader, got %v", i, err)
+		}
+
+		// the proof of a validator can not be used by another validator, in another round, or in another epoch
+		if err := s.verify(publicKeys[(i+1)%len(publicKeys)], proof); err != common.ErrInvalidVRFProof {
+			t.Errorf("validator %d: expected ErrInvalidVRFProof for another validator, got %v", i, err)
+		}
+
+		if err := newSortition(config, validators, seed, 6).verify(publicKeys[i], proof); err != common.ErrInvalidVRFProof {
+			t.Errorf("validator %d: expected ErrInvalidVRFProof in another round, got %v", i, err)
+		}
+
+		if err := newSortition(config, validators, []byte{4, 5, 6}, 5).verify(publicKeys[i], proof); err != common.ErrInvalidVRFProof {
+			t.Errorf("validator %d: expected ErrInvalidVRFProof with another seed, got %v", i, err)
+		}
+	}
+
+	t.Logf("%d of %d validators are leaders of round 5", leaderCount, len(publicKeys))
+}
+
+func generateValidatorKeys(count int) ([][]byte, []ed25519.PrivateKey) {
+
+	var publicKeys [][]byte
+	var privateKeys []ed25519.PrivateKey
+	for i := 0; i < count; i++ {
+		publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+		publicKeys = append(publicKeys, publicKey)
+		privateKeys = append(privateKeys, privateKey)
+	}
+
+	return publicKeys, privateKeys
+}
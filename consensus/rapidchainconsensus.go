@@ -1,9 +1,9 @@
 package consensus
 
 import (
-	"bytes"
 	"crypto/ed25519"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -15,9 +15,21 @@ import (
 // BlockNotValid is returned if the block can not pass vaslidity test
 var ErrBlockNotValid = errors.New("received block is not valid")
 
-// BlockNotValid is returned if the block can not pass vaslidity test
+// ErrDecidedOnDifferentBlock is returned if a block is announced without being proposed by its issuer, possibly the leader equivocate
 var ErrDecidedOnDifferentBlock = errors.New("decided on a different block, possibly the leader equivocate")
 
+// ErrNotProposed is returned if a chunk is received for a merkle root that its issuer did not propose
+var ErrNotProposed = errors.New("merkle root of the chunk is not proposed")
+
+// Committee provides the validators of the chain of a consensus in each round
+type Committee interface {
+	// Validators returns the validators of the round, it blocks until they are known
+	Validators(round int) *common.ValidatorSet
+
+	// KnownValidators returns the validators of the round, it returns false if they are not known yet
+	KnownValidators(round int) (*common.ValidatorSet, bool)
+}
+
 type RapidchainConsensus struct {
 	demultiplexer *common.Demux
 	nodeConfig    registery.NodeConfig
@@ -26,32 +38,54 @@ type RapidchainConsensus struct {
 	publicKey  ed25519.PublicKey
 	privateKey ed25519.PrivateKey
 
+	// public keys of the registered nodes, the decided rounds received from peers must be certified by them
+	validators *common.ValidatorSet
+
+	// validators of the shard chain in each round, all the validators run the chain if it is nil
+	committee Committee
+
+	// randomness beacon, the leaders of a round are elected using the seed of its epoch
+	beacon *common.Beacon
+
+	// returns true if the transaction is included in a decided round, the blocks that include it again are not valid
+	committed func(txHash []byte) bool
+
 	statLogger *common.StatLogger
 }
 
-func NewRapidchain(demux *common.Demux, config registery.NodeConfig, peerSet network.PeerSet, statLogger *common.StatLogger) *RapidchainConsensus {
+// NewRapidchain creates the consensus of a node. The public key of the node must be registered, and be in the validator set.
+func NewRapidchain(demux *common.Demux, config registery.NodeConfig, peerSet network.PeerSet, privateKey ed25519.PrivateKey, validators *common.ValidatorSet, statLogger *common.StatLogger) *RapidchainConsensus {
+
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	if !validators.Contains(publicKey) {
+		panic(fmt.Errorf("public key of the node is not in the validator set"))
+	}
 
-	keyPub, keyPrive, err := ed25519.GenerateKey(nil)
-	if err != nil {
+	if err := config.Validate(); err != nil {
 		panic(err)
 	}
 
+	// the number of the leaders elected by VRF is not known, the propose phase ends with its timeout
+	if config.LeaderElection == vrfElection && config.PhaseTimeout(config.ProposeTimeout) == 0 {
+		panic(fmt.Errorf("vrf leader election requires a propose timeout"))
+	}
+
 	rapidchain := &RapidchainConsensus{
 		demultiplexer: demux,
 		nodeConfig:    config,
 		peerSet:       peerSet,
-		publicKey:     keyPub,
-		privateKey:    keyPrive,
+		publicKey:     publicKey,
+		privateKey:    privateKey,
+		validators:    validators,
+		beacon:        common.NewBeacon(config.EpochSeed, config.EpochLength),
+		committed:     func(txHash []byte) bool { return false },
 		statLogger:    statLogger,
 	}
 
 	return rapidchain
 }
 
-func (c *RapidchainConsensus) Propose(round int, block common.Block, previousBlockHash []byte) []common.Block {
-
-	// emulates the cost of block creation
-	c.emulateCost(len(block.Payload))
+func (c *RapidchainConsensus) Propose(round int, block common.Block, previousBlockHash []byte) common.DecidedRound {
 
 	// starts a new epoch
 	c.statLogger.NewRound(round)
@@ -59,27 +93,48 @@ func (c *RapidchainConsensus) Propose(round int, block common.Block, previousBlo
 	// sets the round for demultiplexer
 	c.demultiplexer.UpdateRound(round)
 
+	// the proof of the election is attached to the propose vote and the chunks
+	leaderProof, isLeader := c.sortition(round).prove(c.privateKey)
+	if !isLeader {
+		panic(fmt.Errorf("the node is not a leader of round %d", round))
+	}
+
+	// the block contributes to the seed of the next epoch
+	block.BeaconProof = c.beacon.Prove(c.privateKey, round)
+
 	// chunks the block
-	chunks, merkleRoot := common.ChunkBlock(block, c.nodeConfig.BlockChunkCount)
+	chunks, merkleRoot := common.ChunkBlockWithCounts(block, c.nodeConfig.RequiredChunkCount(), c.nodeConfig.BlockChunkCount)
 	//log.Printf("proposing block %x\n", encodeBase64(merkleRoot[:15]))
 	log.Printf("the block chunked into %d chunks \n", len(chunks))
 
-	// signs chunks
 	for i := range chunks {
 		chunks[i].Issuer = c.publicKey
-		chunks[i].Signature = signHash(chunks[i].Hash(), c.privateKey)
+		chunks[i].LeaderProof = leaderProof
 	}
 
+	// signs the merkle root once, chunks are authenticated by their merkle paths
+	announcement := common.BlockAnnouncement{
+		Issuer:         c.publicKey,
+		Round:          round,
+		MerkleRoot:     merkleRoot,
+		ChunkCount:     len(chunks),
+		DataChunkCount: chunks[0].DataChunkCount,
+	}
+	announcement.Signature = signHash(announcement.Hash(), c.privateKey)
+	c.peerSet.ForwardBlockAnnouncement(announcement)
+
 	// disseminate chunks over different nodes
 	c.peerSet.DissaminateChunks(chunks)
 
 	// vote propose
-	c.vote(common.ProposeTag, round, [][]byte{merkleRoot}, nil)
+	proposeVote := common.Vote{Issuer: c.publicKey, Tag: common.ProposeTag, Round: round, BlockHash: [][]byte{merkleRoot}, LeaderProof: leaderProof}
+	proposeVote.Signature = signHash(proposeVote.SigningHash(), c.privateKey)
+	c.peerSet.ForwardVote(proposeVote)
 
 	return c.commonPath(round, previousBlockHash)
 }
 
-func (c *RapidchainConsensus) Decide(round int, previousBlockHash []byte) []common.Block {
+func (c *RapidchainConsensus) Decide(round int, previousBlockHash []byte) common.DecidedRound {
 
 	// starts a new epoch
 	c.statLogger.NewRound(round)
@@ -90,56 +145,123 @@ func (c *RapidchainConsensus) Decide(round int, previousBlockHash []byte) []comm
 	return c.commonPath(round, previousBlockHash)
 }
 
-func (c *RapidchainConsensus) commonPath(round int, previousBlockHash []byte) []common.Block {
+func (c *RapidchainConsensus) commonPath(round int, previousBlockHash []byte) common.DecidedRound {
+
+	validators := c.validatorsOf(round)
+
+	// only the elected leaders of the round can propose blocks
+	leaders := c.sortition(round)
 
 	// PROPOSE EVENT
 	startTime := time.Now()
-	proposeVotes := receiveMultipleProposeVotes(round, c.demultiplexer, &c.peerSet, c.nodeConfig.LeaderCount)
+	proposeTimeout := phaseTimeout(c.nodeConfig.PhaseTimeout(c.nodeConfig.ProposeTimeout))
+	proposeVotes := receiveMultipleProposeVotes(round, c.demultiplexer, &c.peerSet, leaders, proposeTimeout)
 	c.statLogger.LogPropose(time.Since(startTime).Milliseconds())
 
 	// BLOCK RECEIVE EVENT
 	//log.Printf("waiting for block...\n")
 	startTime = time.Now()
-	blocks, merkleRoots := receiveMultipleBlocks(round, c.demultiplexer, c.nodeConfig.BlockChunkCount, &c.peerSet, c.nodeConfig.LeaderCount)
+	blockTimeout := phaseTimeout(c.nodeConfig.PhaseTimeout(c.nodeConfig.BlockTimeout))
+	blocks, merkleRoots := receiveMultipleBlocks(round, c.demultiplexer, c.nodeConfig.BlockChunkCount, c.nodeConfig.RequiredChunkCount(), &c.peerSet, proposeVotes, previousBlockHash, c.committed, blockTimeout)
 
 	c.statLogger.LogBlockReceive(time.Since(startTime).Milliseconds())
 
-	for _, block := range blocks {
-		valid := validateBlock(block, previousBlockHash)
-		if !valid {
-			panic(ErrBlockNotValid)
-		}
+	// vote echo for the valid blocks that made it through, the node votes to time out the round if there are none
+	if len(blocks) > 0 {
+		c.vote(common.EchoTag, round, merkleRoots, nil)
 	}
 
-	for i := range proposeVotes {
-		if !bytes.Equal(proposeVotes[i].BlockHash[0], merkleRoots[i]) {
-			panic(ErrDecidedOnDifferentBlock)
+	// ECHO AND ACCEPT EVENTS
+	minVoteCount := c.nodeConfig.QuorumSize(validators.Size())
+	startTime = time.Now()
+	var acceptTime time.Time
+	echoTimeout := phaseTimeout(c.nodeConfig.PhaseTimeout(c.nodeConfig.EchoTimeout))
+	decidedRound := receiveDecision(round, c.demultiplexer, validators, minVoteCount, blocks, merkleRoots, &c.peerSet, echoTimeout, func(tag byte, merkleRoots [][]byte, proof *common.AcceptProof) {
+		if tag == common.AcceptTag {
+			c.statLogger.LogEcho(time.Since(startTime).Milliseconds())
+			acceptTime = time.Now()
 		}
+		c.vote(tag, round, merkleRoots, proof)
+	})
+
+	if decidedRound.IsEmpty() {
+		log.Printf("round %d is decided empty by %d timeout votes\n", round, len(decidedRound.TimeoutCertificate.TimeoutVotes))
+	} else if !acceptTime.IsZero() {
+		c.statLogger.LogAccept(time.Since(acceptTime).Milliseconds())
 	}
 
-	// vote echo
-	c.vote(common.EchoTag, round, merkleRoots, nil)
+	// the round is final, the end of the round is the commit latency of the round
+	c.statLogger.LogEndOfRound()
 
-	// ECHO EVENT
-	minVoteCount := (c.nodeConfig.NodeCount / 2) + 1
-	//log.Printf("waiting for %d echoes \n", minVoteCount)
-	startTime = time.Now()
-	echoVotes := receiveEchoVotes(round, c.demultiplexer, minVoteCount, merkleRoots, &c.peerSet)
-	c.statLogger.LogEcho(time.Since(startTime).Milliseconds())
+	c.addToBeacon(decidedRound)
+
+	return decidedRound
+}
 
-	acceptProof := common.AcceptProof{EchoVotes: echoVotes}
-	c.vote(common.AcceptTag, round, merkleRoots, &acceptProof)
+// SetCommittee makes the consensus run the shard chain of a committee, the validators of a round are the members of the committee in that round
+func (c *RapidchainConsensus) SetCommittee(committee Committee) {
+	c.committee = committee
+}
 
-	// ACCEPT EVENT
-	//log.Printf("waiting for %d accept \n", minVoteCount)
-	//startTime = time.Now()
-	//receiveAcceptVotes(round, c.demultiplexer, minVoteCount, merkleRoots, &c.peerSet)
-	//c.statLogger.LogAccept(time.Since(startTime).Milliseconds())
+// SetCommitted sets the lookup of the committed transactions, the received blocks must not include them.
+// The transactions are committed in the order of the decided rounds, the lookup must know the rounds before the current round.
+func (c *RapidchainConsensus) SetCommitted(committed func(txHash []byte) bool) {
+	c.committed = committed
+}
 
-	c.statLogger.LogEndOfRound()
+// SetPeerSet replaces the peers, the peers of a committee change with its members
+func (c *RapidchainConsensus) SetPeerSet(peerSet network.PeerSet) {
+	c.peerSet = peerSet
+}
+
+// validatorsOf returns the validators of the chain in the round
+func (c *RapidchainConsensus) validatorsOf(round int) *common.ValidatorSet {
+
+	if c.committee == nil {
+		return c.validators
+	}
+
+	return c.committee.Validators(round)
+}
+
+// KnownValidatorsOf returns the validators of the chain in the round without blocking, it returns false if they are not known yet
+func (c *RapidchainConsensus) KnownValidatorsOf(round int) (*common.ValidatorSet, bool) {
+
+	if c.committee == nil {
+		return c.validators, true
+	}
+
+	return c.committee.KnownValidators(round)
+}
 
-	// I am returning accept votes but I do not know how to use them!!!!
-	return blocks
+// CheckMessage checks that a message of the chain is issued by a validator of its round, and the echo votes of an accept vote too.
+// A message of a round whose committee is not known yet is checked against all the validators,
+// the committee checks its votes again when they are counted. It can be used with common.Verifier as the Validate function of a demultiplexer kind.
+func (c *RapidchainConsensus) CheckMessage(message common.Message) error {
+
+	validators, ok := c.KnownValidatorsOf(message.MessageRound())
+	if !ok {
+		validators = c.validators
+	}
+
+	return validators.CheckMessage(message)
+}
+
+// Beacon returns the randomness beacon of the chain
+func (c *RapidchainConsensus) Beacon() *common.Beacon {
+	return c.beacon
+}
+
+// addToBeacon adds a decided round to the beacon, and logs the seed of the next epoch at the end of an epoch
+func (c *RapidchainConsensus) addToBeacon(decidedRound common.DecidedRound) {
+
+	if err := c.beacon.AddRound(decidedRound); err != nil {
+		panic(err)
+	}
+
+	if seed, ok := c.beacon.Seed(decidedRound.Round + 1); ok && c.beacon.Epoch(decidedRound.Round+1) != c.beacon.Epoch(decidedRound.Round) {
+		log.Printf("seed of epoch %d is %s\n", c.beacon.Epoch(decidedRound.Round+1), encodeBase64(seed))
+	}
 }
 
 func (c *RapidchainConsensus) vote(tag byte, round int, merkleRoots [][]byte, proof *common.AcceptProof) {
@@ -154,18 +276,7 @@ func (c *RapidchainConsensus) vote(tag byte, round int, merkleRoots [][]byte, pr
 		vote.Proof = *proof
 	}
 
-	vote.Signature = signHash(vote.Hash(), c.privateKey)
+	vote.Signature = signHash(vote.SigningHash(), c.privateKey)
 
 	c.peerSet.ForwardVote(vote)
 }
-
-func (c *RapidchainConsensus) emulateCost(payloadSize int) {
-	//
-	// 0.13 ms unit cost to validate a transaction
-	// to emulate the cost of merkle tree creation, we have add 0.003 to the unit cost.
-	//
-	sleepTime := (float64(0.133) * float64(payloadSize/512))
-	sleepDuration := time.Duration(sleepTime) * time.Millisecond
-	log.Printf("the node will sleep to emulate tx validation, and merkle tree construction %s \n", sleepDuration)
-	time.Sleep(sleepDuration)
-}
@@ -0,0 +1,138 @@
+package consensus
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+// IsBehind returns true if peers sent valid messages of a round after the provided round, in the future round window of the demux.
+// It means that the provided round is already decided by some peers.
+func (c *RapidchainConsensus) IsBehind(round int) bool {
+	return c.demultiplexer.HighestRound() > round
+}
+
+// CatchUp requests the rounds after lastRound from peers, verifies them against the hash chain starting from previousBlockHash,
+// and moves the demultiplexer to the last verified round. It returns the verified rounds in increasing order.
+func (c *RapidchainConsensus) CatchUp(lastRound int, previousBlockHash []byte) []common.DecidedRound {
+
+	var verifiedRounds []common.DecidedRound
+
+	for {
+		request := common.SyncRequest{FromRound: lastRound + 1}
+
+		var acceptedRounds []common.DecidedRound
+		_, err := c.peerSet.RequestSync(request, func(response common.SyncResponse) error {
+
+			acceptedRounds = nil
+			hash := previousBlockHash
+			for i := range response.Rounds {
+				decidedRound := response.Rounds[i]
+				err := VerifyDecidedRound(decidedRound, lastRound+1+i, hash, c.nodeConfig, c.validatorsOf(lastRound+1+i))
+				if err != nil {
+					return err
+				}
+
+				acceptedRounds = append(acceptedRounds, decidedRound)
+				hash = decidedRound.NextBlockHash(hash)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Printf("catch up stopped after round %d: %s\n", lastRound, err)
+		}
+
+		if len(acceptedRounds) == 0 {
+			break
+		}
+
+		verifiedRounds = append(verifiedRounds, acceptedRounds...)
+		for _, acceptedRound := range acceptedRounds {
+			previousBlockHash = acceptedRound.NextBlockHash(previousBlockHash)
+			c.addToBeacon(acceptedRound)
+		}
+		lastRound = acceptedRounds[len(acceptedRounds)-1].Round
+		log.Printf("caught up to round %d\n", lastRound)
+	}
+
+	if len(verifiedRounds) > 0 {
+		c.demultiplexer.UpdateRound(lastRound)
+	}
+
+	return verifiedRounds
+}
+
+// VerifyDecidedRound checks that a decided round extends the chain, its micro blocks match the Merkle roots,
+// it is accepted by a quorum of valid echo votes on the Merkle roots, and it is final by a quorum of valid accept votes.
+// An empty round must have a timeout certificate with a quorum of valid timeout votes.
+// The votes must be issued by the validators of the round, the quorums are counted for them.
+func VerifyDecidedRound(decidedRound common.DecidedRound, round int, previousBlockHash []byte, config registery.NodeConfig, validators *common.ValidatorSet) error {
+
+	if validators == nil {
+		panic(fmt.Errorf("validators of round %d are not provided", round))
+	}
+
+	if decidedRound.Round != round {
+		return fmt.Errorf("expected round %d, received round %d", round, decidedRound.Round)
+	}
+
+	minVoteCount := config.QuorumSize(validators.Size())
+
+	for _, votes := range [][]common.Vote{decidedRound.AcceptProof.EchoVotes, decidedRound.AcceptCertificate.AcceptVotes, decidedRound.TimeoutCertificate.TimeoutVotes} {
+		if err := validators.CheckVotes(votes); err != nil {
+			return err
+		}
+	}
+
+	if decidedRound.IsEmpty() {
+		if len(decidedRound.MerkleRoots) != 0 {
+			return fmt.Errorf("empty round %d has %d merkle roots", round, len(decidedRound.MerkleRoots))
+		}
+
+		return decidedRound.TimeoutCertificate.Verify(round, minVoteCount)
+	}
+
+	if len(decidedRound.Blocks) != len(decidedRound.MerkleRoots) {
+		return fmt.Errorf("round %d has %d blocks and %d merkle roots", round, len(decidedRound.Blocks), len(decidedRound.MerkleRoots))
+	}
+
+	for i, block := range decidedRound.Blocks {
+
+		if block.Round != round {
+			return fmt.Errorf("block %d belongs to round %d", i, block.Round)
+		}
+
+		if !bytes.Equal(block.PrevBlockHash, previousBlockHash) {
+			return fmt.Errorf("block %d does not extend the previous round", i)
+		}
+
+		if _, err := block.ValidateBody(); err != nil {
+			return fmt.Errorf("block %d is not valid: %s", i, err)
+		}
+
+		_, merkleRoot := common.ChunkBlockWithCounts(block, config.RequiredChunkCount(), config.BlockChunkCount)
+		if !bytes.Equal(merkleRoot, decidedRound.MerkleRoots[i]) {
+			return fmt.Errorf("merkle root of block %d does not match", i)
+		}
+
+		if i > 0 && bytes.Compare(decidedRound.MerkleRoots[i-1], decidedRound.MerkleRoots[i]) >= 0 {
+			return fmt.Errorf("merkle roots are not sorted")
+		}
+	}
+
+	echoVotes := decidedRound.AcceptProof.EchoVotes
+	if len(echoVotes) < minVoteCount {
+		return fmt.Errorf("accept proof has %d echo votes, required %d", len(echoVotes), minVoteCount)
+	}
+
+	if err := common.VerifyEchoVotes(echoVotes, round, decidedRound.MerkleRoots); err != nil {
+		return err
+	}
+
+	return decidedRound.AcceptCertificate.Verify(round, decidedRound.MerkleRoots, minVoteCount)
+}
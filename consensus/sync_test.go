@@ -0,0 +1,278 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/network"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+func TestVerifyDecidedRound(t *testing.T) {
+
+	config := registery.NodeConfig{NodeCount: 4, LeaderCount: 1, BlockChunkCount: 8, DataChunkCount: 6}
+	previousBlockHash := []byte("previous block hash")
+
+	_, clientKey, _ := ed25519.GenerateKey(nil)
+	transactions := []common.Transaction{common.NewTransaction(clientKey, 0, 0, []byte("tx"))}
+	block := common.NewBlock([]byte{1}, previousBlockHash, 5, transactions)
+	_, merkleRoot := common.ChunkBlockWithCounts(block, config.RequiredChunkCount(), config.BlockChunkCount)
+
+	var publicKeys [][]byte
+	var privateKeys []ed25519.PrivateKey
+	for i := 0; i < 4; i++ {
+		publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+		publicKeys = append(publicKeys, publicKey)
+		privateKeys = append(privateKeys, privateKey)
+	}
+
+	decidedRound := common.DecidedRound{Round: 5, Blocks: []common.Block{block}, MerkleRoots: [][]byte{merkleRoot}}
+	for i := 0; i < 3; i++ {
+		vote := common.Vote{Issuer: publicKeys[i], Tag: common.EchoTag, Round: 5, BlockHash: [][]byte{merkleRoot}}
+		vote.Signature = signHash(vote.Hash(), privateKeys[i])
+		decidedRound.AcceptProof.EchoVotes = append(decidedRound.AcceptProof.EchoVotes, vote)
+	}
+
+	var acceptVotes []common.Vote
+	for i := 1; i < 4; i++ {
+		vote := common.Vote{Issuer: publicKeys[i], Tag: common.AcceptTag, Round: 5, BlockHash: [][]byte{merkleRoot}, Proof: decidedRound.AcceptProof}
+		vote.Signature = signHash(vote.SigningHash(), privateKeys[i])
+		acceptVotes = append(acceptVotes, vote)
+	}
+	decidedRound.AcceptCertificate = common.NewAcceptCertificate(acceptVotes)
+
+	validators, err := common.NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyDecidedRound(decidedRound, 5, previousBlockHash, config, validators); err != nil {
+		t.Fatal(err)
+	}
+
+	// a single process can not certify a round with the votes of keys that are not registered
+	stranger, _, _ := ed25519.GenerateKey(nil)
+	otherValidators, err := common.NewValidatorSet([][]byte{publicKeys[0], publicKeys[1], publicKeys[2], stranger})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyDecidedRound(decidedRound, 5, previousBlockHash, config, otherValidators); err == nil {
+		t.Errorf("expected an error because an accept vote is not issued by a validator")
+	}
+
+	notFinal := decidedRound
+	notFinal.AcceptCertificate = common.AcceptCertificate{}
+	if err := VerifyDecidedRound(notFinal, 5, previousBlockHash, config, validators); err == nil {
+		t.Errorf("expected an error because there is no accept certificate")
+	}
+
+	if err := VerifyDecidedRound(decidedRound, 5, []byte("another hash"), config, validators); err == nil {
+		t.Errorf("expected an error because the block does not extend the chain")
+	}
+
+	withoutQuorum := decidedRound
+	withoutQuorum.AcceptProof.EchoVotes = decidedRound.AcceptProof.EchoVotes[:2]
+	if err := VerifyDecidedRound(withoutQuorum, 5, previousBlockHash, config, validators); err == nil {
+		t.Errorf("expected an error because there is no quorum of echo votes")
+	}
+
+	duplicateVotes := decidedRound
+	duplicateVotes.AcceptProof.EchoVotes = []common.Vote{decidedRound.AcceptProof.EchoVotes[0], decidedRound.AcceptProof.EchoVotes[0], decidedRound.AcceptProof.EchoVotes[1]}
+	if err := VerifyDecidedRound(duplicateVotes, 5, previousBlockHash, config, validators); err == nil {
+		t.Errorf("expected an error because echo votes have duplicate issuers")
+	}
+
+	tamperedBlock := block
+	tamperedBlock.Payload = common.EncodeTransactions(nil)
+	tamperedRound := decidedRound
+	tamperedRound.Blocks = []common.Block{tamperedBlock}
+	if err := VerifyDecidedRound(tamperedRound, 5, previousBlockHash, config, validators); err == nil {
+		t.Errorf("expected an error because the block body does not match the header")
+	}
+}
+
+func TestVerifyEmptyRound(t *testing.T) {
+
+	config := registery.NodeConfig{NodeCount: 4, LeaderCount: 1, BlockChunkCount: 8}
+	previousBlockHash := []byte("previous block hash")
+
+	publicKeys, privateKeys := generateValidatorKeys(4)
+	validators, err := common.NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emptyRound := common.DecidedRound{Round: 6}
+	for i := 0; i < 3; i++ {
+		vote := common.Vote{Issuer: publicKeys[i], Tag: common.TimeoutTag, Round: 6}
+		vote.Signature = signHash(vote.Hash(), privateKeys[i])
+		emptyRound.TimeoutCertificate.TimeoutVotes = append(emptyRound.TimeoutCertificate.TimeoutVotes, vote)
+	}
+
+	if err := VerifyDecidedRound(emptyRound, 6, previousBlockHash, config, validators); err != nil {
+		t.Fatal(err)
+	}
+
+	// the timeout votes of the validators of another committee do not count for the committee of the round
+	committee, err := common.NewValidatorSet(publicKeys[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDecidedRound(emptyRound, 6, previousBlockHash, config, committee); err == nil {
+		t.Errorf("expected an error because the timeout votes are not issued by the committee")
+	}
+
+	if !bytes.Equal(emptyRound.NextBlockHash(previousBlockHash), previousBlockHash) {
+		t.Errorf("expected an empty round to keep the previous block hash")
+	}
+
+	withoutQuorum := emptyRound
+	withoutQuorum.TimeoutCertificate.TimeoutVotes = emptyRound.TimeoutCertificate.TimeoutVotes[:2]
+	if err := VerifyDecidedRound(withoutQuorum, 6, previousBlockHash, config, validators); err == nil {
+		t.Errorf("expected an error because there is no quorum of timeout votes")
+	}
+
+	anotherRound := emptyRound
+	anotherRound.Round = 7
+	if err := VerifyDecidedRound(anotherRound, 7, previousBlockHash, config, validators); err == nil {
+		t.Errorf("expected an error because the timeout votes belong to another round")
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+
+	config := registery.NodeConfig{NodeCount: 4, LeaderCount: 1, BlockChunkCount: 8, DataChunkCount: 6}
+
+	publicKeys, privateKeys := generateValidatorKeys(4)
+	validators, err := common.NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer decided 10 rounds, the round 4 is empty. The node is behind by more than a sync response
+	var chain memoryChain
+	hash := common.HashBlocks(common.GenesisBlocks())
+	for round := 1; round <= 10; round++ {
+		decidedRound := certifyRound(round, hash, round == 4, config, publicKeys, privateKeys)
+		chain = append(chain, decidedRound)
+		hash = decidedRound.NextBlockHash(hash)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	server := network.NewServer(common.NewDemultiplexer(10), chain)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn)
+		}
+	}()
+
+	peerSet := network.PeerSet{}
+	address := listener.Addr().(*net.TCPAddr)
+	if err := peerSet.AddPeer(address.IP.String(), address.Port); err != nil {
+		t.Fatal(err)
+	}
+	defer peerSet.Close()
+
+	demux := common.NewDemultiplexer(0)
+	c := NewRapidchain(demux, config, peerSet, privateKeys[0], validators, common.NewStatLogger(0))
+
+	// a signed vote of a future round in the window shows that the node is behind
+	vote := common.Vote{Issuer: publicKeys[1], Tag: common.EchoTag, Round: common.DefaultFutureRoundWindow, BlockHash: [][]byte{[]byte("merkle root")}}
+	vote.Signature = signHash(vote.SigningHash(), privateKeys[1])
+	if err := demux.EnqueFrom("peer", vote); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsBehind(0) {
+		t.Fatalf("expected the node to be behind")
+	}
+
+	genesisHash := common.HashBlocks(common.GenesisBlocks())
+	caughtUp := c.CatchUp(0, genesisHash)
+	if len(caughtUp) != len(chain) {
+		t.Fatalf("expected to catch up %d rounds, caught up %d rounds", len(chain), len(caughtUp))
+	}
+
+	hash = genesisHash
+	for i, decidedRound := range caughtUp {
+		if decidedRound.Round != i+1 || (!decidedRound.IsEmpty() && !bytes.Equal(decidedRound.Blocks[0].PrevBlockHash, hash)) {
+			t.Fatalf("round %d does not extend the previous round", decidedRound.Round)
+		}
+		hash = decidedRound.NextBlockHash(hash)
+	}
+
+	if demux.CurrentRound() != 10 || c.IsBehind(10) {
+		t.Errorf("expected the node to be at the tip of the chain, current round is %d", demux.CurrentRound())
+	}
+
+	// a node at the tip does not receive any round
+	if caughtUp := c.CatchUp(10, hash); len(caughtUp) != 0 {
+		t.Errorf("expected no rounds after the tip, received %d rounds", len(caughtUp))
+	}
+
+	// the rounds that do not extend the chain of the node are not accepted
+	if caughtUp := c.CatchUp(0, []byte("another hash")); len(caughtUp) != 0 {
+		t.Errorf("expected the rounds of another chain to be rejected, received %d rounds", len(caughtUp))
+	}
+}
+
+// memoryChain serves the decided rounds starting from round 1
+type memoryChain []common.DecidedRound
+
+func (m memoryChain) LastRound() int {
+	return len(m)
+}
+
+func (m memoryChain) Get(round int) (common.DecidedRound, error) {
+	if round < 1 || round > len(m) {
+		return common.DecidedRound{}, fmt.Errorf("round %d is not decided", round)
+	}
+	return m[round-1], nil
+}
+
+// certifyRound creates a decided round that extends previousBlockHash, it is certified by the first 3 of the 4 validators
+func certifyRound(round int, previousBlockHash []byte, empty bool, config registery.NodeConfig, publicKeys [][]byte, privateKeys []ed25519.PrivateKey) common.DecidedRound {
+
+	if empty {
+		decidedRound := common.DecidedRound{Round: round}
+		for i := 0; i < 3; i++ {
+			vote := common.Vote{Issuer: publicKeys[i], Tag: common.TimeoutTag, Round: round}
+			vote.Signature = signHash(vote.SigningHash(), privateKeys[i])
+			decidedRound.TimeoutCertificate.TimeoutVotes = append(decidedRound.TimeoutCertificate.TimeoutVotes, vote)
+		}
+		return decidedRound
+	}
+
+	block := common.NewBlock(publicKeys[0], previousBlockHash, round, nil)
+	_, merkleRoot := common.ChunkBlockWithCounts(block, config.RequiredChunkCount(), config.BlockChunkCount)
+
+	decidedRound := common.DecidedRound{Round: round, Blocks: []common.Block{block}, MerkleRoots: [][]byte{merkleRoot}}
+	for i := 0; i < 3; i++ {
+		vote := common.Vote{Issuer: publicKeys[i], Tag: common.EchoTag, Round: round, BlockHash: [][]byte{merkleRoot}}
+		vote.Signature = signHash(vote.SigningHash(), privateKeys[i])
+		decidedRound.AcceptProof.EchoVotes = append(decidedRound.AcceptProof.EchoVotes, vote)
+	}
+
+	var acceptVotes []common.Vote
+	for i := 0; i < 3; i++ {
+		vote := common.Vote{Issuer: publicKeys[i], Tag: common.AcceptTag, Round: round, BlockHash: [][]byte{merkleRoot}, Proof: decidedRound.AcceptProof}
+		vote.Signature = signHash(vote.SigningHash(), privateKeys[i])
+		acceptVotes = append(acceptVotes, vote)
+	}
+	decidedRound.AcceptCertificate = common.NewAcceptCertificate(acceptVotes)
+
+	return decidedRound
+}
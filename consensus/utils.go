@@ -3,18 +3,31 @@ package consensus
 import (
 	"bytes"
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"log"
 	"sort"
+	"time"
 
 	"github.com/korkmazkadir/rapidchain/common"
 	"github.com/korkmazkadir/rapidchain/network"
 )
 
+// phaseTimeout returns a channel that receives after the timeout, the channel never receives if the timeout is 0
+func phaseTimeout(timeout time.Duration) <-chan time.Time {
+
+	if timeout == 0 {
+		return nil
+	}
+
+	return time.After(timeout)
+}
+
 // receiveBlock returns block, merkle root, error
 func receiveBlock(round int, demux *common.Demux, chunkCount int, peerSet *network.PeerSet) (common.Block, []byte, error) {
 
-	chunkChan, err := demux.GetVoteBlockChunkChan(round)
+	chunkChan, err := demux.GetChan(round, common.BlockChunkKind)
 	if err != nil {
 		panic(err)
 	}
@@ -22,7 +35,7 @@ func receiveBlock(round int, demux *common.Demux, chunkCount int, peerSet *netwo
 	// check for differet merkle roots and return error
 	var receivedChunks []common.BlockChunk
 	for len(receivedChunks) < chunkCount {
-		c := <-chunkChan
+		c := (<-chunkChan).(common.BlockChunk)
 		receivedChunks = append(receivedChunks, c)
 		peerSet.ForwardChunk(c)
 	}
@@ -37,45 +50,217 @@ func receiveBlock(round int, demux *common.Demux, chunkCount int, peerSet *netwo
 	return block, receivedChunks[0].Authenticator.MerkleRoot, nil
 }
 
-func receiveMultipleBlocks(round int, demux *common.Demux, chunkCount int, peerSet *network.PeerSet, leaderCount int) ([]common.Block, [][]byte) {
+// receiveMultipleBlocks receives the blocks proposed by the propose votes, and returns the valid blocks and their merkle roots.
+// The blocks that are not received until the timeout are not returned.
+// The invalid messages and blocks are rejected, and attributed to their senders and issuers.
+// The chunks must carry the leader proofs of the propose votes of their blocks. The chunks of a merkle root that is not proposed
+// are held until the phase ends, they are rejected only if their issuer did not propose the root after the propose phase either.
+func receiveMultipleBlocks(round int, demux *common.Demux, chunkCount int, requiredChunkCount int, peerSet *network.PeerSet, proposeVotes []common.Vote, previousBlockHash []byte, committed func(txHash []byte) bool, timeout <-chan time.Time) ([]common.Block, [][]byte) {
 
-	chunkChan, err := demux.GetVoteBlockChunkChan(round)
+	chunkChan, err := demux.GetChan(round, common.BlockChunkKind)
 	if err != nil {
 		panic(err)
 	}
 
-	receiver := newBlockReceiver(leaderCount, chunkCount)
-	for !receiver.ReceivedAll() {
-		c := <-chunkChan
-		if !validateChunk(c) {
-			panic("invalid chunk\n")
+	announcementChan, err := demux.GetChan(round, common.BlockAnnouncementKind)
+	if err != nil {
+		panic(err)
+	}
+
+	// propose votes of the proposed merkle roots, their issuers are the leaders
+	proposers := make(map[string]common.Vote)
+	leaders := make(leaderSet)
+	for _, vote := range proposeVotes {
+		proposers[string(vote.BlockHash[0])] = vote
+		leaders[string(vote.Issuer)] = struct{}{}
+	}
+
+	// chunks are kept until the signed announcement of their merkle root is received
+	announcements := make(map[string]common.BlockAnnouncement)
+	pendingChunks := make(map[string][]common.BlockChunk)
+
+	// chunks of the merkle roots without propose votes, the propose vote of an honest leader may arrive after the propose phase
+	unproposedChunks := make(map[string][]common.BlockChunk)
+
+	receiver := newBlockReceiver(leaders, len(proposeVotes), chunkCount, requiredChunkCount, previousBlockHash, committed)
+	addChunk := func(c common.BlockChunk, a common.BlockAnnouncement) {
+		err := validateChunk(c, a)
+		if err == nil {
+			err = receiver.AddChunk(c)
+		}
+
+		if err != nil {
+			demux.Reject(c, err)
+			return
 		}
-		receiver.AddChunk(c)
 		peerSet.ForwardChunk(c)
 	}
 
-	return receiver.GetBlocks()
+receiveLoop:
+	for !receiver.ReceivedAll() {
+		select {
+
+		case <-timeout:
+			log.Printf("block receive phase of round %d timed out\n", round)
+			break receiveLoop
+
+		case m := <-announcementChan:
+			a := m.(common.BlockAnnouncement)
+			key := string(a.MerkleRoot)
+
+			if err := validateBlockAnnouncement(a, round, chunkCount, requiredChunkCount); err != nil {
+				demux.Reject(a, err)
+				continue
+			}
+
+			if !bytes.Equal(proposers[key].Issuer, a.Issuer) {
+				demux.Reject(a, ErrDecidedOnDifferentBlock)
+				continue
+			}
+
+			if _, ok := announcements[key]; ok {
+				continue
+			}
+
+			announcements[key] = a
+			peerSet.ForwardBlockAnnouncement(a)
+
+			for _, c := range pendingChunks[key] {
+				addChunk(c, a)
+			}
+			delete(pendingChunks, key)
+
+		case m := <-chunkChan:
+			c := m.(common.BlockChunk)
+			key := string(c.Authenticator.MerkleRoot)
+
+			proposeVote, ok := proposers[key]
+			if !ok {
+				if len(unproposedChunks[key]) >= chunkCount {
+					demux.Reject(c, ErrNotProposed)
+					continue
+				}
+				unproposedChunks[key] = append(unproposedChunks[key], c)
+				continue
+			}
+
+			// the proof of the propose vote is already verified, the proof of the chunk is compared without verifying it again
+			if !bytes.Equal(c.LeaderProof, proposeVote.LeaderProof) {
+				demux.Reject(c, ErrNotLeader)
+				continue
+			}
+
+			a, ok := announcements[key]
+			if !ok {
+				pendingChunks[key] = append(pendingChunks[key], c)
+				continue
+			}
+
+			addChunk(c, a)
+		}
+	}
+
+	rejectUnproposedChunks(round, demux, unproposedChunks)
+
+	blocks, merkleRoots, invalidBlocks := receiver.GetBlocks()
+
+	// an invalid block is excluded from the round. The reconstructed block is chunked again and checked against the announced merkle root,
+	// so every correct node that reconstructs the block reaches the same verdict on it, whichever chunks it received
+	for key, err := range invalidBlocks {
+		issuer := announcements[key].Issuer
+		demux.Rejections().Count("", issuer, err)
+		log.Printf("rejected block of round %d issued by %s: %s\n", round, common.EncodeIssuer(issuer), err)
+	}
+
+	return blocks, merkleRoots
 }
 
-func receiveMultipleProposeVotes(round int, demux *common.Demux, peerSet *network.PeerSet, leaderCount int) []common.Vote {
+// rejectUnproposedChunks rejects the held chunks whose issuers did not propose their merkle roots.
+// The propose votes received after the propose phase are taken from the queue, the chunks of the late proposals are dropped without a rejection.
+func rejectUnproposedChunks(round int, demux *common.Demux, unproposedChunks map[string][]common.BlockChunk) {
+
+	if len(unproposedChunks) == 0 {
+		return
+	}
 
-	proposeChannel, err := demux.GetVoteChan(round, common.ProposeTag)
+	proposeChannel, err := demux.GetChan(round, common.ProposeVoteKind)
 	if err != nil {
 		panic(err)
 	}
 
+	lateProposals := make(map[string][]byte)
+	for drained := false; !drained; {
+		select {
+		case m := <-proposeChannel:
+			vote := m.(common.Vote)
+			if len(vote.BlockHash) == 1 {
+				lateProposals[string(vote.BlockHash[0])] = vote.Issuer
+			}
+		default:
+			drained = true
+		}
+	}
+
+	for key, chunks := range unproposedChunks {
+		for _, c := range chunks {
+			if issuer, ok := lateProposals[key]; ok && bytes.Equal(issuer, c.Issuer) {
+				continue
+			}
+			demux.Reject(c, ErrNotProposed)
+		}
+
+		if _, ok := lateProposals[key]; ok {
+			log.Printf("dropped %d chunks of round %d, their block is proposed after the propose phase\n", len(chunks), round)
+		}
+	}
+}
+
+// receiveMultipleProposeVotes receives a propose vote from each of the leaders, or the votes received until the timeout.
+// If the leaders are elected by VRF, the number of the leaders is not known, and the votes are received until the timeout.
+// The votes of the validators that are not leaders, the votes that do not propose a single block, and the second votes of the validators are rejected.
+// The leader proof of a validator is verified once, its second votes are rejected before.
+func receiveMultipleProposeVotes(round int, demux *common.Demux, peerSet *network.PeerSet, leaders *sortition, timeout <-chan time.Time) []common.Vote {
+
+	leaderCount := leaders.expectedLeaderCount()
+
+	proposeChannel, err := demux.GetChan(round, common.ProposeVoteKind)
+	if err != nil {
+		panic(err)
+	}
+
+	issuers := make(map[string]bool)
 	var proposeVotes []common.Vote
 	for {
 
-		vote := <-proposeChannel
-		if !validateVote(vote, nil) {
-			panic(fmt.Errorf("invalid propose vote recevied: %+v", vote))
+		var vote common.Vote
+		select {
+		case m := <-proposeChannel:
+			vote = m.(common.Vote)
+		case <-timeout:
+			log.Printf("propose phase of round %d timed out with %d of %d propose votes\n", round, len(proposeVotes), leaderCount)
+			return sortProposeVotes(proposeVotes)
+		}
+
+		if issuers[string(vote.Issuer)] {
+			demux.Reject(vote, fmt.Errorf("validator proposed more than once"))
+			continue
+		}
+		issuers[string(vote.Issuer)] = true
+
+		if err := leaders.verify(vote.Issuer, vote.LeaderProof); err != nil {
+			demux.Reject(vote, err)
+			continue
+		}
+
+		if len(vote.BlockHash) != 1 {
+			demux.Reject(vote, fmt.Errorf("propose vote has %d merkle roots", len(vote.BlockHash)))
+			continue
 		}
 
 		peerSet.ForwardVote(vote)
 
 		proposeVotes = append(proposeVotes, vote)
-		if len(proposeVotes) == leaderCount {
+		if !leaders.isVRF() && len(proposeVotes) == leaderCount {
 			return sortProposeVotes(proposeVotes)
 		}
 
@@ -90,108 +275,225 @@ func sortProposeVotes(votes []common.Vote) []common.Vote {
 	return votes
 }
 
-// I can count number of votes, and I can return error after receiving (f/2)+1 votes
+// receiveDecision runs the echo and the accept phases of a round.
+// The node votes accept for the first set of received blocks that has a quorum of echo votes, and the round is final
+// when a quorum of accept votes is received for a set of received blocks. If the timeout expires before the node votes accept,
+// or if there are no blocks, the node votes timeout instead, and the round is decided empty when a quorum of timeout votes is received.
+// A node never votes both accept and timeout in a round. The blocks are sorted by their merkle roots.
+// A node that voted timeout still decides on a quorum of accept votes. The config requires Byzantine quorums with the timeouts,
+// so a quorum of accept votes and a quorum of timeout votes can not both form in a round, even if Byzantine validators vote both.
+// The votes of each phase are counted once per validator, the duplicate and the conflicting votes of a validator are rejected.
+func receiveDecision(round int, demux *common.Demux, validators *common.ValidatorSet, minVoteCount int, blocks []common.Block, merkleRoots [][]byte, peerSet *network.PeerSet, timeout <-chan time.Time, vote func(tag byte, merkleRoots [][]byte, proof *common.AcceptProof)) common.DecidedRound {
+
+	echoChannel, err := demux.GetChan(round, common.EchoVoteKind)
+	if err != nil {
+		panic(err)
+	}
 
-func receiveEchoVotes(round int, demux *common.Demux, minVoteCount int, merkleRoots [][]byte, peerSet *network.PeerSet) []common.Vote {
+	acceptChannel, err := demux.GetChan(round, common.AcceptVoteKind)
+	if err != nil {
+		panic(err)
+	}
 
-	echoChannel, err := demux.GetVoteChan(round, common.EchoTag)
+	timeoutChannel, err := demux.GetChan(round, common.TimeoutVoteKind)
 	if err != nil {
 		panic(err)
 	}
 
-	var echoVotes []common.Vote
+	receivedBlocks := make(map[string]common.Block)
+	for i := range blocks {
+		receivedBlocks[string(merkleRoots[i])] = blocks[i]
+	}
+
+	// getBlocks returns the blocks of the merkle roots, it returns false if some of the blocks are not received
+	getBlocks := func(merkleRoots [][]byte) ([]common.Block, bool) {
+		var blocks []common.Block
+		for _, merkleRoot := range merkleRoots {
+			block, ok := receivedBlocks[string(merkleRoot)]
+			if !ok {
+				return nil, false
+			}
+			blocks = append(blocks, block)
+		}
+		return blocks, true
+	}
+
+	accepted := false
+	timedOut := false
+	if len(blocks) == 0 {
+		timedOut = true
+		vote(common.TimeoutTag, nil, nil)
+	}
+
+	// the votes are counted by the merkle roots they vote for, the nodes may have received different sets of blocks
+	echoVotes := common.NewQuorumTracker(validators, minVoteCount)
+	acceptVotes := common.NewQuorumTracker(validators, minVoteCount)
+	timeoutVotes := common.NewQuorumTracker(validators, minVoteCount)
 
 	for {
+		select {
 
-		ev := <-echoChannel
+		case <-timeout:
+			if !accepted && !timedOut {
+				timedOut = true
+				log.Printf("echo phase of round %d timed out, validators %v did not vote echo\n", round, echoVotes.Missing())
+				vote(common.TimeoutTag, nil, nil)
+			}
 
-		if !AreTheyEqual(merkleRoots, ev.BlockHash) || !validateVote(ev, merkleRoots) {
-			panic(fmt.Errorf("echo vore received for undefined merkleroot"))
-		}
+		case m := <-timeoutChannel:
+			tv := m.(common.Vote)
+			if len(tv.BlockHash) != 0 {
+				demux.Reject(tv, fmt.Errorf("timeout vote has merkle roots"))
+				continue
+			}
 
-		echoVotes = append(echoVotes, ev)
-		peerSet.ForwardVote(ev)
+			if err := timeoutVotes.Add(tv); err != nil {
+				demux.Reject(tv, err)
+				continue
+			}
+			peerSet.ForwardVote(tv)
 
-		if len(echoVotes) == minVoteCount {
-			return echoVotes
-		}
+			if timeoutVotes.HasQuorum(nil) {
+				return common.DecidedRound{Round: round, TimeoutCertificate: common.TimeoutCertificate{TimeoutVotes: timeoutVotes.Votes(nil)}}
+			}
 
-	}
+		case m := <-echoChannel:
+			ev := m.(common.Vote)
+			if err := validateMerkleRoots(ev.BlockHash); err != nil {
+				demux.Reject(ev, err)
+				continue
+			}
 
-}
+			if err := echoVotes.Add(ev); err != nil {
+				demux.Reject(ev, err)
+				continue
+			}
+			peerSet.ForwardVote(ev)
 
-func AreTheyEqual(merkleRoots1 [][]byte, merkleRoots2 [][]byte) bool {
-	if len(merkleRoots1) != len(merkleRoots2) {
-		return false
-	}
+			if accepted || timedOut || !echoVotes.HasQuorum(ev.BlockHash) {
+				continue
+			}
 
-	for i := range merkleRoots1 {
-		if !bytes.Equal(merkleRoots1[i], merkleRoots2[i]) {
-			return false
-		}
-	}
+			// the node accepts a quorum of echo votes only if it has all the blocks
+			if _, ok := getBlocks(ev.BlockHash); !ok {
+				if echoVotes.Count(ev.BlockHash) == minVoteCount {
+					log.Printf("a quorum of echo votes is received for %d blocks of round %d, some of the blocks are not received\n", len(ev.BlockHash), round)
+				}
+				continue
+			}
 
-	return true
-}
+			accepted = true
+			vote(common.AcceptTag, ev.BlockHash, &common.AcceptProof{EchoVotes: echoVotes.Votes(ev.BlockHash)})
 
-func receiveAcceptVotes(round int, demux *common.Demux, minVoteCount int, merkleRoots [][]byte, peerSet *network.PeerSet) []common.Vote {
+		case m := <-acceptChannel:
+			av := m.(common.Vote)
+			if err := validateMerkleRoots(av.BlockHash); err != nil {
+				demux.Reject(av, err)
+				continue
+			}
 
-	acceptChannel, err := demux.GetVoteChan(round, common.AcceptTag)
-	if err != nil {
-		panic(err)
-	}
+			// the proof is not signed by the issuer of the vote, an invalid proof is attributed to the relaying peer
+			if len(av.Proof.EchoVotes) < minVoteCount {
+				demux.RejectRelayed(av, fmt.Errorf("accept vote has %d echo votes, required %d", len(av.Proof.EchoVotes), minVoteCount))
+				continue
+			}
 
-	var acceptVotes []common.Vote
+			// the echo votes of the proof are verified before, but they may be issued by the validators of another committee
+			if err := validators.CheckVotes(av.Proof.EchoVotes); err != nil {
+				demux.RejectRelayed(av, err)
+				continue
+			}
 
-	for {
+			// copies of a vote may carry different valid proofs, only the first one is counted
+			err := acceptVotes.Add(av)
+			if err == common.ErrDuplicateVote {
+				continue
+			}
+			if err != nil {
+				demux.Reject(av, err)
+				continue
+			}
+			peerSet.ForwardVote(av)
 
-		av := <-acceptChannel
+			if !acceptVotes.HasQuorum(av.BlockHash) {
+				continue
+			}
 
-		if !AreTheyEqual(merkleRoots, av.BlockHash) || len(av.Proof.EchoVotes) < minVoteCount {
-			continue
-		}
+			decidedBlocks, ok := getBlocks(av.BlockHash)
+			if !ok {
+				if acceptVotes.Count(av.BlockHash) == minVoteCount {
+					log.Printf("a quorum of accept votes is received for %d blocks of round %d, some of the blocks are not received\n", len(av.BlockHash), round)
+				}
+				continue
+			}
 
-		isEchoVotesValid := validateVote(av, merkleRoots)
-		for i := range av.Proof.EchoVotes {
-			isEchoVotesValid = isEchoVotesValid && validateVote(av.Proof.EchoVotes[i], merkleRoots)
-			if !isEchoVotesValid {
-				panic("invalid accept vote")
+			// the echo votes of any accept vote prove the echo quorum, they are verified before the vote is enqueued
+			return common.DecidedRound{
+				Round:             round,
+				Blocks:            decidedBlocks,
+				MerkleRoots:       av.BlockHash,
+				AcceptProof:       av.Proof,
+				AcceptCertificate: common.NewAcceptCertificate(acceptVotes.Votes(av.BlockHash)),
 			}
 		}
+	}
+}
 
-		if !isEchoVotesValid {
-			continue
-		}
+// validateMerkleRoots checks that a vote has merkle roots of the same length, so that they are joined unambiguously
+func validateMerkleRoots(merkleRoots [][]byte) error {
 
-		acceptVotes = append(acceptVotes, av)
-		peerSet.ForwardVote(av)
+	if len(merkleRoots) == 0 {
+		return fmt.Errorf("vote has no merkle roots")
+	}
 
-		if len(acceptVotes) >= minVoteCount {
-			return acceptVotes
+	for _, merkleRoot := range merkleRoots {
+		if len(merkleRoot) != sha256.Size {
+			return fmt.Errorf("vote has a merkle root of %d bytes", len(merkleRoot))
 		}
-
 	}
+
+	return nil
 }
 
-func validateChunk(chunk common.BlockChunk) bool {
+func AreTheyEqual(merkleRoots1 [][]byte, merkleRoots2 [][]byte) bool {
+	if len(merkleRoots1) != len(merkleRoots2) {
+		return false
+	}
+
+	for i := range merkleRoots1 {
+		if !bytes.Equal(merkleRoots1[i], merkleRoots2[i]) {
+			return false
+		}
+	}
+
+	return true
+}
 
-	result, err := common.VerifyContentWithPath(chunk.Authenticator.MerkleRoot, chunk, chunk.Authenticator.Path, chunk.Authenticator.Index)
+// validateChunk validates a chunk using the merkle root of the announcement.
+// The announcement must be validated before calling this function.
+// The merkle path of the chunk is verified by common.Verifier before the chunk is enqueued.
+func validateChunk(chunk common.BlockChunk, announcement common.BlockAnnouncement) error {
 
-	if err != nil {
-		panic(err)
+	if !bytes.Equal(chunk.Issuer, announcement.Issuer) || chunk.Round != announcement.Round {
+		return fmt.Errorf("chunk does not belong to the announced block")
 	}
 
-	if !result {
-		panic("merkle path is not correct")
+	if !bytes.Equal(chunk.Authenticator.MerkleRoot, announcement.MerkleRoot) {
+		return fmt.Errorf("merkle root of the chunk is not announced")
 	}
 
-	result = ed25519.Verify(chunk.Issuer, chunk.Hash(), chunk.Signature)
+	return nil
+}
 
-	if !result {
-		panic("chunks signature is not correct")
+// validateBlockAnnouncement checks an announcement against the config.
+// The signature of the announcement is verified by common.Verifier before the announcement is enqueued.
+func validateBlockAnnouncement(announcement common.BlockAnnouncement, round int, chunkCount int, requiredChunkCount int) error {
+
+	if announcement.Round != round || announcement.ChunkCount != chunkCount || announcement.DataChunkCount != requiredChunkCount {
+		return fmt.Errorf("block announcement does not match the config, chunk count %d data chunk count %d", announcement.ChunkCount, announcement.DataChunkCount)
 	}
 
-	return result
+	return nil
 }
 
 func validateBlock(block common.Block, previousBlockHash []byte) bool {
@@ -199,11 +501,6 @@ func validateBlock(block common.Block, previousBlockHash []byte) bool {
 	return bytes.Equal(block.PrevBlockHash, previousBlockHash)
 }
 
-func validateVote(vote common.Vote, merkleRoots [][]byte) bool {
-
-	return ed25519.Verify(vote.Issuer, vote.Hash(), vote.Signature)
-}
-
 func signHash(hash []byte, keyPrive ed25519.PrivateKey) []byte {
 
 	return ed25519.Sign(keyPrive, hash)
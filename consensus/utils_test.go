@@ -0,0 +1,218 @@
+package consensus
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/network"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+func TestDecisionWithEquivocatingValidator(t *testing.T) {
+
+	publicKeys, privateKeys := generateValidatorKeys(5)
+	validators, err := common.NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := common.NewBlock(publicKeys[0], nil, 1, nil)
+	merkleRoot := sha256.Sum256([]byte("merkle root"))
+	merkleRoots := [][]byte{merkleRoot[:]}
+
+	newVote := func(i int, tag byte, merkleRoots [][]byte, proof common.AcceptProof) common.Vote {
+		vote := common.Vote{Issuer: publicKeys[i], Tag: tag, Round: 1, BlockHash: merkleRoots, Proof: proof}
+		vote.Signature = signHash(vote.SigningHash(), privateKeys[i])
+		return vote
+	}
+
+	var echoVotes []common.Vote
+	for i := range publicKeys {
+		echoVotes = append(echoVotes, newVote(i, common.EchoTag, merkleRoots, common.AcceptProof{}))
+	}
+	acceptVote := func(i int) common.Vote {
+		return newVote(i, common.AcceptTag, merkleRoots, common.AcceptProof{EchoVotes: echoVotes})
+	}
+	timeoutVote := func(i int) common.Vote {
+		return newVote(i, common.TimeoutTag, nil, common.AcceptProof{})
+	}
+
+	peerSet := newDiscardingPeerSet(t)
+	defer peerSet.Close()
+
+	decide := func(minVoteCount int, votes []common.Vote) common.DecidedRound {
+		demux := common.NewDemultiplexer(0)
+		for _, vote := range votes {
+			if err := demux.Enque(vote); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return receiveDecision(1, demux, validators, minVoteCount, []common.Block{block}, merkleRoots, peerSet, nil, func(byte, [][]byte, *common.AcceptProof) {})
+	}
+
+	// validator 4 votes both accept and timeout. With majority quorums, the validators 0 and 1 vote accept,
+	// the validators 2 and 3 vote timeout, and two correct nodes decide differently
+	majority := registery.NodeConfig{QuorumFraction: 0.5}.QuorumSize(5)
+	if decided := decide(majority, []common.Vote{acceptVote(0), acceptVote(1), acceptVote(4)}); len(decided.Blocks) != 1 {
+		t.Fatalf("expected the blocks to be decided with a majority of accept votes")
+	}
+	if decided := decide(majority, []common.Vote{timeoutVote(2), timeoutVote(3), timeoutVote(4)}); len(decided.Blocks) != 0 {
+		t.Fatalf("expected the round to time out with a majority of timeout votes")
+	}
+
+	config := registery.NodeConfig{SynchronyBound: 1000, EchoTimeout: 10, QuorumFraction: 0.5}
+	if config.Validate() == nil {
+		t.Fatalf("majority quorums are accepted with the timeouts")
+	}
+
+	// with Byzantine quorums, a quorum of accept votes leaves too few validators for a quorum of timeout votes
+	config.QuorumFraction = 2.0 / 3.0
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	votes := []common.Vote{timeoutVote(3), timeoutVote(4), acceptVote(0), acceptVote(1), acceptVote(2), acceptVote(4)}
+	if decided := decide(config.QuorumSize(5), votes); len(decided.Blocks) != 1 || len(decided.TimeoutCertificate.TimeoutVotes) != 0 {
+		t.Errorf("expected the blocks to be decided with a quorum of accept votes")
+	}
+}
+
+func TestTruncatedAcceptProof(t *testing.T) {
+
+	publicKeys, privateKeys := generateValidatorKeys(5)
+	validators, err := common.NewValidatorSet(publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := common.NewBlock(publicKeys[0], nil, 1, nil)
+	merkleRoot := sha256.Sum256([]byte("merkle root"))
+	merkleRoots := [][]byte{merkleRoot[:]}
+
+	var echoVotes []common.Vote
+	for i := range publicKeys {
+		vote := common.Vote{Issuer: publicKeys[i], Tag: common.EchoTag, Round: 1, BlockHash: merkleRoots}
+		vote.Signature = signHash(vote.SigningHash(), privateKeys[i])
+		echoVotes = append(echoVotes, vote)
+	}
+
+	var acceptVotes []common.Vote
+	for i := range publicKeys {
+		vote := common.Vote{Issuer: publicKeys[i], Tag: common.AcceptTag, Round: 1, BlockHash: merkleRoots, Proof: common.AcceptProof{EchoVotes: echoVotes}}
+		vote.Signature = signHash(vote.SigningHash(), privateKeys[i])
+		acceptVotes = append(acceptVotes, vote)
+	}
+
+	// a relaying peer forwards the accept vote of validator 0 with a truncated proof before the honest copy arrives.
+	// The signature of the truncated copy is still valid, the proof is not signed
+	truncated := acceptVotes[0]
+	truncated.Proof = common.AcceptProof{EchoVotes: echoVotes[:1]}
+	if truncated.ID() == acceptVotes[0].ID() {
+		t.Fatalf("the truncated copy has the message ID of the vote")
+	}
+
+	demux := common.NewDemultiplexer(0)
+	if err := demux.EnqueFrom("relay", truncated); err != nil {
+		t.Fatal(err)
+	}
+	for i, vote := range acceptVotes {
+		if err := demux.EnqueFrom("honest", vote); err != nil {
+			t.Fatalf("accept vote %d is not enqueued: %s", i, err)
+		}
+	}
+
+	peerSet := newDiscardingPeerSet(t)
+	defer peerSet.Close()
+
+	minVoteCount := registery.NodeConfig{QuorumFraction: 2.0 / 3.0}.QuorumSize(5)
+	decided := receiveDecision(1, demux, validators, minVoteCount, []common.Block{block}, merkleRoots, peerSet, nil, func(byte, [][]byte, *common.AcceptProof) {})
+	if len(decided.Blocks) != 1 {
+		t.Fatalf("expected the block to be decided")
+	}
+
+	rejections := demux.Rejections()
+	if len(rejections.ByIssuer()) != 0 {
+		t.Errorf("the truncated proof is attributed to the issuer %v", rejections.ByIssuer())
+	}
+	if len(rejections.ByPeer()["relay"]) != 1 {
+		t.Errorf("expected the truncated proof to be attributed to the relaying peer, got %v", rejections.ByPeer())
+	}
+}
+
+func TestChunksOfLateProposal(t *testing.T) {
+
+	config := registery.NodeConfig{LeaderCount: 3, BlockChunkCount: 4, DataChunkCount: 4}
+	previousBlockHash := []byte("previous block hash")
+
+	lateChunks, lateRoot := common.ChunkBlockWithCounts(common.NewBlock([]byte("leader 1"), previousBlockHash, 1, nil), config.RequiredChunkCount(), config.BlockChunkCount)
+	unproposedChunks, _ := common.ChunkBlockWithCounts(common.NewBlock([]byte("leader 2"), previousBlockHash, 1, nil), config.RequiredChunkCount(), config.BlockChunkCount)
+	setIssuer(lateChunks, "leader 1")
+	setIssuer(unproposedChunks, "leader 2")
+
+	demux := common.NewDemultiplexer(0)
+	for _, chunks := range [][]common.BlockChunk{lateChunks, unproposedChunks} {
+		for _, c := range chunks {
+			if err := demux.Enque(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the propose vote of leader 1 arrives after the propose phase, the block of leader 3 is not received
+	lateVote := common.Vote{Issuer: []byte("leader 1"), Tag: common.ProposeTag, Round: 1, BlockHash: [][]byte{lateRoot}}
+	if err := demux.Enque(lateVote); err != nil {
+		t.Fatal(err)
+	}
+	proposeVotes := []common.Vote{{Issuer: []byte("leader 3"), Tag: common.ProposeTag, Round: 1, BlockHash: [][]byte{[]byte("merkle root")}}}
+
+	peerSet := newDiscardingPeerSet(t)
+	defer peerSet.Close()
+
+	blocks, _ := receiveMultipleBlocks(1, demux, config.BlockChunkCount, config.RequiredChunkCount(), peerSet, proposeVotes, previousBlockHash, notCommitted, time.After(50*time.Millisecond))
+	if len(blocks) != 0 {
+		t.Fatalf("expected no blocks, received %d blocks", len(blocks))
+	}
+
+	rejections := demux.Rejections().ByIssuer()
+	if len(rejections[common.EncodeIssuer([]byte("leader 1"))]) != 0 {
+		t.Errorf("the chunks of the late proposal are rejected %v", rejections)
+	}
+
+	if count := rejections[common.EncodeIssuer([]byte("leader 2"))][ErrNotProposed.Error()]; count != uint64(len(unproposedChunks)) {
+		t.Errorf("expected %d rejected chunks of the unproposed block, got %d", len(unproposedChunks), count)
+	}
+}
+
+// newDiscardingPeerSet returns a peer set of a single peer that discards the forwarded messages
+func newDiscardingPeerSet(t *testing.T) *network.PeerSet {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	peerSet := &network.PeerSet{}
+	address := listener.Addr().(*net.TCPAddr)
+	if err := peerSet.AddPeer(address.IP.String(), address.Port); err != nil {
+		t.Fatal(err)
+	}
+
+	return peerSet
+}
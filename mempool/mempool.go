@@ -0,0 +1,271 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/korkmazkadir/rapidchain/common"
+)
+
+// ErrDuplicateTransaction is returned if the transaction is already in the mempool
+var ErrDuplicateTransaction = errors.New("transaction is already in the mempool")
+
+// ErrCommittedTransaction is returned if the transaction is already included in a decided round
+var ErrCommittedTransaction = errors.New("transaction is already committed")
+
+// ErrInvalidTransaction is returned if the signature of the transaction is not valid
+var ErrInvalidTransaction = errors.New("transaction signature is not valid")
+
+// ErrMempoolFull is returned if the transaction does not fit into the mempool
+var ErrMempoolFull = errors.New("mempool is full")
+
+// Ordering defines the order in which transactions are taken from the mempool
+type Ordering int
+
+const (
+	// ArrivalOrder takes transactions in the order they are received
+	ArrivalOrder Ordering = iota
+
+	// FeeOrder takes transactions with higher fees first, ties are broken by arrival order
+	FeeOrder
+)
+
+// ParseOrdering parses the ordering names used in the node config
+func ParseOrdering(name string) (Ordering, error) {
+	switch name {
+	case "", "arrival":
+		return ArrivalOrder, nil
+	case "fee":
+		return FeeOrder, nil
+	default:
+		return ArrivalOrder, fmt.Errorf("unknown mempool ordering %q", name)
+	}
+}
+
+type entry struct {
+	tx       common.Transaction
+	hash     string
+	sequence uint64
+	size     int
+}
+
+// Mempool keeps transactions until they are included in a decided round
+type Mempool struct {
+	mutex sync.Mutex
+
+	// maximum number of transactions, 0 means no limit
+	maxCount int
+
+	// maximum total size of transactions, 0 means no limit
+	maxBytes int
+
+	ordering Ordering
+
+	entries map[string]*entry
+
+	// hashes of the transactions of the decided rounds, they are not accepted again
+	committed map[string]bool
+
+	size int
+
+	nextSequence uint64
+}
+
+// NewMempool creates a mempool with the given limits. A limit of 0 means no limit.
+func NewMempool(maxCount int, maxBytes int, ordering Ordering) *Mempool {
+
+	return &Mempool{
+		maxCount:  maxCount,
+		maxBytes:  maxBytes,
+		ordering:  ordering,
+		entries:   make(map[string]*entry),
+		committed: make(map[string]bool),
+	}
+}
+
+// Add verifies a transaction and adds it to the mempool.
+// If the mempool is full and the ordering is FeeOrder, transactions with lower fees are evicted to make room.
+func (m *Mempool) Add(tx common.Transaction) error {
+
+	if !tx.Verify() {
+		return ErrInvalidTransaction
+	}
+
+	hash := string(tx.Hash())
+	size := tx.Size()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.committed[hash] {
+		return ErrCommittedTransaction
+	}
+
+	if _, ok := m.entries[hash]; ok {
+		return ErrDuplicateTransaction
+	}
+
+	if m.maxBytes > 0 && size > m.maxBytes {
+		return ErrMempoolFull
+	}
+
+	if !m.hasRoomFor(size) {
+		if m.ordering != FeeOrder || !m.evictCheaperThan(tx.Fee, size) {
+			return ErrMempoolFull
+		}
+	}
+
+	m.entries[hash] = &entry{tx: tx, hash: hash, sequence: m.nextSequence, size: size}
+	m.nextSequence++
+	m.size += size
+
+	return nil
+}
+
+// Take returns transactions in the order of the mempool until their total size reaches maxBytes.
+// Transactions stay in the mempool until they are removed by Remove.
+func (m *Mempool) Take(maxBytes int) []common.Transaction {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var transactions []common.Transaction
+	size := 0
+	for _, e := range m.sortedEntries() {
+		if size+e.size > maxBytes {
+			break
+		}
+		transactions = append(transactions, e.tx)
+		size += e.size
+	}
+
+	return transactions
+}
+
+// Commit records the transactions of a decided round as committed, and evicts them from the mempool.
+// The committed transactions are rejected by Add, so that a replayed transaction is not included twice.
+func (m *Mempool) Commit(transactions []common.Transaction) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range transactions {
+		hash := string(transactions[i].Hash())
+		m.committed[hash] = true
+		if e, ok := m.entries[hash]; ok {
+			m.size -= e.size
+			delete(m.entries, hash)
+		}
+	}
+}
+
+// IsCommitted returns true if the transaction is recorded as committed
+func (m *Mempool) IsCommitted(txHash []byte) bool {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.committed[string(txHash)]
+}
+
+// Remove evicts transactions without recording them as committed
+func (m *Mempool) Remove(transactions []common.Transaction) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range transactions {
+		hash := string(transactions[i].Hash())
+		if e, ok := m.entries[hash]; ok {
+			m.size -= e.size
+			delete(m.entries, hash)
+		}
+	}
+}
+
+// Len returns the number of transactions in the mempool
+func (m *Mempool) Len() int {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.entries)
+}
+
+// Size returns the total size of the transactions in the mempool
+func (m *Mempool) Size() int {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.size
+}
+
+// All the following functions are helper functions.
+// They must be called from previous functions because
+// they are not thread safe!
+
+func (m *Mempool) hasRoomFor(size int) bool {
+
+	if m.maxCount > 0 && len(m.entries)+1 > m.maxCount {
+		return false
+	}
+
+	if m.maxBytes > 0 && m.size+size > m.maxBytes {
+		return false
+	}
+
+	return true
+}
+
+// evictCheaperThan evicts the transactions with the lowest fees to make room for a transaction.
+// It evicts nothing and returns false if that is not possible using only transactions with lower fees.
+func (m *Mempool) evictCheaperThan(fee uint64, size int) bool {
+
+	sortedEntries := m.sortedEntries()
+
+	count := len(m.entries)
+	totalSize := m.size
+	evictCount := 0
+	for i := len(sortedEntries) - 1; i >= 0; i-- {
+
+		fits := (m.maxCount == 0 || count+1 <= m.maxCount) && (m.maxBytes == 0 || totalSize+size <= m.maxBytes)
+		if fits {
+			break
+		}
+
+		if sortedEntries[i].tx.Fee >= fee {
+			return false
+		}
+
+		count--
+		totalSize -= sortedEntries[i].size
+		evictCount++
+	}
+
+	for _, e := range sortedEntries[len(sortedEntries)-evictCount:] {
+		m.size -= e.size
+		delete(m.entries, e.hash)
+	}
+
+	return true
+}
+
+func (m *Mempool) sortedEntries() []*entry {
+
+	sortedEntries := make([]*entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		sortedEntries = append(sortedEntries, e)
+	}
+
+	sort.Slice(sortedEntries, func(i, j int) bool {
+		if m.ordering == FeeOrder && sortedEntries[i].tx.Fee != sortedEntries[j].tx.Fee {
+			return sortedEntries[i].tx.Fee > sortedEntries[j].tx.Fee
+		}
+		return sortedEntries[i].sequence < sortedEntries[j].sequence
+	})
+
+	return sortedEntries
+}
@@ -0,0 +1,124 @@
+package mempool
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/korkmazkadir/rapidchain/common"
+)
+
+func TestMempool(t *testing.T) {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewMempool(3, 0, ArrivalOrder)
+
+	var transactions []common.Transaction
+	for i := 0; i < 4; i++ {
+		transactions = append(transactions, common.NewTransaction(privateKey, uint64(i), uint64(i), []byte{byte(i)}))
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := pool.Add(transactions[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pool.Add(transactions[0]); err != ErrDuplicateTransaction {
+		t.Errorf("expected duplicate transaction error, received %v", err)
+	}
+
+	if err := pool.Add(transactions[3]); err != ErrMempoolFull {
+		t.Errorf("expected mempool full error, received %v", err)
+	}
+
+	invalidTransaction := transactions[3]
+	invalidTransaction.Nonce++
+	if err := pool.Add(invalidTransaction); err != ErrInvalidTransaction {
+		t.Errorf("expected invalid transaction error, received %v", err)
+	}
+
+	// takes only two transactions in arrival order
+	taken := pool.Take(2 * transactions[0].Size())
+	if len(taken) != 2 || taken[0].Nonce != 0 || taken[1].Nonce != 1 {
+		t.Fatalf("unexpected transactions taken %+v", taken)
+	}
+
+	pool.Remove(taken)
+	if pool.Len() != 1 || pool.Size() != transactions[2].Size() {
+		t.Errorf("expected one transaction in the mempool, there are %d transactions", pool.Len())
+	}
+}
+
+func TestMempoolFeeOrder(t *testing.T) {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewMempool(2, 0, FeeOrder)
+
+	lowFee := common.NewTransaction(privateKey, 0, 1, nil)
+	mediumFee := common.NewTransaction(privateKey, 1, 5, nil)
+	highFee := common.NewTransaction(privateKey, 2, 10, nil)
+
+	for _, tx := range []common.Transaction{lowFee, mediumFee, highFee} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the transaction with the lowest fee is evicted
+	taken := pool.Take(1 << 20)
+	if len(taken) != 2 || !bytes.Equal(taken[0].Hash(), highFee.Hash()) || !bytes.Equal(taken[1].Hash(), mediumFee.Hash()) {
+		t.Fatalf("unexpected transactions taken %+v", taken)
+	}
+
+	if err := pool.Add(lowFee); err != ErrMempoolFull {
+		t.Errorf("expected mempool full error, received %v", err)
+	}
+}
+
+func TestMempoolCommit(t *testing.T) {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewMempool(0, 0, ArrivalOrder)
+
+	pending := common.NewTransaction(privateKey, 0, 1, []byte{0})
+	replayed := common.NewTransaction(privateKey, 1, 1, []byte{1})
+	if err := pool.Add(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	// a transaction of a decided round is evicted, and it is rejected if it is submitted again
+	pool.Commit([]common.Transaction{pending, replayed})
+	if pool.Len() != 0 || pool.Size() != 0 {
+		t.Errorf("expected the committed transaction to be evicted, there are %d transactions", pool.Len())
+	}
+
+	for _, tx := range []common.Transaction{pending, replayed} {
+		if !pool.IsCommitted(tx.Hash()) {
+			t.Errorf("expected the transaction to be committed")
+		}
+
+		if err := pool.Add(tx); err != ErrCommittedTransaction {
+			t.Errorf("expected committed transaction error, received %v", err)
+		}
+	}
+
+	// the removed transactions are not committed
+	other := common.NewTransaction(privateKey, 2, 1, []byte{2})
+	pool.Remove([]common.Transaction{other})
+	if err := pool.Add(other); err != nil {
+		t.Errorf("expected the removed transaction to be accepted, received %v", err)
+	}
+}
@@ -0,0 +1,518 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/registery"
+)
+
+// ProofChainReader provides the decided rounds and the chunks of their blocks to create the inclusion proofs
+type ProofChainReader interface {
+	ChainReader
+
+	DataChunks(round int, dataChunkCount int, chunkCount int) ([][]common.BlockChunk, error)
+}
+
+// APIServer serves a read-only HTTP JSON API to inspect a running node.
+// The rounds, the peers, the queues, the rejections and the beacon are the ones of the shard chain of the node.
+//
+//	GET /round          current round of the node
+//	GET /node           node ID, config and config hash
+//	GET /peers          peers and the state of the connections
+//	GET /rounds/{round} decided blocks of a round
+//	GET /stats          phase timings of all rounds
+//	GET /stats/{round}  phase timings of a round
+//	GET /queues         depth and drop counters of the demux queues
+//	GET /rejections     number of rejected messages by peer address, by issuer, and by reason
+//	GET /verification   counters and timings of the message verification
+//	GET /beacon         seeds of the epochs and the validators that contributed to them
+//	GET /proofs/tx/{hash}?round={round}
+//	                    inclusion proof of a transaction, the hash is hex encoded
+//	GET /proofs/payload?round={round}&block={index}&offset={offset}&length={length}
+//	                    inclusion proof of a range of the payload of a block
+//
+// Proofs are verified by common.VerifyTransactionProof and common.VerifyPayloadRangeProof,
+// against the validators of the round and the quorum size of the config for them.
+type APIServer struct {
+	nodeID     int
+	config     registery.NodeConfig
+	verifier   *common.Verifier
+	p2pServer  *P2PServer
+	statLogger *common.StatLogger
+
+	// the shard chain of the node, it changes when the node is assigned to another committee
+	mutex   sync.Mutex
+	shard   int
+	demux   *common.Demux
+	peerSet *PeerSet
+	chain   ProofChainReader
+	beacon  *common.Beacon
+
+	// validators of the shard chain in a round, it returns false if they are not known yet
+	validators func(round int) (*common.ValidatorSet, bool)
+
+	mux *http.ServeMux
+}
+
+// RoundInfo is the response of /round
+type RoundInfo struct {
+	CurrentRound int
+	HighestRound int
+	LastDecided  int
+}
+
+// NodeStatus is the response of /node
+type NodeStatus struct {
+	NodeID     int
+	Shard      int
+	ConfigHash string
+	Config     registery.NodeConfig
+}
+
+// BlockInfo describes a decided block, transactions are not included
+type BlockInfo struct {
+	Hash             string
+	Issuer           string
+	PrevBlockHash    string
+	Round            int
+	TxRoot           string
+	BeaconProof      string
+	TransactionCount int
+	PayloadSize      int
+}
+
+// DecidedRoundInfo is the response of /rounds/{round}
+type DecidedRoundInfo struct {
+	Round         int
+	Hash          string
+	Blocks        []BlockInfo
+	MerkleRoots   []string
+	EchoVoteCount int
+
+	// number of the accept votes that made the round final
+	AcceptVoteCount int
+
+	// number of the timeout votes of an empty round
+	TimeoutVoteCount int
+}
+
+// RejectionInfo is the response of /rejections, the counts are keyed by peer address or issuer, and by reason
+type RejectionInfo struct {
+	Peers   map[string]map[string]uint64
+	Issuers map[string]map[string]uint64
+}
+
+// VerificationInfo is an element of the response of /verification, the times are in microseconds
+type VerificationInfo struct {
+	Verified      uint64
+	Failed        uint64
+	CacheHits     uint64
+	AverageWait   int64
+	AverageVerify int64
+	MaxVerify     int64
+}
+
+// EpochInfo is an element of the response of /beacon.
+// The seed of the next epoch is derived from the seed and the VRF outputs of the contributors of the epoch.
+type EpochInfo struct {
+	Epoch        int
+	Seed         string
+	Contributors []string
+}
+
+// EventInfo is an element of the response of /stats
+type EventInfo struct {
+	Round       int
+	Type        string
+	ElapsedTime int
+}
+
+func NewAPIServer(nodeID int, config registery.NodeConfig, demux *common.Demux, verifier *common.Verifier, p2pServer *P2PServer, peerSet *PeerSet, chain ProofChainReader, beacon *common.Beacon, validators func(round int) (*common.ValidatorSet, bool), statLogger *common.StatLogger) *APIServer {
+
+	s := &APIServer{nodeID: nodeID, config: config, verifier: verifier, p2pServer: p2pServer, statLogger: statLogger}
+	s.SetShard(0, demux, peerSet, chain, beacon, validators)
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/round", s.handleRound)
+	s.mux.HandleFunc("/node", s.handleNode)
+	s.mux.HandleFunc("/peers", s.handlePeers)
+	s.mux.HandleFunc("/rounds/", s.handleDecidedRound)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	s.mux.HandleFunc("/stats/", s.handleStats)
+	s.mux.HandleFunc("/queues", s.handleQueues)
+	s.mux.HandleFunc("/rejections", s.handleRejections)
+	s.mux.HandleFunc("/verification", s.handleVerification)
+	s.mux.HandleFunc("/beacon", s.handleBeacon)
+	s.mux.HandleFunc("/proofs/tx/", s.handleTransactionProof)
+	s.mux.HandleFunc("/proofs/payload", s.handlePayloadRangeProof)
+
+	return s
+}
+
+// SetShard sets the shard chain of the node, validators provides the members of the committee of the chain in a round
+func (s *APIServer) SetShard(shard int, demux *common.Demux, peerSet *PeerSet, chain ProofChainReader, beacon *common.Beacon, validators func(round int) (*common.ValidatorSet, bool)) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.shard = shard
+	s.demux = demux
+	s.peerSet = peerSet
+	s.chain = chain
+	s.beacon = beacon
+	s.validators = validators
+}
+
+// quorumOf returns the validators of the shard chain in the round and their quorum size
+func (s *APIServer) quorumOf(round int) (*common.ValidatorSet, int, error) {
+
+	s.mutex.Lock()
+	validatorsOf := s.validators
+	s.mutex.Unlock()
+
+	validators, ok := validatorsOf(round)
+	if !ok {
+		return nil, 0, fmt.Errorf("validators of round %d are not known", round)
+	}
+
+	return validators, s.config.QuorumSize(validators.Size()), nil
+}
+
+// currentShard returns the shard chain of the node
+func (s *APIServer) currentShard() (int, *common.Demux, *PeerSet, ProofChainReader, *common.Beacon) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.shard, s.demux, s.peerSet, s.chain, s.beacon
+}
+
+// Serve serves the API on the listener, it blocks the calling goroutine
+func (s *APIServer) Serve(l net.Listener) error {
+	return http.Serve(l, s)
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *APIServer) handleRound(w http.ResponseWriter, r *http.Request) {
+
+	_, demux, _, chain, _ := s.currentShard()
+	writeJSON(w, RoundInfo{
+		CurrentRound: demux.CurrentRound(),
+		HighestRound: demux.HighestRound(),
+		LastDecided:  chain.LastRound(),
+	})
+}
+
+func (s *APIServer) handleNode(w http.ResponseWriter, r *http.Request) {
+
+	shard, _, _, _, _ := s.currentShard()
+	writeJSON(w, NodeStatus{NodeID: s.nodeID, Shard: shard, ConfigHash: fmt.Sprintf("%x", s.config.Hash()), Config: s.config})
+}
+
+func (s *APIServer) handlePeers(w http.ResponseWriter, r *http.Request) {
+
+	_, _, peerSet, _, _ := s.currentShard()
+	peers := peerSet.Peers()
+	if peers == nil {
+		peers = []PeerInfo{}
+	}
+
+	writeJSON(w, peers)
+}
+
+func (s *APIServer) handleDecidedRound(w http.ResponseWriter, r *http.Request) {
+
+	round, err := parseRound(r.URL.Path, "/rounds/")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, _, _, chain, _ := s.currentShard()
+	decidedRound, err := chain.Get(round)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("round %d: %s", round, err))
+		return
+	}
+
+	info := DecidedRoundInfo{
+		Round:            decidedRound.Round,
+		EchoVoteCount:    len(decidedRound.AcceptProof.EchoVotes),
+		AcceptVoteCount:  len(decidedRound.AcceptCertificate.AcceptVotes),
+		TimeoutVoteCount: len(decidedRound.TimeoutCertificate.TimeoutVotes),
+	}
+
+	// an empty round does not have a hash
+	if !decidedRound.IsEmpty() {
+		info.Hash = fmt.Sprintf("%x", common.HashBlocks(decidedRound.Blocks))
+	}
+
+	for _, block := range decidedRound.Blocks {
+
+		transactions, err := block.Transactions()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		info.Blocks = append(info.Blocks, BlockInfo{
+			Hash:             fmt.Sprintf("%x", block.Hash()),
+			Issuer:           fmt.Sprintf("%x", block.Issuer),
+			PrevBlockHash:    fmt.Sprintf("%x", block.PrevBlockHash),
+			Round:            block.Round,
+			TxRoot:           fmt.Sprintf("%x", block.TxRoot),
+			BeaconProof:      fmt.Sprintf("%x", block.BeaconProof),
+			TransactionCount: len(transactions),
+			PayloadSize:      len(block.Payload),
+		})
+	}
+
+	for _, merkleRoot := range decidedRound.MerkleRoots {
+		info.MerkleRoots = append(info.MerkleRoots, fmt.Sprintf("%x", merkleRoot))
+	}
+
+	writeJSON(w, info)
+}
+
+func (s *APIServer) handleStats(w http.ResponseWriter, r *http.Request) {
+
+	var events []common.Event
+	if r.URL.Path == "/stats" || r.URL.Path == "/stats/" {
+		events = s.statLogger.GetEvents()
+	} else {
+		round, err := parseRound(r.URL.Path, "/stats/")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		events = s.statLogger.GetRoundEvents(round)
+	}
+
+	infos := []EventInfo{}
+	for _, event := range events {
+		infos = append(infos, EventInfo{Round: event.Round, Type: event.Type.String(), ElapsedTime: event.ElapsedTime})
+	}
+
+	writeJSON(w, infos)
+}
+
+func (s *APIServer) handleQueues(w http.ResponseWriter, r *http.Request) {
+
+	// keyed by message kinds
+	queues := make(map[string]common.QueueStats)
+	_, demux, _, _, _ := s.currentShard()
+	for kind, stats := range demux.QueueStats() {
+		queues[string(kind)] = stats
+	}
+
+	writeJSON(w, queues)
+}
+
+func (s *APIServer) handleRejections(w http.ResponseWriter, r *http.Request) {
+
+	_, demux, _, _, _ := s.currentShard()
+	rejections := demux.Rejections()
+	writeJSON(w, RejectionInfo{Peers: rejections.ByPeer(), Issuers: rejections.ByIssuer()})
+}
+
+func (s *APIServer) handleBeacon(w http.ResponseWriter, r *http.Request) {
+
+	infos := []EpochInfo{}
+	_, _, _, _, beacon := s.currentShard()
+	for _, epoch := range beacon.Epochs() {
+		info := EpochInfo{Epoch: epoch.Epoch, Seed: fmt.Sprintf("%x", epoch.Seed), Contributors: []string{}}
+		for _, contributor := range epoch.Contributors {
+			info.Contributors = append(info.Contributors, fmt.Sprintf("%x", contributor))
+		}
+		infos = append(infos, info)
+	}
+
+	writeJSON(w, infos)
+}
+
+func (s *APIServer) handleVerification(w http.ResponseWriter, r *http.Request) {
+
+	// keyed by message kinds
+	infos := make(map[string]VerificationInfo)
+	for kind, stats := range s.verifier.Stats() {
+		info := VerificationInfo{Verified: stats.Verified, Failed: stats.Failed, CacheHits: stats.CacheHits, MaxVerify: stats.MaxVerifyTime.Microseconds()}
+
+		// cache hits wait for a worker but they are not verified
+		if count := stats.Verified + stats.Failed + stats.CacheHits; count > 0 {
+			info.AverageWait = stats.WaitTime.Microseconds() / int64(count)
+		}
+		if count := stats.Verified + stats.Failed; count > 0 {
+			info.AverageVerify = stats.VerifyTime.Microseconds() / int64(count)
+		}
+
+		infos[string(kind)] = info
+	}
+
+	writeJSON(w, infos)
+}
+
+func (s *APIServer) handleTransactionProof(w http.ResponseWriter, r *http.Request) {
+
+	txHash, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/proofs/tx/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid transaction hash: %s", err))
+		return
+	}
+
+	decidedRound, ok := s.getDecidedRound(w, r.URL.Query().Get("round"))
+	if !ok {
+		return
+	}
+
+	chunks, ok := s.getDataChunks(w, decidedRound.Round)
+	if !ok {
+		return
+	}
+
+	for i := range decidedRound.Blocks {
+		proof, err := common.NewTransactionProof(decidedRound, i, chunks[i], txHash)
+		if err != nil {
+			continue
+		}
+
+		// the proof is served only if it is certified by the validators of the round
+		validators, minVoteCount, err := s.quorumOf(decidedRound.Round)
+		if err == nil {
+			err = common.VerifyTransactionProof(proof, validators, minVoteCount)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, proof)
+		return
+	}
+
+	writeError(w, http.StatusNotFound, fmt.Errorf("transaction is not in round %d", decidedRound.Round))
+}
+
+func (s *APIServer) handlePayloadRangeProof(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	decidedRound, ok := s.getDecidedRound(w, query.Get("round"))
+	if !ok {
+		return
+	}
+
+	var values [3]int
+	for i, name := range []string{"block", "offset", "length"} {
+		value, err := strconv.Atoi(query.Get(name))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s %q", name, query.Get(name)))
+			return
+		}
+		values[i] = value
+	}
+
+	blockIndex, offset, length := values[0], values[1], values[2]
+	if blockIndex < 0 || blockIndex >= len(decidedRound.Blocks) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("round %d has %d blocks", decidedRound.Round, len(decidedRound.Blocks)))
+		return
+	}
+
+	chunks, ok := s.getDataChunks(w, decidedRound.Round)
+	if !ok {
+		return
+	}
+
+	proof, err := common.NewPayloadRangeProof(decidedRound, blockIndex, chunks[blockIndex], offset, length)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the proof is served only if it is certified by the validators of the round
+	validators, minVoteCount, err := s.quorumOf(decidedRound.Round)
+	if err == nil {
+		_, err = common.VerifyPayloadRangeProof(proof, validators, minVoteCount)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, proof)
+}
+
+// getDataChunks reads the data chunks of the blocks of the round from the chain, they are chunked with the chunk counts of the config.
+// It writes the error response if the chunks are not available.
+func (s *APIServer) getDataChunks(w http.ResponseWriter, round int) ([][]common.BlockChunk, bool) {
+
+	_, _, _, chain, _ := s.currentShard()
+	chunks, err := chain.DataChunks(round, s.config.RequiredChunkCount(), s.config.BlockChunkCount)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("round %d: %s", round, err))
+		return nil, false
+	}
+
+	return chunks, true
+}
+
+// getDecidedRound reads the decided round from the chain, it writes the error response if the round is not available
+func (s *APIServer) getDecidedRound(w http.ResponseWriter, roundParameter string) (common.DecidedRound, bool) {
+
+	round, err := strconv.Atoi(roundParameter)
+	if err != nil || round < 1 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid round %q", roundParameter))
+		return common.DecidedRound{}, false
+	}
+
+	_, _, _, chain, _ := s.currentShard()
+	decidedRound, err := chain.Get(round)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("round %d: %s", round, err))
+		return common.DecidedRound{}, false
+	}
+
+	return decidedRound, true
+}
+
+func parseRound(path string, prefix string) (int, error) {
+
+	round, err := strconv.Atoi(strings.TrimPrefix(path, prefix))
+	if err != nil || round < 1 {
+		return 0, fmt.Errorf("invalid round %q", strings.TrimPrefix(path, prefix))
+	}
+
+	return round, nil
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("could not write the http response: %s\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct{ Error string }{Error: err.Error()})
+}
@@ -2,7 +2,9 @@ package network
 
 import (
 	"fmt"
+	"log"
 	"net/rpc"
+	"sync"
 
 	"github.com/korkmazkadir/rapidchain/common"
 )
@@ -12,12 +14,16 @@ type P2PClient struct {
 	IPAddress  string
 	portNumber int
 
+	// namespace of the messages sent to the peer
+	shard int
+
 	rpcClient *rpc.Client
 
-	blockChunks chan common.BlockChunk
-	votes       chan common.Vote
+	messages chan common.Message
 
-	err error
+	// protects err, it is set by the goroutines of the main loop
+	mutex sync.Mutex
+	err   error
 }
 
 // NewClient creates a new client
@@ -33,8 +39,7 @@ func NewClient(IPAddress string, portNumber int) (*P2PClient, error) {
 	client.portNumber = portNumber
 	client.rpcClient = rpcClient
 
-	client.blockChunks = make(chan common.BlockChunk, 1024)
-	client.votes = make(chan common.Vote, 1024)
+	client.messages = make(chan common.Message, 3*1024)
 
 	return client, nil
 }
@@ -45,29 +50,74 @@ func (c *P2PClient) Start() {
 	c.mainLoop()
 }
 
-// SendBlockChunk enques a chunk of a block to send
-func (c *P2PClient) SendBlockChunk(chunk common.BlockChunk) {
+// SendMessage enques a message to send, the message is delivered to the demultiplexer of the peer
+func (c *P2PClient) SendMessage(message common.Message) {
+
+	c.messages <- message
+}
+
+// RequestSync requests decided rounds from the peer, it blocks until the response is received
+func (c *P2PClient) RequestSync(request common.SyncRequest) (common.SyncResponse, error) {
+
+	response := common.SyncResponse{}
+	err := c.rpcClient.Call("P2PServer.HandleSyncRequest", request, &response)
+
+	return response, err
+}
+
+// Address returns the address of the peer
+func (c *P2PClient) Address() string {
+	return fmt.Sprintf("%s:%d", c.IPAddress, c.portNumber)
+}
+
+// Err returns the error that closed the connection, it returns nil if the connection is open
+func (c *P2PClient) Err() error {
 
-	c.blockChunks <- chunk
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
+
+// PendingMessageCount returns the number of messages waiting to be sent
+func (c *P2PClient) PendingMessageCount() int {
+	return len(c.messages)
 }
 
-// SendVote enques a vote to send
-func (c *P2PClient) SendVote(vote common.Vote) {
+// Close stops the main loop and closes the connection, messages must not be sent after
+func (c *P2PClient) Close() {
+
+	close(c.messages)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	c.votes <- vote
+	if c.err == nil {
+		c.rpcClient.Close()
+		c.err = rpc.ErrShutdown
+	}
 }
 
 func (c *P2PClient) mainLoop() {
 
-	for {
-		select {
+	for message := range c.messages {
+		go c.call("P2PServer.HandleMessage", &common.Envelope{Shard: c.shard, Message: message})
+	}
+}
 
-		case vote := <-c.votes:
-			go c.rpcClient.Call("P2PServer.HandleVote", vote, nil)
+// call calls the remote method, the client is marked as failed if the connection is closed
+func (c *P2PClient) call(serviceMethod string, args interface{}) {
+
+	err := c.rpcClient.Call(serviceMethod, args, nil)
+	if err != rpc.ErrShutdown {
+		return
+	}
 
-		case blockChunk := <-c.blockChunks:
-			go c.rpcClient.Call("P2PServer.HandleBlockChunk", blockChunk, nil)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-		}
+	if c.err == nil {
+		log.Printf("connection to %s is closed\n", c.Address())
+		c.err = err
 	}
 }
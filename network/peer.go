@@ -2,13 +2,25 @@ package network
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/korkmazkadir/rapidchain/common"
 )
 
 var NoCorrectPeerAvailable = errors.New("there are no correct peers available")
 
+// PeerInfo describes the state of the connection to a peer
+type PeerInfo struct {
+	Address         string
+	Connected       bool
+	Error           string `json:",omitempty"`
+	PendingMessages int
+}
+
 type PeerSet struct {
+	// Shard is the namespace of the messages and the sync requests sent to the peers
+	Shard int
+
 	peers []*P2PClient
 }
 
@@ -18,6 +30,7 @@ func (p *PeerSet) AddPeer(IPAddress string, portNumber int) error {
 	if err != nil {
 		return err
 	}
+	client.shard = p.Shard
 
 	// starts the main loop of client
 	go client.Start()
@@ -31,19 +44,20 @@ func (p *PeerSet) DissaminateChunks(chunks []common.BlockChunk) {
 
 	for index, chunk := range chunks {
 		peer := p.selectPeer(index)
-		peer.SendBlockChunk(chunk)
+		peer.SendMessage(chunk)
 	}
 }
 
-func (p *PeerSet) ForwardChunk(chunk common.BlockChunk) {
+// ForwardMessage sends a message to all the correct peers
+func (p *PeerSet) ForwardMessage(message common.Message) {
 
 	forwardCount := 0
 	for _, peer := range p.peers {
-		if peer.err != nil {
+		if peer.Err() != nil {
 			continue
 		}
 		forwardCount++
-		peer.SendBlockChunk(chunk)
+		peer.SendMessage(message)
 	}
 
 	if forwardCount == 0 {
@@ -51,19 +65,72 @@ func (p *PeerSet) ForwardChunk(chunk common.BlockChunk) {
 	}
 }
 
+func (p *PeerSet) ForwardChunk(chunk common.BlockChunk) {
+	p.ForwardMessage(chunk)
+}
+
+func (p *PeerSet) ForwardBlockAnnouncement(announcement common.BlockAnnouncement) {
+	p.ForwardMessage(announcement)
+}
+
 func (p *PeerSet) ForwardVote(vote common.Vote) {
+	p.ForwardMessage(vote)
+}
 
-	forwardCount := 0
+// RequestSync requests decided rounds from the peers one by one, until a response
+// that contains at least one round is accepted by the provided function.
+func (p *PeerSet) RequestSync(request common.SyncRequest, accept func(common.SyncResponse) error) (common.SyncResponse, error) {
+
+	request.Shard = p.Shard
+
+	var lastErr error
 	for _, peer := range p.peers {
-		if peer.err != nil {
+		if peer.Err() != nil {
 			continue
 		}
-		forwardCount++
-		peer.SendVote(vote)
+
+		response, err := peer.RequestSync(request)
+		if err != nil {
+			lastErr = fmt.Errorf("sync request to %s:%d failed: %s", peer.IPAddress, peer.portNumber, err)
+			continue
+		}
+
+		if len(response.Rounds) == 0 {
+			continue
+		}
+
+		err = accept(response)
+		if err != nil {
+			lastErr = fmt.Errorf("sync response of %s:%d is not accepted: %s", peer.IPAddress, peer.portNumber, err)
+			continue
+		}
+
+		return response, nil
 	}
 
-	if forwardCount == 0 {
-		panic(NoCorrectPeerAvailable)
+	return common.SyncResponse{}, lastErr
+}
+
+// Peers returns the connection states of the peers
+func (p *PeerSet) Peers() []PeerInfo {
+
+	var peers []PeerInfo
+	for _, peer := range p.peers {
+		info := PeerInfo{Address: peer.Address(), Connected: true, PendingMessages: peer.PendingMessageCount()}
+		if err := peer.Err(); err != nil {
+			info.Connected = false
+			info.Error = err.Error()
+		}
+		peers = append(peers, info)
+	}
+
+	return peers
+}
+
+// Close closes the connections to the peers, the peer set must not be used after
+func (p *PeerSet) Close() {
+	for _, peer := range p.peers {
+		peer.Close()
 	}
 }
 
@@ -72,7 +139,7 @@ func (p *PeerSet) selectPeer(index int) *P2PClient {
 	peerCount := len(p.peers)
 	for i := 0; i < peerCount; i++ {
 		peer := p.peers[(index+i)%peerCount]
-		if peer.err == nil {
+		if peer.Err() == nil {
 			return peer
 		}
 	}
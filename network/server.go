@@ -1,28 +1,177 @@
 package network
 
 import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+
 	"github.com/korkmazkadir/rapidchain/common"
 )
 
+// maximum number of rounds returned by a sync response
+const maxSyncRoundCount = 4
+
+// ChainReader provides the decided rounds to the peers that are catching up
+type ChainReader interface {
+	LastRound() int
+
+	Get(round int) (common.DecidedRound, error)
+}
+
 type P2PServer struct {
-	demux *common.Demux
+	mutex sync.Mutex
+
+	// demux and chain of each shard keyed by shard, the messages and the sync requests are routed by their shards
+	demuxes map[int]*common.Demux
+	chains  map[int]ChainReader
+
+	// services served on the connections in addition to the p2p handlers
+	services map[string]interface{}
 }
 
-func NewServer(demux *common.Demux) *P2PServer {
-	server := &P2PServer{demux: demux}
+// NewServer creates a server of the shard 0, the chain of a node that does not run committees
+func NewServer(demux *common.Demux, chain ChainReader) *P2PServer {
+	server := &P2PServer{demuxes: make(map[int]*common.Demux), chains: make(map[int]ChainReader)}
+	server.services = make(map[string]interface{})
+	server.AddShard(0, demux, chain)
 	return server
 }
 
-func (s *P2PServer) HandleBlockChunk(chunk *common.BlockChunk, reply *int) error {
+// AddShard serves the messages and the sync requests of a shard
+func (s *P2PServer) AddShard(shard int, demux *common.Demux, chain ChainReader) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.demuxes[shard] = demux
+	s.chains[shard] = chain
+}
+
+// shard returns the demux and the chain of a shard
+func (s *P2PServer) shard(shard int) (*common.Demux, ChainReader, error) {
 
-	s.demux.EnqueBlockChunk(*chunk)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	demux, ok := s.demuxes[shard]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown shard %d", shard)
+	}
+
+	return demux, s.chains[shard], nil
+}
+
+// RegisterService registers a net/rpc service to be served on the connections accepted after the call
+func (s *P2PServer) RegisterService(name string, service interface{}) error {
+
+	// validates the service
+	if err := rpc.NewServer().RegisterName(name, service); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.services[name] = service
 
 	return nil
 }
 
-func (s *P2PServer) HandleVote(vote *common.Vote, reply *int) error {
+// ServeConn serves the p2p handlers and the registered services on a connection, it blocks until the connection is closed.
+// Every connection has its own handlers, so that the rejected messages are attributed to the remote address.
+func (s *P2PServer) ServeConn(conn net.Conn) {
+
+	server := rpc.NewServer()
+
+	err := server.RegisterName("P2PServer", &PeerHandler{server: s, peer: conn.RemoteAddr().String()})
+	if err != nil {
+		panic(err)
+	}
+
+	s.mutex.Lock()
+	for name, service := range s.services {
+		if err := server.RegisterName(name, service); err != nil {
+			panic(err)
+		}
+	}
+	s.mutex.Unlock()
+
+	server.ServeConn(conn)
+}
+
+// countRejection counts the message as rejected for the peer and the issuer if err is not nil, and returns err to the sender.
+// The rejections are kept by the demux, so that they are counted together with the rejections of the consensus layer.
+// The rejections of the messages of unknown shards are counted by the demux of the shard 0.
+func (s *P2PServer) countRejection(demux *common.Demux, peer string, message common.Message, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	if demux == nil {
+		demux, _, _ = s.shard(0)
+	}
+
+	var issuer []byte
+	if message != nil {
+		issuer = common.MessageIssuer(message)
+	}
+	demux.Rejections().Count(peer, issuer, err)
+
+	return err
+}
+
+// PeerHandler handles the messages received on the connection of a peer.
+// It is served with the name P2PServer.
+type PeerHandler struct {
+	server *P2PServer
+	peer   string
+}
+
+// HandleMessage enques a message of any registered kind to the demultiplexer of its shard
+func (h *PeerHandler) HandleMessage(envelope *common.Envelope, reply *int) error {
+
+	demux, _, err := h.server.shard(envelope.Shard)
+	if err != nil {
+		return h.server.countRejection(nil, h.peer, envelope.Message, err)
+	}
+
+	if envelope.Message == nil {
+		return h.server.countRejection(demux, h.peer, nil, fmt.Errorf("envelope is empty"))
+	}
+
+	return h.server.countRejection(demux, h.peer, envelope.Message, demux.EnqueFrom(h.peer, envelope.Message))
+}
+
+func (h *PeerHandler) HandleSyncRequest(request *common.SyncRequest, response *common.SyncResponse) error {
+
+	return h.server.HandleSyncRequest(request, response)
+}
+
+// HandleSyncRequest returns the requested decided rounds of the shard that are available
+func (s *P2PServer) HandleSyncRequest(request *common.SyncRequest, response *common.SyncResponse) error {
+
+	_, chain, err := s.shard(request.Shard)
+	if err != nil {
+		return err
+	}
+
+	lastRound := chain.LastRound()
+	response.LastRound = lastRound
+
+	toRound := request.ToRound
+	if toRound == 0 || toRound > lastRound {
+		toRound = lastRound
+	}
 
-	s.demux.EnqueVote(*vote)
+	for round := request.FromRound; round <= toRound && len(response.Rounds) < maxSyncRoundCount; round++ {
+		decidedRound, err := chain.Get(round)
+		if err != nil {
+			return err
+		}
+		response.Rounds = append(response.Rounds, decidedRound)
+	}
 
 	return nil
 }
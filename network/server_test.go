@@ -0,0 +1,69 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/korkmazkadir/rapidchain/common"
+)
+
+func TestHandleSyncRequest(t *testing.T) {
+
+	var chain memoryChain
+	for round := 1; round <= 10; round++ {
+		chain = append(chain, common.DecidedRound{Round: round})
+	}
+
+	server := NewServer(common.NewDemultiplexer(10), chain)
+
+	tests := []struct {
+		request common.SyncRequest
+		rounds  []int
+	}{
+		// the responses are paged, the requester continues after the last returned round
+		{request: common.SyncRequest{FromRound: 1}, rounds: []int{1, 2, 3, 4}},
+		{request: common.SyncRequest{FromRound: 9}, rounds: []int{9, 10}},
+		{request: common.SyncRequest{FromRound: 2, ToRound: 3}, rounds: []int{2, 3}},
+		{request: common.SyncRequest{FromRound: 8, ToRound: 20}, rounds: []int{8, 9, 10}},
+		{request: common.SyncRequest{FromRound: 11}, rounds: nil},
+		{request: common.SyncRequest{FromRound: 5, ToRound: 4}, rounds: nil},
+	}
+
+	for _, test := range tests {
+		var response common.SyncResponse
+		if err := server.HandleSyncRequest(&test.request, &response); err != nil {
+			t.Fatal(err)
+		}
+
+		if response.LastRound != 10 {
+			t.Errorf("expected last round 10, got %d", response.LastRound)
+		}
+
+		var rounds []int
+		for _, decidedRound := range response.Rounds {
+			rounds = append(rounds, decidedRound.Round)
+		}
+
+		if fmt.Sprint(rounds) != fmt.Sprint(test.rounds) {
+			t.Errorf("request %+v returned rounds %v, expected %v", test.request, rounds, test.rounds)
+		}
+	}
+
+	if err := server.HandleSyncRequest(&common.SyncRequest{Shard: 1, FromRound: 1}, &common.SyncResponse{}); err == nil {
+		t.Errorf("expected an error because the shard is not served")
+	}
+}
+
+// memoryChain serves the decided rounds starting from round 1
+type memoryChain []common.DecidedRound
+
+func (m memoryChain) LastRound() int {
+	return len(m)
+}
+
+func (m memoryChain) Get(round int) (common.DecidedRound, error) {
+	if round < 1 || round > len(m) {
+		return common.DecidedRound{}, fmt.Errorf("round %d is not decided", round)
+	}
+	return m[round-1], nil
+}
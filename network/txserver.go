@@ -0,0 +1,43 @@
+package network
+
+import (
+	"github.com/korkmazkadir/rapidchain/common"
+)
+
+// TransactionPool keeps the transactions submitted by clients
+type TransactionPool interface {
+	Add(tx common.Transaction) error
+}
+
+// SubmitReply is the reply of a transaction submission
+type SubmitReply struct {
+	Accepted bool
+
+	// Reason of the rejection
+	Reason string
+}
+
+// TxServer serves transaction submissions of clients
+type TxServer struct {
+	pool TransactionPool
+}
+
+func NewTxServer(pool TransactionPool) *TxServer {
+	server := &TxServer{pool: pool}
+	return server
+}
+
+// SubmitTransaction adds a transaction to the mempool of the node
+func (s *TxServer) SubmitTransaction(tx *common.Transaction, reply *SubmitReply) error {
+
+	err := s.pool.Add(*tx)
+	if err != nil {
+		reply.Accepted = false
+		reply.Reason = err.Error()
+		return nil
+	}
+
+	reply.Accepted = true
+
+	return nil
+}
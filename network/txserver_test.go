@@ -0,0 +1,91 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"net"
+	"net/rpc"
+	"testing"
+
+	"github.com/korkmazkadir/rapidchain/common"
+	"github.com/korkmazkadir/rapidchain/mempool"
+)
+
+func TestSubmitTransaction(t *testing.T) {
+
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := mempool.NewMempool(2, 0, mempool.ArrivalOrder)
+
+	// clients submit transactions on the connections of the p2p server
+	server := NewServer(common.NewDemultiplexer(0), memoryChain{})
+	if err := server.RegisterService("TxServer", NewTxServer(pool)); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn)
+		}
+	}()
+
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var transactions []common.Transaction
+	for i := 0; i < 4; i++ {
+		transactions = append(transactions, common.NewTransaction(privateKey, uint64(i), 1, []byte{byte(i)}))
+	}
+
+	committed := transactions[3]
+	pool.Commit([]common.Transaction{committed})
+
+	invalid := transactions[2]
+	invalid.Nonce = 10
+
+	tests := []struct {
+		tx     common.Transaction
+		reason error
+	}{
+		{tx: transactions[0]},
+		{tx: transactions[0], reason: mempool.ErrDuplicateTransaction},
+		{tx: committed, reason: mempool.ErrCommittedTransaction},
+		{tx: invalid, reason: mempool.ErrInvalidTransaction},
+		{tx: transactions[1]},
+		{tx: transactions[2], reason: mempool.ErrMempoolFull},
+	}
+
+	for i, test := range tests {
+		var reply SubmitReply
+		if err := client.Call("TxServer.SubmitTransaction", test.tx, &reply); err != nil {
+			t.Fatal(err)
+		}
+
+		if test.reason == nil && (!reply.Accepted || reply.Reason != "") {
+			t.Errorf("submission %d: expected the transaction to be accepted, rejected with %q", i, reply.Reason)
+		}
+
+		if test.reason != nil && (reply.Accepted || reply.Reason != test.reason.Error()) {
+			t.Errorf("submission %d: expected the rejection %q, got %+v", i, test.reason, reply)
+		}
+	}
+
+	if pool.Len() != 2 {
+		t.Errorf("expected 2 transactions in the mempool, there are %d transactions", pool.Len())
+	}
+}
@@ -3,11 +3,14 @@ package registery
 import (
 	"crypto/sha256"
 	"fmt"
+	"math"
+	"time"
 )
 
 type NodeConfig struct {
 	NodeCount int
 
+	// EpochSeed is the seed of the first epoch, the seeds of the next epochs are derived by the randomness beacon
 	EpochSeed []byte
 
 	EndRound int
@@ -19,11 +22,151 @@ type NodeConfig struct {
 	BlockSize int
 
 	BlockChunkCount int
+
+	// DataChunkCount enables erasure coding if it is set to a value between 0 and BlockChunkCount.
+	// A block can then be reconstructed from any DataChunkCount of its BlockChunkCount chunks.
+	DataChunkCount int
+
+	// MempoolSize is the maximum number of transactions in the mempool, 0 means no limit
+	MempoolSize int
+
+	// MempoolBytes is the maximum total size of transactions in the mempool, 0 means no limit
+	MempoolBytes int
+
+	// MempoolOrdering is either "arrival" or "fee"
+	MempoolOrdering string
+
+	// SyntheticLoad makes leaders fill their blocks with generated transactions if the mempool does not have enough transactions
+	SyntheticLoad bool
+
+	// QueueCapacity is the capacity of the per-round demux queue of each message type, 0 means the default capacity
+	QueueCapacity int
+
+	// QueueOverflowPolicy is applied when a demux queue is full: "drop-newest", "drop-oldest" or "reject"
+	QueueOverflowPolicy string
+
+	// QueueOverflowPolicies overrides QueueOverflowPolicy for the message kinds "chunk", "announcement", "propose", "echo", "accept" and "timeout"
+	QueueOverflowPolicies map[string]string
+
+	// FutureRoundWindow is the number of rounds after the current round whose messages are accepted, 0 means the default window
+	FutureRoundWindow int
+
+	// DedupBackend is the message deduplication backend of the demux: "exact", "lru" or "bloom"
+	DedupBackend string
+
+	// DedupCapacity is the number of message IDs kept by the lru backend, or by each filter generation of the bloom backend
+	DedupCapacity int
+
+	// DedupFalsePositiveRate is the false positive rate of the bloom backend
+	DedupFalsePositiveRate float64
+
+	// VerificationWorkers is the number of workers verifying the received messages, 0 means the number of CPUs
+	VerificationWorkers int
+
+	// VerificationCacheSize is the number of verified messages remembered to skip the verification of their copies, 0 disables the cache
+	VerificationCacheSize int
+
+	// SynchronyBound is the upper bound of the message delay in milliseconds, the phase timeouts are multiples of it.
+	// 0 disables the timeouts, and the nodes wait for all the leaders.
+	SynchronyBound int
+
+	// ProposeTimeout is the number of synchrony bounds to wait for the propose votes of the leaders
+	ProposeTimeout int
+
+	// BlockTimeout is the number of synchrony bounds to wait for the blocks of the proposals
+	BlockTimeout int
+
+	// EchoTimeout is the number of synchrony bounds to wait for a quorum of echo votes before voting to time out the round
+	EchoTimeout int
+
+	// QuorumFraction is the fraction of the validators that a quorum must exceed, 0 means a majority.
+	// It must be at least 0.5 and less than 1, and at least 2/3 if any phase times out.
+	QuorumFraction float64
+
+	// LeaderElection is either "permutation" or "vrf". The permutation elects LeaderCount leaders known in advance.
+	// The VRF sortition elects LeaderCount leaders on average, the leaders prove their election when they propose.
+	// The VRF sortition requires the timeouts, the nodes do not know how many leaders to wait for.
+	LeaderElection string
+
+	// EpochLength is the number of rounds of an epoch. The leaders of an epoch are elected using its seed,
+	// the seed of the next epoch is derived from the beacon proofs of the blocks of the epoch. 0 keeps EpochSeed for all the rounds.
+	// The leaders of the last rounds of an epoch can bias the next seed by withholding their blocks, see common/beacon.go.
+	EpochLength int
+
+	// CommitteeCount is the number of committees, each committee runs its own shard chain. The validators are assigned
+	// to the committees of an epoch using the seed of the epoch, the seeds are derived by the chain of the reference committee 0.
+	// 0 or 1 runs a single committee of all the validators.
+	CommitteeCount int
+}
+
+// PhaseTimeout returns the duration of a phase timeout of the given number of synchrony bounds, 0 means that the phase does not time out
+func (nc NodeConfig) PhaseTimeout(synchronyBounds int) time.Duration {
+	return time.Duration(synchronyBounds*nc.SynchronyBound) * time.Millisecond
+}
+
+// QuorumSize returns the number of distinct validators in a quorum of validatorCount validators
+func (nc NodeConfig) QuorumSize(validatorCount int) int {
+
+	fraction := nc.QuorumFraction
+	if fraction == 0 {
+		fraction = 0.5
+	}
+
+	if fraction < 0.5 || fraction >= 1 {
+		panic(fmt.Errorf("illegal quorum fraction %g", fraction))
+	}
+
+	return int(math.Floor(fraction*float64(validatorCount))) + 1
+}
+
+// Validate checks the quorum fraction against the timeouts.
+// If a phase times out, a round is decided either by a quorum of accept votes or by a quorum of timeout votes.
+// Two such quorums must intersect in more than the Byzantine validators, so that a validator voting both accept
+// and timeout can not make two correct nodes decide differently. This requires quorums of more than 2/3 of the validators.
+func (nc NodeConfig) Validate() error {
+
+	fraction := nc.QuorumFraction
+	if fraction == 0 {
+		fraction = 0.5
+	}
+
+	if fraction < 0.5 || fraction >= 1 {
+		return fmt.Errorf("illegal quorum fraction %g", fraction)
+	}
+
+	timesOut := nc.PhaseTimeout(nc.ProposeTimeout) > 0 || nc.PhaseTimeout(nc.BlockTimeout) > 0 || nc.PhaseTimeout(nc.EchoTimeout) > 0
+	if timesOut && fraction < 2.0/3.0 {
+		return fmt.Errorf("quorum fraction %g is less than 2/3, the phase timeouts require Byzantine quorums", fraction)
+	}
+
+	return nil
+}
+
+// IsShardingEnabled returns true if the validators are assigned to more than one committee
+func (nc NodeConfig) IsShardingEnabled() bool {
+	return nc.CommitteeCount > 1
+}
+
+// IsErasureCodingEnabled returns true if blocks are erasure coded
+func (nc NodeConfig) IsErasureCodingEnabled() bool {
+	return nc.DataChunkCount > 0 && nc.DataChunkCount < nc.BlockChunkCount
+}
+
+// RequiredChunkCount returns the number of chunks required to reconstruct a block
+func (nc NodeConfig) RequiredChunkCount() int {
+	if nc.IsErasureCodingEnabled() {
+		return nc.DataChunkCount
+	}
+	return nc.BlockChunkCount
 }
 
 func (nc NodeConfig) Hash() []byte {
 
-	str := fmt.Sprintf("%d,%x,%d,%d,%d,%d,%d", nc.NodeCount, nc.EpochSeed, nc.EndRound, nc.GossipFanout, nc.LeaderCount, nc.BlockSize, nc.BlockChunkCount)
+	// maps are printed in sorted key order
+	str := fmt.Sprintf("%d,%x,%d,%d,%d,%d,%d,%d,%d,%d,%s,%t,%d,%s,%v,%d,%s,%d,%g,%d,%d,%d,%d,%d,%d,%g,%s,%d,%d", nc.NodeCount, nc.EpochSeed, nc.EndRound, nc.GossipFanout, nc.LeaderCount, nc.BlockSize, nc.BlockChunkCount, nc.DataChunkCount,
+		nc.MempoolSize, nc.MempoolBytes, nc.MempoolOrdering, nc.SyntheticLoad, nc.QueueCapacity, nc.QueueOverflowPolicy, nc.QueueOverflowPolicies, nc.FutureRoundWindow,
+		nc.DedupBackend, nc.DedupCapacity, nc.DedupFalsePositiveRate, nc.VerificationWorkers, nc.VerificationCacheSize,
+		nc.SynchronyBound, nc.ProposeTimeout, nc.BlockTimeout, nc.EchoTimeout, nc.QuorumFraction, nc.LeaderElection, nc.EpochLength, nc.CommitteeCount)
 
 	h := sha256.New()
 	_, err := h.Write([]byte(str))
@@ -43,4 +186,32 @@ func (nc *NodeConfig) CopyFields(cp NodeConfig) {
 	nc.LeaderCount = cp.LeaderCount
 	nc.BlockSize = cp.BlockSize
 	nc.BlockChunkCount = cp.BlockChunkCount
+	nc.DataChunkCount = cp.DataChunkCount
+	nc.MempoolSize = cp.MempoolSize
+	nc.MempoolBytes = cp.MempoolBytes
+	nc.MempoolOrdering = cp.MempoolOrdering
+	nc.SyntheticLoad = cp.SyntheticLoad
+	nc.QueueCapacity = cp.QueueCapacity
+	nc.QueueOverflowPolicy = cp.QueueOverflowPolicy
+	nc.QueueOverflowPolicies = nil
+	if cp.QueueOverflowPolicies != nil {
+		nc.QueueOverflowPolicies = make(map[string]string)
+		for queue, policy := range cp.QueueOverflowPolicies {
+			nc.QueueOverflowPolicies[queue] = policy
+		}
+	}
+	nc.FutureRoundWindow = cp.FutureRoundWindow
+	nc.DedupBackend = cp.DedupBackend
+	nc.DedupCapacity = cp.DedupCapacity
+	nc.DedupFalsePositiveRate = cp.DedupFalsePositiveRate
+	nc.VerificationWorkers = cp.VerificationWorkers
+	nc.VerificationCacheSize = cp.VerificationCacheSize
+	nc.SynchronyBound = cp.SynchronyBound
+	nc.ProposeTimeout = cp.ProposeTimeout
+	nc.BlockTimeout = cp.BlockTimeout
+	nc.EchoTimeout = cp.EchoTimeout
+	nc.QuorumFraction = cp.QuorumFraction
+	nc.LeaderElection = cp.LeaderElection
+	nc.EpochLength = cp.EpochLength
+	nc.CommitteeCount = cp.CommitteeCount
 }
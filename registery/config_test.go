@@ -0,0 +1,48 @@
+package registery
+
+import "testing"
+
+func TestQuorumSize(t *testing.T) {
+
+	tests := []struct {
+		fraction       float64
+		validatorCount int
+		quorumSize     int
+	}{
+		{0, 4, 3},
+		{0, 5, 3},
+		{0.5, 10, 6},
+		{2.0 / 3.0, 4, 3},
+		{2.0 / 3.0, 7, 5},
+		{2.0 / 3.0, 10, 7},
+	}
+
+	for _, test := range tests {
+		config := NodeConfig{QuorumFraction: test.fraction}
+		if quorumSize := config.QuorumSize(test.validatorCount); quorumSize != test.quorumSize {
+			t.Errorf("quorum of %d validators with fraction %g is %d, expected %d", test.validatorCount, test.fraction, quorumSize, test.quorumSize)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		config NodeConfig
+		valid  bool
+	}{
+		{NodeConfig{}, true},
+		{NodeConfig{QuorumFraction: 0.4}, false},
+		{NodeConfig{QuorumFraction: 1}, false},
+		{NodeConfig{SynchronyBound: 1000, EchoTimeout: 10}, false},
+		{NodeConfig{SynchronyBound: 1000, ProposeTimeout: 10, QuorumFraction: 0.5}, false},
+		{NodeConfig{SynchronyBound: 1000, BlockTimeout: 10, QuorumFraction: 2.0 / 3.0}, true},
+		{NodeConfig{EchoTimeout: 10, QuorumFraction: 0.5}, true},
+	}
+
+	for _, test := range tests {
+		if err := test.config.Validate(); (err == nil) != test.valid {
+			t.Errorf("config %+v is expected to be valid %t, got %v", test.config, test.valid, err)
+		}
+	}
+}
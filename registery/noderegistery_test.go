@@ -2,6 +2,7 @@ package registery
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"fmt"
 	"testing"
 )
@@ -18,7 +19,8 @@ func TestRegistry(t *testing.T) {
 	nodeRegistry := NewNodeRegistry(nodeConfig)
 
 	// test register function
-	nodeInfo := &NodeInfo{IPAddress: "abc", PortNumber: 6349}
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	nodeInfo := &NodeInfo{IPAddress: "abc", PortNumber: 6349, PublicKey: publicKey}
 	err := nodeRegistry.Register(nodeInfo, nodeInfo)
 	if err != nil {
 		t.Error(err)
@@ -28,6 +30,28 @@ func TestRegistry(t *testing.T) {
 		t.Error("registery did not assign a node id")
 	}
 
+	// a restarted node registers its public key again from another address, it keeps its node ID
+	restartedNode := &NodeInfo{IPAddress: "abc", PortNumber: 6350, PublicKey: publicKey}
+	reply := &NodeInfo{}
+	if err := nodeRegistry.Register(restartedNode, reply); err != nil {
+		t.Error(err)
+	}
+
+	if reply.ID != nodeInfo.ID || reply.PortNumber != 6350 {
+		t.Errorf("registery did not return the registered node, received %v", reply)
+	}
+	nodeInfo = reply
+
+	// the address of a node can not be registered with another public key
+	anotherKey, _, _ := ed25519.GenerateKey(nil)
+	if nodeRegistry.Register(&NodeInfo{IPAddress: "abc", PortNumber: 6350, PublicKey: anotherKey}, &NodeInfo{}) == nil {
+		t.Error("registery registered an address with another public key")
+	}
+
+	if nodeRegistry.Register(&NodeInfo{IPAddress: "def", PortNumber: 6350}, &NodeInfo{}) == nil {
+		t.Error("registery registered a node without a public key")
+	}
+
 	// test get config function
 	retrievedConfig := &NodeConfig{}
 	err = nodeRegistry.GetConfig(nodeInfo, retrievedConfig)
@@ -1,8 +1,12 @@
 package registery
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,6 +20,9 @@ type NodeInfo struct {
 	ID         int
 	IPAddress  string
 	PortNumber int
+
+	// PublicKey is the ed25519 public key that the node signs its messages with
+	PublicKey []byte
 }
 
 type NodeList struct {
@@ -36,14 +43,41 @@ func NewNodeRegistry(config NodeConfig) *NodeRegistry {
 	return &NodeRegistry{config: config, isTimerRunning: false}
 }
 
-// Register registers a node with specific node info
+// Register registers a node with specific node info.
+// A node that registers its public key again, for instance after a restart, keeps its node ID, and its address is updated.
+// The address of a node can not be registered with another public key.
 func (nr *NodeRegistry) Register(nodeInfo *NodeInfo, reply *NodeInfo) error {
 
 	nr.mutex.Lock()
 	defer nr.mutex.Unlock()
 
-	// assigns a node ID. smallest node ID is 1
-	nodeID := len(nr.registeredNodes) + 1
+	if len(nodeInfo.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key of the node has %d bytes", len(nodeInfo.PublicKey))
+	}
+
+	// smallest node ID is 1, the IDs of the unregistered nodes are not reused
+	nodeID := 1
+	for i := range nr.registeredNodes {
+		registered := &nr.registeredNodes[i]
+		if bytes.Equal(registered.PublicKey, nodeInfo.PublicKey) {
+			registered.IPAddress = nodeInfo.IPAddress
+			registered.PortNumber = nodeInfo.PortNumber
+			log.Printf("node %d registered again; ip address %s port number %d\n", registered.ID, registered.IPAddress, registered.PortNumber)
+
+			*reply = *registered
+			return nil
+		}
+
+		if registered.IPAddress == nodeInfo.IPAddress && registered.PortNumber == nodeInfo.PortNumber {
+			return fmt.Errorf("address %s:%d is already registered by node %d with another public key", nodeInfo.IPAddress, nodeInfo.PortNumber, registered.ID)
+		}
+
+		if registered.ID >= nodeID {
+			nodeID = registered.ID + 1
+		}
+	}
+
+	// assigns a node ID
 	nodeInfo.ID = nodeID
 
 	nr.registeredNodes = append(nr.registeredNodes, *nodeInfo)
@@ -52,6 +86,7 @@ func (nr *NodeRegistry) Register(nodeInfo *NodeInfo, reply *NodeInfo) error {
 	reply.IPAddress = nodeInfo.IPAddress
 	reply.PortNumber = nodeInfo.PortNumber
 	reply.ID = nodeInfo.ID
+	reply.PublicKey = nodeInfo.PublicKey
 
 	return nil
 }
@@ -114,6 +149,21 @@ func (nr *NodeRegistry) GetNodeList(nodeInfo *NodeInfo, nodeList *NodeList) erro
 	return nil
 }
 
+// NewValidatorSet creates the validator set of the registered nodes, the validators are ordered by node ID
+func NewValidatorSet(nodes []NodeInfo) (*common.ValidatorSet, error) {
+
+	var sortedNodes []NodeInfo
+	sortedNodes = append(sortedNodes, nodes...)
+	sort.Slice(sortedNodes, func(i, j int) bool { return sortedNodes[i].ID < sortedNodes[j].ID })
+
+	var publicKeys [][]byte
+	for _, node := range sortedNodes {
+		publicKeys = append(publicKeys, node.PublicKey)
+	}
+
+	return common.NewValidatorSet(publicKeys)
+}
+
 func (nr *NodeRegistry) UploadStats(stats *common.StatList, reply *int) error {
 
 	nr.mutex.Lock()
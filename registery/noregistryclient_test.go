@@ -2,6 +2,7 @@ package registery
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"log"
 	"net"
 	"net/rpc"
@@ -53,7 +54,8 @@ func nodeConfigTestInstance() NodeConfig {
 func TestRegistryClient(t *testing.T) {
 
 	// test register function
-	nodeInfo := NodeInfo{IPAddress: "abc", PortNumber: 6349}
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	nodeInfo := NodeInfo{IPAddress: "abc", PortNumber: 6349, PublicKey: publicKey}
 	registryAddress := "localhost:1234"
 	registryClient := NewRegistryClient(registryAddress, nodeInfo)
 
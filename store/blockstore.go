@@ -0,0 +1,350 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/korkmazkadir/rapidchain/common"
+)
+
+// ErrRoundNotFound is returned if the store does not contain the requested round
+var ErrRoundNotFound = errors.New("round is not in the block store")
+
+// record header: 4 bytes length and 4 bytes CRC32 (Castagnoli) of the record data
+const recordHeaderSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// number of the rounds whose chunks are kept in memory
+const chunkCacheSize = 16
+
+// BlockStore is an append-only on-disk store of decided rounds.
+// Every decided round is written as a single checksummed record and synced to the disk before Append returns.
+// A partially written record at the end of the file, caused by a crash, is discarded when the store is opened.
+type BlockStore struct {
+	mutex sync.Mutex
+
+	file *os.File
+
+	// offsets of the records keyed by round
+	index map[int]int64
+
+	// end of the last valid record
+	size int64
+
+	lastRound int
+
+	readOnly bool
+
+	// data chunks of the blocks with their merkle paths keyed by round, cachedRounds is ordered from the oldest to the newest
+	chunks       map[int][][]common.BlockChunk
+	cachedRounds []int
+}
+
+// Open opens the block store at path, the file is created if it does not exist.
+func Open(path string) (*BlockStore, error) {
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncDirectory(path); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s := &BlockStore{file: file, index: make(map[int]int64), chunks: make(map[int][][]common.BlockChunk)}
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// OpenReadOnly opens an existing block store to read decided rounds, the file is not modified.
+// A partially written record at the end of the file is ignored.
+func OpenReadOnly(path string) (*BlockStore, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BlockStore{file: file, index: make(map[int]int64), chunks: make(map[int][][]common.BlockChunk), readOnly: true}
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append appends a decided round to the store. Rounds must be appended in increasing order.
+func (s *BlockStore) Append(decidedRound common.DecidedRound) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readOnly {
+		return fmt.Errorf("block store is opened read only")
+	}
+
+	if decidedRound.Round <= s.lastRound {
+		return fmt.Errorf("round %d is not after the last stored round %d", decidedRound.Round, s.lastRound)
+	}
+
+	data := bytes.Buffer{}
+	if err := gob.NewEncoder(&data).Encode(decidedRound); err != nil {
+		return err
+	}
+
+	record := make([]byte, recordHeaderSize+data.Len())
+	binary.BigEndian.PutUint32(record[0:4], uint32(data.Len()))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(data.Bytes(), crcTable))
+	copy(record[recordHeaderSize:], data.Bytes())
+
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+		return err
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	s.index[decidedRound.Round] = s.size
+	s.size += int64(len(record))
+	s.lastRound = decidedRound.Round
+
+	return nil
+}
+
+// Get returns the decided round
+func (s *BlockStore) Get(round int) (common.DecidedRound, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	offset, ok := s.index[round]
+	if !ok {
+		return common.DecidedRound{}, ErrRoundNotFound
+	}
+
+	data, err := s.readRecord(offset)
+	if err != nil {
+		return common.DecidedRound{}, err
+	}
+
+	return decodeDecidedRound(data)
+}
+
+// DataChunks returns the data chunks of each block of the round with their merkle paths, the blocks are chunked as their leaders did.
+// The blocks of a round are chunked once, the chunks of the last requested rounds are cached.
+func (s *BlockStore) DataChunks(round int, dataChunkCount int, chunkCount int) ([][]common.BlockChunk, error) {
+
+	if dataChunkCount < 1 || dataChunkCount > chunkCount {
+		return nil, fmt.Errorf("illegal chunk counts %d of %d", dataChunkCount, chunkCount)
+	}
+
+	s.mutex.Lock()
+	chunks, ok := s.chunks[round]
+	s.mutex.Unlock()
+
+	if ok {
+		return chunks, nil
+	}
+
+	decidedRound, err := s.Get(round)
+	if err != nil {
+		return nil, err
+	}
+
+	// the blocks smaller than the chunk count are chunked too, their last chunks are empty
+	chunks = make([][]common.BlockChunk, len(decidedRound.Blocks))
+	for i := range decidedRound.Blocks {
+		blockChunks, _ := common.ChunkBlockWithCounts(decidedRound.Blocks[i], dataChunkCount, chunkCount)
+		chunks[i] = blockChunks[:dataChunkCount]
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.chunks[round]; !ok {
+		s.chunks[round] = chunks
+		s.cachedRounds = append(s.cachedRounds, round)
+	}
+
+	if len(s.cachedRounds) > chunkCacheSize {
+		delete(s.chunks, s.cachedRounds[0])
+		s.cachedRounds = s.cachedRounds[1:]
+	}
+
+	return chunks, nil
+}
+
+// FirstRound returns the first stored round, it returns 0 if the store is empty
+func (s *BlockStore) FirstRound() int {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	firstRound := 0
+	for round := range s.index {
+		if firstRound == 0 || round < firstRound {
+			firstRound = round
+		}
+	}
+
+	return firstRound
+}
+
+// LastRound returns the last stored round, it returns 0 if the store is empty
+func (s *BlockStore) LastRound() int {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lastRound
+}
+
+// Close closes the underlying file
+func (s *BlockStore) Close() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+// load reads all records to construct the index, and truncates a partially written last record.
+// It returns an error if a record before the last one is corrupted, the following rounds are not discarded.
+func (s *BlockStore) load() error {
+
+	for {
+		data, err := s.readRecord(s.size)
+		if err == io.EOF {
+			break
+		}
+
+		var decidedRound common.DecidedRound
+		if err == nil {
+			decidedRound, err = decodeDecidedRound(data)
+		}
+
+		if err != nil {
+			last, lastErr := s.isLastRecord(s.size)
+			if lastErr != nil {
+				return lastErr
+			}
+
+			if !last {
+				return fmt.Errorf("record at offset %d is corrupted, it is followed by other records: %s", s.size, err)
+			}
+
+			log.Printf("discarding the tail of the block store after offset %d: %s\n", s.size, err)
+			break
+		}
+
+		s.index[decidedRound.Round] = s.size
+		s.size += int64(recordHeaderSize + len(data))
+		s.lastRound = decidedRound.Round
+	}
+
+	if s.readOnly {
+		return nil
+	}
+
+	if err := s.file.Truncate(s.size); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+// isLastRecord returns true if the record at offset is the last one, its header is incomplete or its declared length reaches the end of the file
+func (s *BlockStore) isLastRecord(offset int64) (bool, error) {
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if info.Size()-offset < recordHeaderSize {
+		return true, nil
+	}
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := s.file.ReadAt(header, offset); err != nil {
+		return false, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+
+	return offset+recordHeaderSize+int64(length) >= info.Size(), nil
+}
+
+func (s *BlockStore) readRecord(offset int64) ([]byte, error) {
+
+	header := make([]byte, recordHeaderSize)
+	n, err := s.file.ReadAt(header, offset)
+	if n == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read the record header: %s", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(length) > info.Size()-offset-recordHeaderSize {
+		return nil, fmt.Errorf("record length %d exceeds the file size", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := s.file.ReadAt(data, offset+recordHeaderSize); err != nil {
+		return nil, fmt.Errorf("could not read the record data: %s", err)
+	}
+
+	if crc32.Checksum(data, crcTable) != checksum {
+		return nil, fmt.Errorf("record checksum does not match")
+	}
+
+	return data, nil
+}
+
+func decodeDecidedRound(data []byte) (common.DecidedRound, error) {
+
+	decidedRound := common.DecidedRound{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decidedRound)
+
+	return decidedRound, err
+}
+
+// syncDirectory makes the creation of the file durable
+func syncDirectory(path string) error {
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
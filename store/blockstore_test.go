@@ -0,0 +1,205 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/korkmazkadir/rapidchain/common"
+)
+
+func TestBlockStore(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "chain.db")
+
+	blockStore, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blockStore.LastRound() != 0 {
+		t.Errorf("expected an empty store, last round is %d", blockStore.LastRound())
+	}
+
+	for round := 1; round <= 3; round++ {
+		block := common.NewBlock([]byte{1}, []byte{byte(round - 1)}, round, nil)
+		decidedRound := common.DecidedRound{Round: round, Blocks: []common.Block{block}, MerkleRoots: [][]byte{{byte(round)}}}
+		if err := blockStore.Append(decidedRound); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := blockStore.Append(common.DecidedRound{Round: 2}); err == nil {
+		t.Errorf("expected an error because round 2 is already stored")
+	}
+
+	blockStore.Close()
+
+	// simulates a crash in the middle of a write
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	file.Close()
+
+	blockStore, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockStore.Close()
+
+	if blockStore.LastRound() != 3 {
+		t.Errorf("expected last round 3, last round is %d", blockStore.LastRound())
+	}
+
+	decidedRound, err := blockStore.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decidedRound.Round != 2 || len(decidedRound.Blocks) != 1 || !bytes.Equal(decidedRound.MerkleRoots[0], []byte{2}) {
+		t.Errorf("unexpected decided round %+v", decidedRound)
+	}
+
+	if _, err := blockStore.Get(4); err != ErrRoundNotFound {
+		t.Errorf("expected round not found error, received %v", err)
+	}
+
+	// the discarded tail does not prevent appending new rounds
+	if err := blockStore.Append(common.DecidedRound{Round: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := blockStore.Get(4); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCorruptedRecord(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "chain.db")
+
+	blockStore, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 1; round <= 3; round++ {
+		block := common.NewBlock([]byte{1}, []byte{byte(round - 1)}, round, nil)
+		if err := blockStore.Append(common.DecidedRound{Round: round, Blocks: []common.Block{block}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offsets := []int64{blockStore.index[2], blockStore.index[3]}
+	blockStore.Close()
+
+	flipBit := func(offset int64) {
+		file, err := os.OpenFile(path, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		data := make([]byte, 1)
+		if _, err := file.ReadAt(data, offset); err != nil {
+			t.Fatal(err)
+		}
+		data[0] ^= 1
+		if _, err := file.WriteAt(data, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a corrupted record in the middle of the file does not discard the following rounds
+	flipBit(offsets[0] + recordHeaderSize + 10)
+	if _, err := Open(path); err == nil {
+		t.Fatalf("expected an error because round 2 is corrupted")
+	}
+	if _, err := OpenReadOnly(path); err == nil {
+		t.Fatalf("expected an error because round 2 is corrupted")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() <= offsets[1] {
+		t.Fatalf("the rounds after the corrupted record are truncated")
+	}
+
+	// a corrupted last record is discarded
+	flipBit(offsets[0] + recordHeaderSize + 10)
+	flipBit(offsets[1] + recordHeaderSize + 10)
+	blockStore, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockStore.Close()
+
+	if blockStore.LastRound() != 2 {
+		t.Errorf("expected last round 2, last round is %d", blockStore.LastRound())
+	}
+}
+
+func TestDataChunks(t *testing.T) {
+
+	blockStore, err := Open(filepath.Join(t.TempDir(), "chain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockStore.Close()
+
+	for round := 1; round <= chunkCacheSize+1; round++ {
+		block := common.NewBlock([]byte{1}, []byte{byte(round - 1)}, round, nil)
+		_, merkleRoot := common.ChunkBlockWithCounts(block, 4, 6)
+		decidedRound := common.DecidedRound{Round: round, Blocks: []common.Block{block}, MerkleRoots: [][]byte{merkleRoot}}
+		if err := blockStore.Append(decidedRound); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the data chunks carry the merkle paths of the chunks of the leader
+	chunks, err := blockStore.DataChunks(1, 4, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decidedRound, _ := blockStore.Get(1)
+	if len(chunks) != 1 || len(chunks[0]) != 4 {
+		t.Fatalf("expected 4 data chunks of a block, got %d blocks", len(chunks))
+	}
+	for _, c := range chunks[0] {
+		if err := common.VerifyChunk(decidedRound.MerkleRoots[0], c); err != nil {
+			t.Errorf("chunk %d: %s", c.ChunkIndex, err)
+		}
+	}
+
+	for round := 2; round <= chunkCacheSize+1; round++ {
+		if _, err := blockStore.DataChunks(round, 4, 6); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(blockStore.chunks) != chunkCacheSize {
+		t.Errorf("expected the chunks of %d rounds to be cached, %d rounds are cached", chunkCacheSize, len(blockStore.chunks))
+	}
+
+	if _, ok := blockStore.chunks[1]; ok {
+		t.Errorf("expected the chunks of the oldest round to be evicted")
+	}
+
+	if _, err := blockStore.DataChunks(2, 7, 6); err == nil {
+		t.Errorf("expected an error because there are more data chunks than chunks")
+	}
+
+	// the empty blocks are smaller than the chunk count
+	if _, err := blockStore.DataChunks(chunkCacheSize+1, 128, 128); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := blockStore.DataChunks(chunkCacheSize+2, 4, 6); err != ErrRoundNotFound {
+		t.Errorf("expected round not found error, received %v", err)
+	}
+}